@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gabriel-araujjo/condominio-auth/domain"
@@ -15,10 +18,14 @@ import (
 
 // Config stores the app config
 type Config struct {
-	Dao     Dao
-	Clients []*domain.Client
-	Session Session
-	Notary  Notary
+	Dao        Dao
+	Clients    []*domain.Client
+	Session    Session
+	Notary     Notary
+	Mail       Mail
+	SMS        SMS
+	Federation Federation
+	WebAuthn   WebAuthn
 }
 
 // Dao stores config about Dao
@@ -46,13 +53,102 @@ type Session struct {
 
 // Notary stores the Notary's blacklist config
 type Notary struct {
-	TokenStoreType  string
-	TokenStoreURI   string
-	JWTAlgorithm    string
-	JWTVerifyingKey interface{}
-	JWTSigningKey   interface{}
-	// 4, 8, 16 and 32 bytes key
+	// Issuer is the URL identifying this server on the issued tokens,
+	// it is also the base of the end points listed on discovery
+	Issuer         string
+	TokenStoreType string
+	TokenStoreURI  string
+	// SigningKeys are the keys signing and verifying ID tokens, see SigningKey
+	SigningKeys []*SigningKey
+	// 16, 24 and 32 bytes key
 	CodeCipherSecret []byte
+	// AccessTokenTTL is how long an access token lasts
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is how long a refresh token lasts, each rotation
+	// issues a refresh token with a full TTL
+	RefreshTokenTTL time.Duration
+	// CodeTTL is how long an authorization code can be redeemed after issued
+	CodeTTL time.Duration
+	// DeviceCodeTTL is how long a device waits for the user to authorize it
+	DeviceCodeTTL time.Duration
+	// DevicePollingInterval is the minimum time between device polls on the token end point
+	DevicePollingInterval time.Duration
+	// VerificationSecret signs the tokens sent to users proving they own an email
+	VerificationSecret []byte
+	// EmailVerificationTTL is how long an email verification link lasts
+	EmailVerificationTTL time.Duration
+	// PhoneOTPTTL is how long a code sent by SMS lasts
+	PhoneOTPTTL time.Duration
+	// PasswordResetTTL is how long a password reset link lasts
+	PasswordResetTTL time.Duration
+	// OTPMaxAttempts is how many times a code sent by SMS can be typed before it's discarded
+	OTPMaxAttempts int
+}
+
+// Mail stores the config about how emails are sent to users
+type Mail struct {
+	// MailerType is either "smtp" or "memory", the latter only keeps
+	// the messages on memory and it's meant for tests
+	MailerType   string
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	// From is the sender address of the emails
+	From string
+}
+
+// SMS stores the config about how text messages are sent to users
+type SMS struct {
+	// SenderType is either "log" or "memory", both are meant for
+	// development and tests until a SMS gateway is integrated. It must be
+	// set explicitly, so neither is used by accident.
+	SenderType string
+}
+
+// Federation stores the upstream identity providers users can log in with
+type Federation struct {
+	Providers []*IdentityProvider
+}
+
+// WebAuthn stores the relying party passkeys are registered for
+type WebAuthn struct {
+	// RPID is the domain the passkeys are scoped to, the origins must be
+	// on it or on its subdomains
+	RPID   string
+	RPName string
+	// Origins are the pages allowed to register passkeys and log in with them
+	Origins []string
+}
+
+// IdentityProvider is an upstream identity provider, it's reached on the
+// login path /users/login/{Name}
+type IdentityProvider struct {
+	Name string
+	// Type is either "facebook" or "oidc"
+	Type string
+	// Issuer is the OpenID Connect issuer, its discovery document lists the
+	// provider end points. It's ignored by facebook providers.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scope        []string
+}
+
+// SigningKey is a key pair used on ID tokens signatures. The key with the
+// latest PromoteAt already reached signs the new tokens, the remaining ones
+// only verify tokens until they are retired.
+type SigningKey struct {
+	Algorithm string
+	// SigningKey can be nil for keys that only verify tokens
+	SigningKey   interface{}
+	VerifyingKey interface{}
+	// PromoteAt is when the key starts signing tokens. Keys are published
+	// before it, so clients can cache them in advance.
+	PromoteAt time.Time
+	// RetireAt is when the key stops verifying tokens. When zero, the key
+	// is retired once every token it signed has expired after a newer key
+	// has been promoted.
+	RetireAt time.Time
 }
 
 func getEnv(name string, fallback string) (env string) {
@@ -63,11 +159,11 @@ func getEnv(name string, fallback string) (env string) {
 	return
 }
 
-func getSignKey() *rsa.PrivateKey {
-	signBytes, err := ioutil.ReadFile(getEnv("JWT_PRIVATE_KEY_FILE", ""))
+func getSignKey(env string) *rsa.PrivateKey {
+	signBytes, err := ioutil.ReadFile(getEnv(env, ""))
 
 	if err != nil {
-		log.Fatalf("Can't read private key on path %q", getEnv("JWT_PRIVATE_KEY_FILE", ""))
+		log.Fatalf("Can't read private key on path %q", getEnv(env, ""))
 	}
 
 	key, err := jwt.ParseRSAPrivateKeyFromPEM(signBytes)
@@ -78,11 +174,11 @@ func getSignKey() *rsa.PrivateKey {
 	return key
 }
 
-func getVerifyKey() *rsa.PublicKey {
-	verifyBytes, err := ioutil.ReadFile(getEnv("JWT_PUBLIC_KEY_FILE", ""))
+func getVerifyKey(env string) *rsa.PublicKey {
+	verifyBytes, err := ioutil.ReadFile(getEnv(env, ""))
 
 	if err != nil {
-		log.Fatalf("Can't read private key on path %q", getEnv("JWT_PRIVATE_KEY_FILE", ""))
+		log.Fatalf("Can't read public key on path %q", getEnv(env, ""))
 	}
 
 	key, err := jwt.ParseRSAPublicKeyFromPEM(verifyBytes)
@@ -93,6 +189,97 @@ func getVerifyKey() *rsa.PublicKey {
 	return key
 }
 
+// getSigningKeys loads the current key pair and, when configured, the
+// previous verifying key still accepted until JWT_PREVIOUS_KEY_RETIRE_AT
+// and the next key pair to be promoted at JWT_NEXT_KEY_PROMOTE_AT
+func getSigningKeys() []*SigningKey {
+	alg := getEnv("JWT_ALG", "RS512")
+	keys := []*SigningKey{}
+
+	if getEnv("JWT_PREVIOUS_PUBLIC_KEY_FILE", "") != "" {
+		keys = append(keys, &SigningKey{
+			Algorithm:    getEnv("JWT_PREVIOUS_ALG", alg),
+			VerifyingKey: getVerifyKey("JWT_PREVIOUS_PUBLIC_KEY_FILE"),
+			RetireAt:     mustParseTime(getEnv("JWT_PREVIOUS_KEY_RETIRE_AT", "")),
+		})
+	}
+
+	keys = append(keys, &SigningKey{
+		Algorithm:    alg,
+		SigningKey:   getSignKey("JWT_PRIVATE_KEY_FILE"),
+		VerifyingKey: getVerifyKey("JWT_PUBLIC_KEY_FILE"),
+	})
+
+	if getEnv("JWT_NEXT_PRIVATE_KEY_FILE", "") != "" {
+		keys = append(keys, &SigningKey{
+			Algorithm:    getEnv("JWT_NEXT_ALG", alg),
+			SigningKey:   getSignKey("JWT_NEXT_PRIVATE_KEY_FILE"),
+			VerifyingKey: getVerifyKey("JWT_NEXT_PUBLIC_KEY_FILE"),
+			PromoteAt:    mustParseTime(getEnv("JWT_NEXT_KEY_PROMOTE_AT", "")),
+		})
+	}
+	return keys
+}
+
+// getIdentityProviders loads Facebook when FACEBOOK_CLIENT_ID is set and every
+// OpenID Connect provider listed on OIDC_PROVIDERS. The settings of an OIDC
+// provider are read from OIDC_{NAME}_ISSUER, OIDC_{NAME}_CLIENT_ID,
+// OIDC_{NAME}_CLIENT_SECRET and OIDC_{NAME}_SCOPE, where NAME is the provider
+// name in upper case with any other character than letters and digits as "_".
+func getIdentityProviders() []*IdentityProvider {
+	providers := []*IdentityProvider{}
+
+	if getEnv("FACEBOOK_CLIENT_ID", "") != "" {
+		providers = append(providers, &IdentityProvider{
+			Name:         "facebook",
+			Type:         "facebook",
+			ClientID:     getEnv("FACEBOOK_CLIENT_ID", ""),
+			ClientSecret: getEnv("FACEBOOK_CLIENT_SECRET", ""),
+			Scope:        strings.Fields(getEnv("FACEBOOK_SCOPE", "public_profile email")),
+		})
+	}
+
+	for _, name := range strings.Fields(getEnv("OIDC_PROVIDERS", "")) {
+		prefix := "OIDC_" + envName(name) + "_"
+		providers = append(providers, &IdentityProvider{
+			Name:         name,
+			Type:         "oidc",
+			Issuer:       strings.TrimSuffix(getEnv(prefix+"ISSUER", ""), "/"),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			Scope:        strings.Fields(getEnv(prefix+"SCOPE", "openid profile email")),
+		})
+	}
+	return providers
+}
+
+// getWebAuthn loads the relying party from WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME and
+// WEBAUTHN_ORIGINS, by default it's the issuer host with the issuer as origin
+func getWebAuthn(issuer string) WebAuthn {
+	rpID := issuer
+	if parsed, err := url.Parse(issuer); err == nil && parsed.Host != "" {
+		rpID = parsed.Hostname()
+	}
+	return WebAuthn{
+		RPID:    getEnv("WEBAUTHN_RP_ID", rpID),
+		RPName:  getEnv("WEBAUTHN_RP_NAME", "Condominio"),
+		Origins: strings.Fields(getEnv("WEBAUTHN_ORIGINS", issuer)),
+	}
+}
+
+// envName turns a name like "gov.br" into "GOV_BR"
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
 func mustParseInt(intString string) int {
 	value, err := strconv.Atoi(intString)
 	if err != nil {
@@ -101,6 +288,37 @@ func mustParseInt(intString string) int {
 	return value
 }
 
+func mustParseDuration(durationString string) time.Duration {
+	value, err := time.ParseDuration(durationString)
+	if err != nil {
+		panic(fmt.Sprintf("expecting a duration instead of %q", durationString))
+	}
+	return value
+}
+
+func mustParseTime(timeString string) time.Time {
+	value, err := time.Parse(time.RFC3339, timeString)
+	if err != nil {
+		panic(fmt.Sprintf("expecting a RFC 3339 time instead of %q", timeString))
+	}
+	return value
+}
+
+// requireEnv returns the value of env, stopping the app when it's not set
+func requireEnv(env string) string {
+	value := getEnv(env, "")
+	if value == "" {
+		log.Fatalf("%s must be set", env)
+	}
+	return value
+}
+
+// getSecret decodes the hex secret on env. There's no default, a secret known
+// by everyone would let anyone forge what it protects.
+func getSecret(env string) []byte {
+	return mustDecodeHex(requireEnv(env))
+}
+
 func mustDecodeHex(hexString string) []byte {
 	data, err := hex.DecodeString(hexString)
 	if err != nil {
@@ -123,6 +341,9 @@ func DefaultConfig() *Config {
 				Name:     "CondominiumWeb",
 				PublicID: "7p0k9rmAak4",
 				Secret:   "64db530fafdc40759c54e1a520a86d0e13e786b3ba215050dbc870fa781651b6",
+				RedirectURIs: strings.Fields(getEnv("WEB_CLIENT_REDIRECT_URIS",
+					"http://localhost:3000/auth/callback")),
+				Scope: domain.Scope{domain.ScopeRegistration},
 			},
 		},
 		Session: Session{
@@ -134,11 +355,35 @@ func DefaultConfig() *Config {
 				"75625f538a4a5431762b96263e2762fb1cd8af1a3326c4468aaa9a7f336ed0ccf27dfd59167f1dd64aa28074ef87726b0c1f7f7d68fedd6f825e5323dba23280")),
 		},
 		Notary: Notary{
-			TokenStoreType:  getEnv("TOKENSTORE_TYPE", "redis"),
-			TokenStoreURI:   getEnv("TOKENSTORE_URI", "redis:///1"),
-			JWTAlgorithm:    getEnv("JWT_ALG", "RS512"),
-			JWTVerifyingKey: getVerifyKey(),
-			JWTSigningKey:   getSignKey(),
+			Issuer:                strings.TrimSuffix(getEnv("ISSUER", "http://localhost:8080"), "/"),
+			TokenStoreType:        getEnv("TOKENSTORE_TYPE", "redis"),
+			TokenStoreURI:         getEnv("TOKENSTORE_URI", "redis:///1"),
+			SigningKeys:           getSigningKeys(),
+			CodeCipherSecret:      getSecret("CODE_CIPHER_SECRET"),
+			AccessTokenTTL:        mustParseDuration(getEnv("ACCESS_TOKEN_TTL", "1h")),
+			RefreshTokenTTL:       mustParseDuration(getEnv("REFRESH_TOKEN_TTL", "720h")),
+			CodeTTL:               mustParseDuration(getEnv("AUTHORIZATION_CODE_TTL", "10m")),
+			DeviceCodeTTL:         mustParseDuration(getEnv("DEVICE_CODE_TTL", "10m")),
+			DevicePollingInterval: mustParseDuration(getEnv("DEVICE_POLLING_INTERVAL", "5s")),
+			VerificationSecret:    getSecret("VERIFICATION_SECRET"),
+			EmailVerificationTTL:  mustParseDuration(getEnv("EMAIL_VERIFICATION_TTL", "24h")),
+			PhoneOTPTTL:           mustParseDuration(getEnv("PHONE_OTP_TTL", "10m")),
+			PasswordResetTTL:      mustParseDuration(getEnv("PASSWORD_RESET_TTL", "1h")),
+			OTPMaxAttempts:        mustParseInt(getEnv("OTP_MAX_ATTEMPTS", "5")),
+		},
+		Mail: Mail{
+			MailerType:   getEnv("MAILER_TYPE", "smtp"),
+			SMTPAddr:     getEnv("SMTP_ADDR", "localhost:25"),
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+			From:         getEnv("MAIL_FROM", "no-reply@localhost"),
+		},
+		SMS: SMS{
+			SenderType: requireEnv("SMS_SENDER_TYPE"),
+		},
+		Federation: Federation{
+			Providers: getIdentityProviders(),
 		},
+		WebAuthn: getWebAuthn(strings.TrimSuffix(getEnv("ISSUER", "http://localhost:8080"), "/")),
 	}
 }
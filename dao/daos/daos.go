@@ -9,6 +9,7 @@ import (
 type PermissionDao interface {
 	Create(string) error
 	ScopeIntoPermissionIDs(domain.Scope) ([]int64, error)
+	PermissionIDsIntoScope([]int64) (domain.Scope, error)
 }
 
 // ClientDao manage all queries related to clients
@@ -19,6 +20,7 @@ type ClientDao interface {
 	Get(publicID string) (*domain.Client, error)
 	Auth(publicID string, secret string) (pubID string, err error)
 	GetAuthorizedScopesByUser(publicID string, userID int64) domain.Scope
+	GetAuthorizationsByUser(userID int64) ([]*domain.Authorization, error)
 }
 
 // UserDao manage all queries related to users
@@ -28,7 +30,47 @@ type UserDao interface {
 	Update(id int64, patch jp.Patch) error
 	Get(id int64) (*domain.User, error)
 	Authenticate(credential string, password string) (int64, error)
+	// Lookup finds the user id by CPF, email or phone
+	Lookup(credential string) (int64, error)
+	// LookupIdentity finds the user linked to the subject of an upstream identity provider
+	LookupIdentity(provider string, subject string) (int64, error)
+	// LinkIdentity links an account of an upstream identity provider to the user,
+	// setting identity.LinkedAt
+	LinkIdentity(userID int64, identity *domain.Identity) error
+	// UnlinkIdentity removes the link between the user and a provider account
+	UnlinkIdentity(userID int64, provider string, subject string) error
+	// Identities lists the provider accounts linked to the user
+	Identities(userID int64) ([]*domain.Identity, error)
+	// SetTOTPSecret keeps the TOTP secret of the user, it's only required on
+	// login after ConfirmTOTP. A secret not confirmed yet is replaced.
+	SetTOTPSecret(userID int64, secret []byte) error
+	// TOTPSecret returns the TOTP secret of the user, if any, and whether it was confirmed
+	TOTPSecret(userID int64) (secret []byte, confirmed bool, err error)
+	// ConfirmTOTP requires the TOTP secret on login and replaces the recovery codes
+	ConfirmTOTP(userID int64, recoveryCodeHashes [][]byte) error
+	// RedeemRecoveryCode removes the recovery code of the user with the hash
+	RedeemRecoveryCode(userID int64, recoveryCodeHash []byte) error
+	// RemoveTOTP removes the TOTP secret and the recovery codes of the user
+	RemoveTOTP(userID int64) error
+	// AddCredential registers a WebAuthn credential of the user
+	AddCredential(userID int64, credential *domain.Credential) error
+	// LookupCredential returns a credential by its id and the user owning it
+	LookupCredential(credentialID []byte) (int64, *domain.Credential, error)
+	// UseCredential keeps the signature counter of a credential used to log
+	// in, it fails when the counter didn't increase
+	UseCredential(credentialID []byte, signCount uint32) error
+	// RemoveCredential removes a WebAuthn credential of the user
+	RemoveCredential(userID int64, credentialID []byte) error
+	// Credentials lists the WebAuthn credentials of the user
+	Credentials(userID int64) ([]*domain.Credential, error)
+	// SetPassword replaces the password of the user
+	SetPassword(userID int64, password string) error
+	// VerifyEmail marks an email of the user as verified
+	VerifyEmail(userID int64, email string) error
+	// VerifyPhone marks a phone of the user as verified
+	VerifyPhone(userID int64, phone string) error
 	AuthorizeClient(userID int64, clientPublicID string, scope domain.Scope) error
+	RevokeClient(userID int64, clientPublicID string) error
 	//GetAuthorizedScopeForClient(clientPublicID string) []domain.Permission
 }
 
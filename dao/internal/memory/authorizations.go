@@ -0,0 +1,41 @@
+package memory
+
+import "github.com/gabriel-araujjo/condominio-auth/domain"
+
+// authorizationsMemory keeps the scopes each user granted to each client,
+// it's shared by the user and the client daos
+type authorizationsMemory struct {
+	scopes map[int64]map[string]domain.Scope
+}
+
+func newAuthorizationsMemory() *authorizationsMemory {
+	return &authorizationsMemory{scopes: map[int64]map[string]domain.Scope{}}
+}
+
+// authorize adds scope to the scopes already granted
+func (a *authorizationsMemory) authorize(userID int64, clientPublicID string, scope domain.Scope) {
+	clients, ok := a.scopes[userID]
+	if !ok {
+		clients = map[string]domain.Scope{}
+		a.scopes[userID] = clients
+	}
+	granted := clients[clientPublicID]
+	for _, s := range scope {
+		if !granted.HasSubscope(domain.Scope{s}) {
+			granted = append(granted, s)
+		}
+	}
+	clients[clientPublicID] = granted
+}
+
+func (a *authorizationsMemory) scope(userID int64, clientPublicID string) domain.Scope {
+	return a.scopes[userID][clientPublicID]
+}
+
+func (a *authorizationsMemory) revoke(userID int64, clientPublicID string) bool {
+	if _, ok := a.scopes[userID][clientPublicID]; !ok {
+		return false
+	}
+	delete(a.scopes[userID], clientPublicID)
+	return true
+}
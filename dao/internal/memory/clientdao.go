@@ -1,33 +1,106 @@
 package memory
 
 import (
+	"errors"
+
+	"github.com/gabriel-araujjo/base62"
 	"github.com/gabriel-araujjo/condominio-auth/domain"
 )
 
 type clientDaoMemory struct {
+	clients        []*domain.Client
+	authorizations *authorizationsMemory
+}
+
+func newClientDaoMemory(clients []*domain.Client, authorizations *authorizationsMemory) *clientDaoMemory {
+	d := &clientDaoMemory{authorizations: authorizations}
+	for _, c := range clients {
+		registered := *c
+		registered.ID = 0
+		d.Create(&registered)
+	}
+	return d
 }
 
-func (d *clientDaoMemory) Create(u *domain.Client) error {
-	panic("implement me")
+func (d *clientDaoMemory) find(publicID string) int {
+	for i, c := range d.clients {
+		if c != nil && c.PublicID == publicID {
+			return i
+		}
+	}
+	return -1
 }
 
-func (d *clientDaoMemory) Delete(u *domain.Client) error {
-	panic("implement me")
+func (d *clientDaoMemory) Create(c *domain.Client) error {
+	if c == nil {
+		return errors.New("memory_clientdao: trying to create a nil client")
+	}
+	if c.ID != 0 {
+		return errors.New("memory_clientdao: client already created")
+	}
+	if c.PublicID != "" && d.find(c.PublicID) != -1 {
+		return errors.New("memory_clientdao: duplicate public id")
+	}
+	d.clients = append(d.clients, c)
+	c.ID = int64(len(d.clients))
+	if c.PublicID == "" {
+		c.PublicID = base62.FormatUint(uint64(c.ID))
+	}
+	return nil
 }
 
-func (d *clientDaoMemory) Update(u *domain.Client) error {
-	panic("implement me")
+func (d *clientDaoMemory) Delete(c *domain.Client) error {
+	i := d.find(c.PublicID)
+	if i == -1 {
+		return errors.New("memory_clientdao: no client was deleted")
+	}
+	d.clients[i] = nil
+	return nil
+}
+
+func (d *clientDaoMemory) Update(c *domain.Client) error {
+	i := d.find(c.PublicID)
+	if i == -1 {
+		return errors.New("memory_clientdao: no client found")
+	}
+	c.ID = d.clients[i].ID
+	d.clients[i] = c
+	return nil
 }
 
 func (d *clientDaoMemory) Get(publicID string) (*domain.Client, error) {
-	panic("implement me")
+	i := d.find(publicID)
+	if i == -1 {
+		return nil, errors.New("memory_clientdao: no client found")
+	}
+	return d.clients[i], nil
 }
 
 func (d *clientDaoMemory) Auth(publicID string, secret string) (string, error) {
-	panic("implement me")
+	c, err := d.Get(publicID)
+	if err != nil || c.Secret != secret {
+		return "", errors.New("memory_clientdao: unauthorized client")
+	}
+	return c.PublicID, nil
 }
 
 func (d *clientDaoMemory) GetAuthorizedScopesByUser(publicID string, userID int64) domain.Scope {
-	//TODO:
-	return nil
+	return d.authorizations.scope(userID, publicID)
+}
+
+func (d *clientDaoMemory) GetAuthorizationsByUser(userID int64) ([]*domain.Authorization, error) {
+	authorizations := []*domain.Authorization{}
+	for _, c := range d.clients {
+		if c == nil {
+			continue
+		}
+		if scope := d.authorizations.scope(userID, c.PublicID); len(scope) != 0 {
+			authorizations = append(authorizations, &domain.Authorization{
+				ClientID:   c.PublicID,
+				ClientName: c.Name,
+				Scope:      scope,
+			})
+		}
+	}
+	return authorizations, nil
 }
@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gabriel-araujjo/condominio-auth/config"
+	"github.com/gabriel-araujjo/condominio-auth/domain"
+)
+
+func TestClientDaoMemory(t *testing.T) {
+	conf := &config.Config{
+		Clients: []*domain.Client{{
+			Name:         "Web",
+			PublicID:     "7p0k9rmAak4",
+			Secret:       "secret",
+			RedirectURIs: []string{"https://condominio.com/callback"},
+		}},
+	}
+	userDao, clientDao, _, _ := NewDao(conf)
+
+	t.Run("Get", func(t *testing.T) {
+		c, err := clientDao.Get("7p0k9rmAak4")
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if c.ID <= 0 {
+			t.Error("should an ID be set on client")
+		}
+		if !reflect.DeepEqual(c.RedirectURIs, conf.Clients[0].RedirectURIs) {
+			t.Errorf("expecting redirect uris %q instead of %q", conf.Clients[0].RedirectURIs, c.RedirectURIs)
+		}
+		if !c.HasRedirectURI("https://condominio.com/callback") {
+			t.Error("registered redirect uri should match")
+		}
+		if c.HasRedirectURI("https://condominio.com/callback?next=https://evil.com") {
+			t.Error("only exact redirect uris should match")
+		}
+	})
+
+	t.Run("InvalidClient", func(t *testing.T) {
+		c, err := clientDao.Get("-1")
+		if err == nil {
+			t.Error("expecting error, but nil was returned")
+		}
+		if c != nil {
+			t.Errorf("invalid client must be nil, instead of %#v", c)
+		}
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		c := &domain.Client{Name: "Mobile", RedirectURIs: []string{"condominio://callback"}}
+		if err := clientDao.Create(c); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if c.PublicID == "" {
+			t.Error("should a public id be set on client")
+		}
+		if err := clientDao.Create(c); err == nil {
+			t.Error("creating a client twice should fail")
+		}
+	})
+
+	t.Run("Auth", func(t *testing.T) {
+		if _, err := clientDao.Auth("7p0k9rmAak4", "secret"); err != nil {
+			t.Errorf("unexpected error %q", err)
+		}
+		if _, err := clientDao.Auth("7p0k9rmAak4", "wrong"); err == nil {
+			t.Error("wrong secret should fail")
+		}
+	})
+
+	t.Run("AuthorizedScopes", func(t *testing.T) {
+		user := &domain.User{Name: "Resident"}
+		userDao.Create(user)
+
+		if scope := clientDao.GetAuthorizedScopesByUser("7p0k9rmAak4", user.ID); len(scope) != 0 {
+			t.Errorf("no scope should be authorized yet instead of %q", scope)
+		}
+
+		userDao.AuthorizeClient(user.ID, "7p0k9rmAak4", domain.Scope{"openid", "email"})
+		userDao.AuthorizeClient(user.ID, "7p0k9rmAak4", domain.Scope{"email", "phone"})
+
+		scope := clientDao.GetAuthorizedScopesByUser("7p0k9rmAak4", user.ID)
+		if !reflect.DeepEqual(scope, domain.Scope{"openid", "email", "phone"}) {
+			t.Errorf("authorized scopes should be merged instead of %q", scope)
+		}
+		if err := userDao.AuthorizeClient(user.ID+1, "7p0k9rmAak4", domain.Scope{"openid"}); err == nil {
+			t.Error("authorizing an unknown user should fail")
+		}
+
+		authorizations, _ := clientDao.GetAuthorizationsByUser(user.ID)
+		if len(authorizations) != 1 || authorizations[0].ClientName != "Web" {
+			t.Errorf("expecting the Web client authorization instead of %v", authorizations)
+		}
+
+		if err := userDao.RevokeClient(user.ID, "7p0k9rmAak4"); err != nil {
+			t.Errorf("unexpected error %q", err)
+		}
+		if authorizations, _ = clientDao.GetAuthorizationsByUser(user.ID); len(authorizations) != 0 {
+			t.Errorf("revoked client should not be listed instead of %v", authorizations)
+		}
+		if err := userDao.RevokeClient(user.ID, "7p0k9rmAak4"); err == nil {
+			t.Error("revoking a client twice should fail")
+		}
+	})
+}
@@ -3,9 +3,16 @@ package memory
 import (
 	"github.com/gabriel-araujjo/condominio-auth/config"
 	"github.com/gabriel-araujjo/condominio-auth/dao/daos"
+	"github.com/gabriel-araujjo/condominio-auth/domain"
 )
 
 // NewDao create a dao in memory
 func NewDao(conf *config.Config) (daos.UserDao, daos.ClientDao, daos.PermissionDao, error) {
-	return &userDaoMemory{}, &clientDaoMemory{}, &permissionDaoMemory{}, nil
+	var clients []*domain.Client
+	if conf != nil {
+		clients = conf.Clients
+	}
+	authorizations := newAuthorizationsMemory()
+	return &userDaoMemory{authorizations: authorizations}, newClientDaoMemory(clients, authorizations),
+		newPermissionDaoMemory(), nil
 }
@@ -6,21 +6,28 @@ import (
 	"github.com/gabriel-araujjo/condominio-auth/domain"
 )
 
+// permissionDaoMemory holds the scope names, their ids are their indexes
 type permissionDaoMemory []string
 
-func (p permissionDaoMemory) Create(permission string) error {
+// newPermissionDaoMemory starts with the scopes advertised on discovery, as the postgres scheme
+func newPermissionDaoMemory() *permissionDaoMemory {
+	p := append(permissionDaoMemory{}, domain.OpenIDScopes...)
+	return &p
+}
+
+func (p *permissionDaoMemory) Create(permission string) error {
 	id, _ := p.ScopeIntoPermissionIDs([]string{permission})
 	if len(id) > 0 {
 		return errors.New("duplicate permission")
 	}
-	p = append(p)
+	*p = append(*p, permission)
 	return nil
 }
 
-func (p permissionDaoMemory) ScopeIntoPermissionIDs(scope domain.Scope) ([]int64, error) {
+func (p *permissionDaoMemory) ScopeIntoPermissionIDs(scope domain.Scope) ([]int64, error) {
 	permissions := make([]int64, 0, len(scope))
 	for _, s := range scope {
-		for i, name := range p {
+		for i, name := range *p {
 			if s == name {
 				permissions = append(permissions, int64(i))
 			}
@@ -28,3 +35,14 @@ func (p permissionDaoMemory) ScopeIntoPermissionIDs(scope domain.Scope) ([]int64
 	}
 	return permissions, nil
 }
+
+func (p *permissionDaoMemory) PermissionIDsIntoScope(ids []int64) (domain.Scope, error) {
+	scope := make(domain.Scope, 0, len(ids))
+	for _, id := range ids {
+		if id < 0 || id >= int64(len(*p)) {
+			return nil, errors.New("unknown permission")
+		}
+		scope = append(scope, (*p)[id])
+	}
+	return scope, nil
+}
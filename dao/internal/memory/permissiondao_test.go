@@ -0,0 +1,38 @@
+package memory
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gabriel-araujjo/condominio-auth/domain"
+)
+
+func TestPermissionDaoMemory(t *testing.T) {
+	_, _, permissionDao, _ := NewDao(nil)
+
+	t.Run("OpenIDScopes", func(t *testing.T) {
+		ids, err := permissionDao.ScopeIntoPermissionIDs(domain.OpenIDScopes)
+		if err != nil || len(ids) != len(domain.OpenIDScopes) {
+			t.Fatalf("every OpenID scope should be known instead of %v (err %v)", ids, err)
+		}
+		scope, err := permissionDao.PermissionIDsIntoScope(ids)
+		if err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+		if !reflect.DeepEqual(scope, domain.OpenIDScopes) {
+			t.Errorf("scope should be %v instead of %v", domain.OpenIDScopes, scope)
+		}
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		if err := permissionDao.Create("condominium"); err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+		if ids, _ := permissionDao.ScopeIntoPermissionIDs(domain.Scope{"condominium"}); len(ids) != 1 {
+			t.Errorf("created scope should be known instead of %v", ids)
+		}
+		if err := permissionDao.Create("condominium"); err == nil {
+			t.Error("duplicated scope should return an error")
+		}
+	})
+}
@@ -1,10 +1,12 @@
 package memory
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/gabriel-araujjo/condominio-auth/domain"
 	"github.com/gabriel-araujjo/go-jsonpointer"
@@ -13,6 +15,14 @@ import (
 
 type userTailor struct{}
 
+func stringValue(value interface{}) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("memory_userdao: expecting a string instead of %v", value)
+	}
+	return s, nil
+}
+
 func (userTailor) Add(obj interface{}, path string, value interface{}) error {
 
 	pointer, err := jsonpointer.NewJSONPointerFromString(path)
@@ -28,19 +38,24 @@ func (userTailor) Add(obj interface{}, path string, value interface{}) error {
 
 	//TODO: Use reflections
 
+	if pointer.Tokens()[0] == "id" {
+		return errors.New("memory_userdao: can't edit user id")
+	}
+
+	s, err := stringValue(value)
+	if err != nil {
+		return err
+	}
+
 	switch pointer.Tokens()[0] {
 	default:
 		return fmt.Errorf("memory_userdao: invalid path %q", path)
-	case "id":
-		return errors.New("memory_userdao: can't edit user id")
 	case "name":
-		user.Name = value.(string)
+		user.Name = s
 	case "cpf":
-		user.CPF = value.(string)
-	case "fb_id":
-		user.FbID = value.(string)
+		user.CPF = s
 	case "avatar":
-		url, err := url.Parse(value.(string))
+		url, err := url.Parse(s)
 		if err != nil {
 			return err
 		}
@@ -50,13 +65,15 @@ func (userTailor) Add(obj interface{}, path string, value interface{}) error {
 		if err != nil {
 			return err
 		}
-		// TODO: check whether phone already exists
+		if phoneIndex(user.Phones, s) >= 0 {
+			return fmt.Errorf("memory_userdao: phone %q already added", s)
+		}
 		user.Phones = append(
 			user.Phones[:idx],
 			append(
 				[]domain.Phone{
 					{
-						Phone:    value.(string),
+						Phone:    s,
 						Verified: false,
 					},
 				},
@@ -68,13 +85,15 @@ func (userTailor) Add(obj interface{}, path string, value interface{}) error {
 		if err != nil {
 			return err
 		}
-		// TODO: check whether phone already exists
+		if emailIndex(user.Emails, s) >= 0 {
+			return fmt.Errorf("memory_userdao: email %q already added", s)
+		}
 		user.Emails = append(
 			user.Emails[:idx],
 			append(
 				[]domain.Email{
 					{
-						Email:    value.(string),
+						Email:    s,
 						Verified: false,
 					},
 				},
@@ -82,7 +101,7 @@ func (userTailor) Add(obj interface{}, path string, value interface{}) error {
 			)...,
 		)
 	case "password":
-		user.PasswordHash = value.(string)
+		user.PasswordHash = s
 	}
 	return nil
 }
@@ -108,111 +127,474 @@ func (userTailor) Remove(obj interface{}, path string) error {
 		user.Name = ""
 	case "cpf":
 		user.CPF = ""
-	case "fb_id":
-		user.FbID = ""
 	case "avatar":
 		user.Avatar = nil
 	case "phones":
-		idx, err := getIndex(pointer, len(user.Phones))
-		if err != nil || idx == len(user.Phones) {
-			if pointer.Depth() != 1 {
-				return err
-			}
+		if pointer.Depth() == 1 {
 			// clear all phones
 			user.Phones = nil
 			return nil
 		}
-		// TODO: check whether phone already exists
+		idx, err := getIndex(pointer, len(user.Phones)-1)
+		if err != nil {
+			return err
+		}
 		user.Phones = append(user.Phones[:idx], user.Phones[idx+1:]...)
 	case "emails":
-		idx, err := getIndex(pointer, len(user.Emails))
-		if err != nil || idx == len(user.Emails) {
-			if pointer.Depth() != 1 {
-				return err
-			}
-			// clear all phones
-			user.Phones = nil
+		if pointer.Depth() == 1 {
+			// clear all emails
+			user.Emails = nil
 			return nil
 		}
-		// TODO: check whether phone already exists
-		user.Phones = append(user.Phones[:idx], user.Phones[idx+1:]...)
+		idx, err := getIndex(pointer, len(user.Emails)-1)
+		if err != nil {
+			return err
+		}
+		user.Emails = append(user.Emails[:idx], user.Emails[idx+1:]...)
 	}
 	return nil
 }
 
+// Move reorders the phones or emails, the first ones are the primary ones
 func (userTailor) Move(obj interface{}, path string, from uint64, to uint64) error {
-	panic("implement me")
+	user := obj.(*domain.User)
+
+	switch path {
+	default:
+		return fmt.Errorf("memory_userdao: can't move on path %q", path)
+	case "/phones":
+		if from >= uint64(len(user.Phones)) || to >= uint64(len(user.Phones)) {
+			return fmt.Errorf("memory_userdao: out of range move from %d to %d", from, to)
+		}
+		phone := user.Phones[from]
+		user.Phones = append(user.Phones[:from], user.Phones[from+1:]...)
+		user.Phones = append(user.Phones[:to], append([]domain.Phone{phone}, user.Phones[to:]...)...)
+	case "/emails":
+		if from >= uint64(len(user.Emails)) || to >= uint64(len(user.Emails)) {
+			return fmt.Errorf("memory_userdao: out of range move from %d to %d", from, to)
+		}
+		email := user.Emails[from]
+		user.Emails = append(user.Emails[:from], user.Emails[from+1:]...)
+		user.Emails = append(user.Emails[:to], append([]domain.Email{email}, user.Emails[to:]...)...)
+	}
+	return nil
 }
 
-func (userTailor) Replace(obj interface{}, path string, value interface{}) error {
-	panic("implement me")
+// Replace sets a field, replaced phones and emails aren't verified unless they keep their value
+func (t userTailor) Replace(obj interface{}, path string, value interface{}) error {
+	pointer, err := jsonpointer.NewJSONPointerFromString(path)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case pointer.Depth() == 2 && (pointer.Tokens()[0] == "phones" || pointer.Tokens()[0] == "emails"):
+		if pointer.Tokens()[1] == "-" {
+			return fmt.Errorf("memory_userdao: invalid path %q", path)
+		}
+		if sameEntry(obj.(*domain.User), pointer, value) {
+			return nil
+		}
+		if err = t.Remove(obj, path); err != nil {
+			return err
+		}
+		return t.Add(obj, path, value)
+	case pointer.Depth() == 1:
+		return t.Add(obj, path, value)
+	}
+	return fmt.Errorf("memory_userdao: invalid path %q", path)
 }
 
-type userDaoMemory []*domain.User
+// sameEntry tells whether value is already the phone or email on pointer,
+// replacing it would only lose its verified flag
+func sameEntry(user *domain.User, pointer *jsonpointer.JSONPointer, value interface{}) bool {
+	s, ok := value.(string)
+	i, err := strconv.Atoi(pointer.Tokens()[1])
+	if !ok || err != nil || i < 0 {
+		return false
+	}
+	switch pointer.Tokens()[0] {
+	case "phones":
+		return i < len(user.Phones) && user.Phones[i].Phone == s
+	case "emails":
+		return i < len(user.Emails) && user.Emails[i].Email == s
+	}
+	return false
+}
+
+func phoneIndex(phones []domain.Phone, phone string) int {
+	for i := range phones {
+		if phones[i].Phone == phone {
+			return i
+		}
+	}
+	return -1
+}
+
+func emailIndex(emails []domain.Email, email string) int {
+	for i := range emails {
+		if emails[i].Email == email {
+			return i
+		}
+	}
+	return -1
+}
+
+// userIdentity is an identity linked to the user identified by userID
+type userIdentity struct {
+	domain.Identity
+	userID int64
+}
+
+// userTOTP is the TOTP secret of a user and the hashes of its recovery codes
+type userTOTP struct {
+	secret        []byte
+	confirmed     bool
+	recoveryCodes []string
+}
+
+// userCredential is a WebAuthn credential of the user identified by userID
+type userCredential struct {
+	domain.Credential
+	userID int64
+}
+
+type userDaoMemory struct {
+	users          []*domain.User
+	identities     []*userIdentity
+	totps          map[int64]*userTOTP
+	credentials    []*userCredential
+	authorizations *authorizationsMemory
+}
 
 func (d *userDaoMemory) Create(u *domain.User) error {
 	if u == nil {
 		return errors.New("memory_userdao: trying to create a nil user")
 	}
-	*d = append(*d, u)
-	u.ID = int64(len(*d))
+	d.users = append(d.users, u)
+	u.ID = int64(len(d.users))
 	return nil
 }
 
 func (d *userDaoMemory) Delete(id int64) error {
-	if id <= 0 || id > int64(len(*d)) || (*d)[id-1] == nil {
+	if id <= 0 || id > int64(len(d.users)) || d.users[id-1] == nil {
 		return errors.New("memory_userdao: no user was deleted")
 	}
-	(*d)[id-1] = nil
+	d.users[id-1] = nil
+
+	identities := d.identities[:0]
+	for _, identity := range d.identities {
+		if identity.userID != id {
+			identities = append(identities, identity)
+		}
+	}
+	d.identities = identities
+	delete(d.totps, id)
+
+	credentials := d.credentials[:0]
+	for _, credential := range d.credentials {
+		if credential.userID != id {
+			credentials = append(credentials, credential)
+		}
+	}
+	d.credentials = credentials
 	return nil
 }
 
 func (d *userDaoMemory) Update(id int64, patch json_patcher.Patch) error {
-	if id <= 0 || id > int64(len(*d)) || (*d)[id-1] == nil {
+	if id <= 0 || id > int64(len(d.users)) || d.users[id-1] == nil {
 		return errors.New("memory_userdao: no user found")
 	}
-	return json_patcher.Mend(nil, patch, (*d)[id-1])
+	// the patch is applied on a copy, so a failing operation changes nothing
+	user := *d.users[id-1]
+	user.Phones = append([]domain.Phone(nil), user.Phones...)
+	user.Emails = append([]domain.Email(nil), user.Emails...)
+	if err := json_patcher.Mend(userTailor{}, patch, &user); err != nil {
+		return err
+	}
+	d.users[id-1] = &user
+	return nil
 }
 
 func (d *userDaoMemory) Get(id int64) (*domain.User, error) {
-	if id <= 0 || id > int64(len(*d)) {
+	if id <= 0 || id > int64(len(d.users)) || d.users[id-1] == nil {
 		return nil, errors.New("memory_userdao: no user found")
 	}
-	return (*d)[id-1], nil
+	return d.users[id-1], nil
 }
 
 func (d *userDaoMemory) Authenticate(credential string, password string) (int64, error) {
 	var user *domain.User
-	for i := range *d {
-		if (*d)[i].CPF == credential {
-			user = (*d)[i]
+	for i := range d.users {
+		if d.users[i] == nil {
+			continue
+		}
+		if d.users[i].CPF == credential {
+			user = d.users[i]
 			break
 		}
 
-		for j := range (*d)[i].Emails {
-			if (*d)[i].Emails[j].Email == credential {
-				user = (*d)[i]
+		for j := range d.users[i].Emails {
+			if d.users[i].Emails[j].Email == credential {
+				user = d.users[i]
 				break
 			}
 		}
 
-		for j := range (*d)[i].Phones {
-			if (*d)[i].Phones[j].Phone == credential {
-				user = (*d)[i]
+		for j := range d.users[i].Phones {
+			if d.users[i].Phones[j].Phone == credential {
+				user = d.users[i]
 				break
 			}
 		}
 	}
 
-	if user == nil || user.PasswordHash != password {
+	if user == nil || user.PasswordHash == "" || user.PasswordHash != password {
 		return -1, errors.New("memory_userdao: authentication failed")
 	}
 
 	return user.ID, nil
 }
 
+func (d *userDaoMemory) Lookup(credential string) (int64, error) {
+	for _, user := range d.users {
+		if user == nil {
+			continue
+		}
+		if user.CPF == credential {
+			return user.ID, nil
+		}
+		for _, email := range user.Emails {
+			if email.Email == credential {
+				return user.ID, nil
+			}
+		}
+		for _, phone := range user.Phones {
+			if phone.Phone == credential {
+				return user.ID, nil
+			}
+		}
+	}
+	return 0, errors.New("memory_userdao: user not found")
+}
+
+func (d *userDaoMemory) LookupIdentity(provider string, subject string) (int64, error) {
+	for _, identity := range d.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity.userID, nil
+		}
+	}
+	return 0, errors.New("memory_userdao: user not found")
+}
+
+func (d *userDaoMemory) LinkIdentity(userID int64, identity *domain.Identity) error {
+	if identity == nil || identity.Provider == "" || identity.Subject == "" {
+		return errors.New("memory_userdao: invalid identity")
+	}
+	user, err := d.Get(userID)
+	if err != nil || user == nil {
+		return errors.New("memory_userdao: no user found")
+	}
+
+	for _, linked := range d.identities {
+		if linked.Provider != identity.Provider || linked.Subject != identity.Subject {
+			continue
+		}
+		if linked.userID != userID {
+			return errors.New("memory_userdao: identity linked to another user")
+		}
+		linked.Email = identity.Email
+		identity.LinkedAt = linked.LinkedAt
+		return nil
+	}
+
+	identity.LinkedAt = time.Now()
+	d.identities = append(d.identities, &userIdentity{*identity, userID})
+	return nil
+}
+
+func (d *userDaoMemory) UnlinkIdentity(userID int64, provider string, subject string) error {
+	for i, identity := range d.identities {
+		if identity.userID == userID && identity.Provider == provider && identity.Subject == subject {
+			d.identities = append(d.identities[:i], d.identities[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("memory_userdao: identity not linked")
+}
+
+func (d *userDaoMemory) Identities(userID int64) ([]*domain.Identity, error) {
+	identities := []*domain.Identity{}
+	for _, identity := range d.identities {
+		if identity.userID == userID {
+			linked := identity.Identity
+			identities = append(identities, &linked)
+		}
+	}
+	return identities, nil
+}
+
+func (d *userDaoMemory) SetTOTPSecret(userID int64, secret []byte) error {
+	if user, err := d.Get(userID); err != nil || user == nil {
+		return errors.New("memory_userdao: no user found")
+	}
+	if totp, ok := d.totps[userID]; ok && totp.confirmed {
+		return errors.New("memory_userdao: totp already confirmed")
+	}
+	if d.totps == nil {
+		d.totps = map[int64]*userTOTP{}
+	}
+	d.totps[userID] = &userTOTP{secret: append([]byte(nil), secret...)}
+	return nil
+}
+
+func (d *userDaoMemory) TOTPSecret(userID int64) ([]byte, bool, error) {
+	totp, ok := d.totps[userID]
+	if !ok {
+		return nil, false, nil
+	}
+	return totp.secret, totp.confirmed, nil
+}
+
+func (d *userDaoMemory) ConfirmTOTP(userID int64, recoveryCodeHashes [][]byte) error {
+	totp, ok := d.totps[userID]
+	if !ok || totp.confirmed {
+		return errors.New("memory_userdao: no totp waiting confirmation")
+	}
+	totp.confirmed = true
+	totp.recoveryCodes = nil
+	for _, hash := range recoveryCodeHashes {
+		totp.recoveryCodes = append(totp.recoveryCodes, string(hash))
+	}
+	return nil
+}
+
+func (d *userDaoMemory) RedeemRecoveryCode(userID int64, recoveryCodeHash []byte) error {
+	if totp, ok := d.totps[userID]; ok {
+		for i, hash := range totp.recoveryCodes {
+			if hash == string(recoveryCodeHash) {
+				totp.recoveryCodes = append(totp.recoveryCodes[:i], totp.recoveryCodes[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return errors.New("memory_userdao: invalid recovery code")
+}
+
+func (d *userDaoMemory) RemoveTOTP(userID int64) error {
+	delete(d.totps, userID)
+	return nil
+}
+
+func (d *userDaoMemory) AddCredential(userID int64, credential *domain.Credential) error {
+	if credential == nil || len(credential.ID) == 0 || len(credential.PublicKey) == 0 {
+		return errors.New("memory_userdao: invalid credential")
+	}
+	if user, err := d.Get(userID); err != nil || user == nil {
+		return errors.New("memory_userdao: no user found")
+	}
+	if _, _, err := d.LookupCredential(credential.ID); err == nil {
+		return errors.New("memory_userdao: credential already registered")
+	}
+
+	credential.CreatedAt = time.Now()
+	d.credentials = append(d.credentials, &userCredential{*credential, userID})
+	return nil
+}
+
+func (d *userDaoMemory) LookupCredential(credentialID []byte) (int64, *domain.Credential, error) {
+	for _, credential := range d.credentials {
+		if bytes.Equal(credential.ID, credentialID) {
+			found := credential.Credential
+			return credential.userID, &found, nil
+		}
+	}
+	return 0, nil, errors.New("memory_userdao: credential not found")
+}
+
+func (d *userDaoMemory) UseCredential(credentialID []byte, signCount uint32) error {
+	for _, credential := range d.credentials {
+		if !bytes.Equal(credential.ID, credentialID) {
+			continue
+		}
+		if credential.SignCount >= signCount && (credential.SignCount != 0 || signCount != 0) {
+			return errors.New("memory_userdao: sign count didn't increase")
+		}
+		credential.SignCount = signCount
+		credential.LastUsedAt = time.Now()
+		return nil
+	}
+	return errors.New("memory_userdao: credential not found")
+}
+
+func (d *userDaoMemory) RemoveCredential(userID int64, credentialID []byte) error {
+	for i, credential := range d.credentials {
+		if credential.userID == userID && bytes.Equal(credential.ID, credentialID) {
+			d.credentials = append(d.credentials[:i], d.credentials[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("memory_userdao: credential not found")
+}
+
+func (d *userDaoMemory) Credentials(userID int64) ([]*domain.Credential, error) {
+	credentials := []*domain.Credential{}
+	for _, credential := range d.credentials {
+		if credential.userID == userID {
+			registered := credential.Credential
+			credentials = append(credentials, &registered)
+		}
+	}
+	return credentials, nil
+}
+
 func (d *userDaoMemory) AuthorizeClient(userID int64, clientPublicID string, scope domain.Scope) error {
-	// TODO
+	if _, err := d.Get(userID); err != nil {
+		return err
+	}
+	d.authorizations.authorize(userID, clientPublicID, scope)
+	return nil
+}
+
+func (d *userDaoMemory) RevokeClient(userID int64, clientPublicID string) error {
+	if !d.authorizations.revoke(userID, clientPublicID) {
+		return errors.New("memory_userdao: client not authorized")
+	}
+	return nil
+}
+
+func (d *userDaoMemory) SetPassword(userID int64, password string) error {
+	user, err := d.Get(userID)
+	if err != nil || user == nil {
+		return errors.New("memory_userdao: no user found")
+	}
+	user.PasswordHash = password
+	return nil
+}
+
+func (d *userDaoMemory) VerifyEmail(userID int64, email string) error {
+	user, err := d.Get(userID)
+	if err != nil || user == nil {
+		return errors.New("memory_userdao: no user found")
+	}
+	idx := emailIndex(user.Emails, email)
+	if idx < 0 {
+		return errors.New("memory_userdao: email not found")
+	}
+	user.Emails[idx].Verified = true
+	return nil
+}
+
+func (d *userDaoMemory) VerifyPhone(userID int64, phone string) error {
+	user, err := d.Get(userID)
+	if err != nil || user == nil {
+		return errors.New("memory_userdao: no user found")
+	}
+	idx := phoneIndex(user.Phones, phone)
+	if idx < 0 {
+		return errors.New("memory_userdao: phone not found")
+	}
+	user.Phones[idx].Verified = true
 	return nil
 }
 
@@ -229,7 +611,7 @@ func getIndex(pointer *jsonpointer.JSONPointer, maxValue int) (idx int, err erro
 		idx = maxValue
 		err = nil
 	}
-	if idx > maxValue {
+	if idx < 0 || idx > maxValue {
 		err = fmt.Errorf("memory_usage: out of range index (%v) max is (%v)", idx, maxValue)
 	}
 	return idx, err
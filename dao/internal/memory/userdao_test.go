@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/gabriel-araujjo/condominio-auth/domain"
+	"github.com/gabriel-araujjo/json-patcher"
 )
 
 func mustParse(s string) (u *url.URL) {
@@ -19,7 +20,6 @@ func TestUserTailor_Add(t *testing.T) {
 		ID:           1,
 		Name:         "",
 		CPF:          "",
-		FbID:         "",
 		Avatar:       nil,
 		Phones:       nil,
 		Emails:       nil,
@@ -54,12 +54,6 @@ func TestUserTailor_Add(t *testing.T) {
 		expectValue: "875.208.787-59",
 		field:       "CPF",
 		expectErr:   false,
-	}, {
-		name:        "FbID",
-		field:       "FbID",
-		jsonValue:   "12314345345",
-		expectValue: "12314345345",
-		expectErr:   false,
 	}, {
 		name:        "Avatar",
 		field:       "Avatar",
@@ -101,7 +95,6 @@ func TestUserTailor_Remove(t *testing.T) {
 		ID:     1,
 		Name:   "Paulo Silva",
 		CPF:    "646.112.228-10",
-		FbID:   "213124",
 		Avatar: mustParse("https://gravatar.com/avatar/234235345345323423423423"),
 		Phones: []domain.Phone{
 			{Phone: "(84) 9 5432-1111", Verified: true},
@@ -139,11 +132,6 @@ func TestUserTailor_Remove(t *testing.T) {
 		expectValue: "",
 		field:       "CPF",
 		expectErr:   false,
-	}, {
-		name:        "FbID",
-		field:       "FbID",
-		expectValue: "",
-		expectErr:   false,
 	}, {
 		name:        "Avatar",
 		field:       "Avatar",
@@ -180,7 +168,7 @@ func TestUserTailor_Remove(t *testing.T) {
 
 func TestUserDaoMemory(t *testing.T) {
 
-	userDao, _, _ := NewDao(nil)
+	userDao, _, _, _ := NewDao(nil)
 
 	avatar, _ := url.Parse("https://www.gravatar.com/avatar/205e460b479e2e5b48aec07710c08d53")
 
@@ -194,7 +182,6 @@ func TestUserDaoMemory(t *testing.T) {
 			user: &domain.User{
 				Name:   "Fulano",
 				CPF:    "61772443514",
-				FbID:   "1111111111",
 				Avatar: avatar,
 				Phones: []domain.Phone{{
 					Phone:    "447588164927",
@@ -317,6 +304,256 @@ func TestUserDaoMemory(t *testing.T) {
 		}
 	})
 
+	t.Run("Lookup", func(t *testing.T) {
+		for _, credential := range []string{"61772443514", "fulano@email2.com", "447588164927"} {
+			id, err := userDao.Lookup(credential)
+			if err != nil {
+				t.Errorf("lookup %q: err should be nil instead of %q", credential, err)
+			}
+			if id != 1 {
+				t.Errorf("lookup %q: 1 should be returned instead of %d", credential, id)
+			}
+		}
+
+		if _, err := userDao.Lookup("unknown@email.com"); err == nil {
+			t.Error("err should be returned for an unknown credential")
+		}
+	})
+
+	t.Run("Identity", func(t *testing.T) {
+		facebook := &domain.Identity{Provider: "facebook", Subject: "1111111111", Email: "fulano@email.com"}
+		if err := userDao.LinkIdentity(1, facebook); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if facebook.LinkedAt.IsZero() {
+			t.Error("LinkedAt should be set")
+		}
+		if err := userDao.LinkIdentity(1, &domain.Identity{Provider: "gov.br", Subject: "61772443514"}); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if id, err := userDao.LookupIdentity("facebook", "1111111111"); err != nil || id != 1 {
+			t.Errorf("1 should be returned instead of %d (err %v)", id, err)
+		}
+		if _, err := userDao.LookupIdentity("google", "1111111111"); err == nil {
+			t.Error("err should be returned for an unknown identity")
+		}
+		if identities, _ := userDao.Identities(1); len(identities) != 2 || *identities[0] != *facebook {
+			t.Errorf("the 2 linked identities should be listed instead of %v", identities)
+		}
+		if err := userDao.LinkIdentity(20, &domain.Identity{Provider: "google", Subject: "1"}); err == nil {
+			t.Error("err should be returned for an unknown user")
+		}
+
+		if err := userDao.UnlinkIdentity(1, "gov.br", "61772443514"); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if err := userDao.UnlinkIdentity(1, "gov.br", "61772443514"); err == nil {
+			t.Error("err should be returned for an identity not linked")
+		}
+		if identities, _ := userDao.Identities(1); len(identities) != 1 {
+			t.Errorf("1 identity should be listed instead of %d", len(identities))
+		}
+	})
+
+	t.Run("TOTP", func(t *testing.T) {
+		if err := userDao.SetTOTPSecret(1, []byte("old secret")); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if err := userDao.SetTOTPSecret(1, []byte("secret")); err != nil {
+			t.Errorf("a pending secret should be replaced instead of %q", err)
+		}
+		if secret, confirmed, err := userDao.TOTPSecret(1); err != nil || string(secret) != "secret" || confirmed {
+			t.Errorf("a pending secret should be returned instead of %q, %v (err %v)", secret, confirmed, err)
+		}
+
+		if err := userDao.ConfirmTOTP(1, [][]byte{[]byte("code 1"), []byte("code 2")}); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if _, confirmed, _ := userDao.TOTPSecret(1); !confirmed {
+			t.Error("the secret should be confirmed")
+		}
+		if err := userDao.SetTOTPSecret(1, []byte("other")); err == nil {
+			t.Error("err should be returned replacing a confirmed secret")
+		}
+
+		if err := userDao.RedeemRecoveryCode(1, []byte("code 1")); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if err := userDao.RedeemRecoveryCode(1, []byte("code 1")); err == nil {
+			t.Error("err should be returned for a redeemed recovery code")
+		}
+
+		if err := userDao.RemoveTOTP(1); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if secret, _, err := userDao.TOTPSecret(1); err != nil || secret != nil {
+			t.Errorf("no secret should be returned instead of %q (err %v)", secret, err)
+		}
+		if err := userDao.RedeemRecoveryCode(1, []byte("code 2")); err == nil {
+			t.Error("err should be returned for a removed recovery code")
+		}
+	})
+
+	t.Run("Credentials", func(t *testing.T) {
+		credential := &domain.Credential{ID: []byte("credential"), Name: "Phone", PublicKey: []byte("key")}
+		if err := userDao.AddCredential(1, credential); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if credential.CreatedAt.IsZero() {
+			t.Error("the registration time should be set")
+		}
+		if err := userDao.AddCredential(1, &domain.Credential{ID: []byte("credential"), PublicKey: []byte("key")}); err == nil {
+			t.Error("err should be returned registering a credential twice")
+		}
+
+		userID, found, err := userDao.LookupCredential([]byte("credential"))
+		if err != nil || userID != 1 || found.Name != "Phone" || string(found.PublicKey) != "key" {
+			t.Errorf("the credential of user 1 should be found instead of %d, %v (err %v)", userID, found, err)
+		}
+		if _, _, err = userDao.LookupCredential([]byte("unknown")); err == nil {
+			t.Error("err should be returned for an unknown credential")
+		}
+
+		if err = userDao.UseCredential([]byte("credential"), 5); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if err = userDao.UseCredential([]byte("credential"), 5); err == nil {
+			t.Error("err should be returned when the sign count doesn't increase")
+		}
+		if _, found, _ = userDao.LookupCredential([]byte("credential")); found.SignCount != 5 || found.LastUsedAt.IsZero() {
+			t.Errorf("the sign count should be 5 instead of %d", found.SignCount)
+		}
+
+		if credentials, err := userDao.Credentials(1); err != nil || len(credentials) != 1 {
+			t.Errorf("one credential should be listed instead of %v (err %v)", credentials, err)
+		}
+		if err = userDao.RemoveCredential(2, []byte("credential")); err == nil {
+			t.Error("err should be returned removing a credential of another user")
+		}
+		if err = userDao.RemoveCredential(1, []byte("credential")); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if credentials, err := userDao.Credentials(1); err != nil || len(credentials) != 0 {
+			t.Errorf("no credential should be listed instead of %v (err %v)", credentials, err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		cases := []struct {
+			name      string
+			patch     json_patcher.Patch
+			expectErr bool
+			check     func(u *domain.User) bool
+		}{{
+			name: "PrimaryEmail",
+			patch: json_patcher.Patch{
+				{Op: "move", From: "/emails/1", Path: "/emails/0"},
+			},
+			check: func(u *domain.User) bool {
+				return reflect.DeepEqual(u.Emails, []domain.Email{
+					{Email: "fulano@email2.com", Verified: false},
+					{Email: "fulano@email.com", Verified: true},
+				})
+			},
+		}, {
+			name: "ReplacedWithSameEmailKeepsVerified",
+			patch: json_patcher.Patch{
+				{Op: "replace", Path: "/emails/1", Value: "fulano@email.com"},
+			},
+			check: func(u *domain.User) bool {
+				return reflect.DeepEqual(u.Emails[1], domain.Email{Email: "fulano@email.com", Verified: true})
+			},
+		}, {
+			name: "ReplacedPhoneIsNotVerified",
+			patch: json_patcher.Patch{
+				{Op: "replace", Path: "/phones/0", Value: "558499990000"},
+			},
+			check: func(u *domain.User) bool {
+				return reflect.DeepEqual(u.Phones[0], domain.Phone{Phone: "558499990000", Verified: false})
+			},
+		}, {
+			name: "AddEmail",
+			patch: json_patcher.Patch{
+				{Op: "add", Path: "/emails/-", Value: "fulano@email3.com"},
+			},
+			check: func(u *domain.User) bool {
+				return len(u.Emails) == 3 && u.Emails[2].Email == "fulano@email3.com"
+			},
+		}, {
+			name: "Atomic",
+			patch: json_patcher.Patch{
+				{Op: "replace", Path: "/name", Value: "Ciclano"},
+				{Op: "remove", Path: "/emails/5"},
+			},
+			expectErr: true,
+			check: func(u *domain.User) bool {
+				return u.Name == "Fulano"
+			},
+		}, {
+			name: "ID",
+			patch: json_patcher.Patch{
+				{Op: "replace", Path: "/id", Value: "2"},
+			},
+			expectErr: true,
+			check: func(u *domain.User) bool {
+				return u.ID == 1
+			},
+		}}
+
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				err := userDao.Update(1, tt.patch)
+				if tt.expectErr {
+					if err == nil {
+						t.Errorf("test %q: should err be returned", tt.name)
+					}
+				} else if err != nil {
+					t.Errorf("test %q: should err be nil instead of %q", tt.name, err)
+				}
+
+				if u, _ := userDao.Get(1); !tt.check(u) {
+					t.Errorf("test %q: unexpected user %+v", tt.name, u)
+				}
+			})
+		}
+	})
+
+	t.Run("VerifyEmail", func(t *testing.T) {
+		if err := userDao.VerifyEmail(1, "fulano@email3.com"); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if u, _ := userDao.Get(1); !u.Emails[2].Verified {
+			t.Error("expecting the email to be verified")
+		}
+		if err := userDao.VerifyEmail(1, "ciclano@email.com"); err == nil {
+			t.Error("err should be returned for an email of other user")
+		}
+	})
+
+	t.Run("VerifyPhone", func(t *testing.T) {
+		if err := userDao.VerifyPhone(1, "558499990000"); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if u, _ := userDao.Get(1); !u.Phones[0].Verified {
+			t.Error("expecting the phone to be verified")
+		}
+		if err := userDao.VerifyPhone(1, "558499991111"); err == nil {
+			t.Error("err should be returned for a phone of other user")
+		}
+	})
+
+	t.Run("SetPassword", func(t *testing.T) {
+		if err := userDao.SetPassword(1, "nova senha"); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if _, err := userDao.Authenticate("61772443514", "nova senha"); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if _, err := userDao.Authenticate("61772443514", "senha"); err == nil {
+			t.Error("err should be returned for the old password")
+		}
+	})
+
 	t.Run("Get", func(t *testing.T) {
 		user, err := userDao.Get(1)
 		if err != nil {
@@ -361,11 +598,16 @@ func TestUserDaoMemory(t *testing.T) {
 					if err != nil {
 						t.Errorf("test %q: should err be nil instead of %q", tt.name, err)
 					}
-					if u, _ := userDao.Get(tt.id); u != nil {
+					if u, err := userDao.Get(tt.id); u != nil || err == nil {
 						t.Error("expecting user does not exist")
 					}
 				}
 			})
 		}
+
+		// deleted users are skipped instead of dereferenced
+		if _, err := userDao.Authenticate("nobody@email.com", "password"); err == nil {
+			t.Error("err should be returned for an unknown credential")
+		}
 	})
 }
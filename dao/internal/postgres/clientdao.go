@@ -13,27 +13,38 @@ import (
 	"math/rand"
 
 	"github.com/gabriel-araujjo/condominio-auth/domain"
+	"github.com/lib/pq"
 )
 
 var count = rand.Int31()
 
 var clientDaoStmts = map[string]string{
 	"get": `
-			SELECT c.client_id, c.name, c.secret
+			SELECT c.client_id, c.name, c.secret, c.require_pkce, c.redirect_uris, c.scope, c.first_party,
+				c.user_scope
 			FROM "client" c
 			WHERE c.client_id = $1
 			LIMIT 1
 		`,
 	"insert": `
-			INSERT INTO "client"(client_id, name, secret)
-			VALUES ($1, $2, $3)
+			INSERT INTO "client"(client_id, name, secret, require_pkce, redirect_uris, scope, first_party, user_scope)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 			RETURNING "client".client_id
 		`,
 	"permissionsByUser": `
-			SELECT s.scope_id, s.name, s.description 
+			SELECT s.name
 			FROM "authorization" a LEFT JOIN "scope" s ON a.scope_id = s.scope_id
 			WHERE a.client_id = $1 AND a.user_id = $2
 	`,
+	"authorizationsByUser": `
+			SELECT c.client_id, c.name, array_agg(s.name ORDER BY s.name)
+			FROM "authorization" a
+				JOIN "client" c ON a.client_id = c.client_id
+				JOIN "scope" s ON a.scope_id = s.scope_id
+			WHERE a.user_id = $1
+			GROUP BY c.client_id, c.name
+			ORDER BY c.name
+	`,
 }
 
 func newClientID() int64 {
@@ -55,10 +66,8 @@ func convertClientIDIntoPublicID(clientID int64) string {
 }
 
 type clientDaoPG struct {
-	db          *sql.DB
-	getStmt     *sql.Stmt
-	scopeByUser *sql.Stmt
-	stmts       map[string]*sql.Stmt
+	db    *sql.DB
+	stmts map[string]*sql.Stmt
 }
 
 func (d *clientDaoPG) lazyPrepare() {
@@ -71,24 +80,25 @@ func (d *clientDaoPG) lazyPrepare() {
 			}
 			prepared[k] = stmt
 		}
+		d.stmts = prepared
 	}
 }
 
 func (d *clientDaoPG) Create(c *domain.Client) error {
-	if c.ID == 0 {
+	if c.ID != 0 {
 		return errors.New("pg: cliente already created")
 	}
 	d.lazyPrepare()
 	clientID := newClientID()
 
-	row := d.stmts["insert"].QueryRow(clientID, c.Name, c.Secret)
+	row := d.stmts["insert"].QueryRow(clientID, c.Name, c.Secret, c.RequirePKCE,
+		pq.Array(c.RedirectURIs), pq.Array([]string(c.Scope)), c.FirstParty, pq.Array([]string(c.UserScope)))
 	if err := row.Scan(&c.ID); err != nil {
 		return err
 	}
 
 	c.PublicID = convertClientIDIntoPublicID(clientID)
-
-	panic("implement me")
+	return nil
 }
 
 func (d *clientDaoPG) Delete(u *domain.Client) error {
@@ -108,9 +118,11 @@ func (d *clientDaoPG) Get(publicID string) (*domain.Client, error) {
 	}
 
 	client := &domain.Client{}
-	row := d.getStmt.QueryRow(clientID)
+	row := d.stmts["get"].QueryRow(clientID)
 
-	err = row.Scan(&client.ID, &client.Name, &client.Secret)
+	err = row.Scan(&client.ID, &client.Name, &client.Secret, &client.RequirePKCE,
+		pq.Array(&client.RedirectURIs), pq.Array((*[]string)(&client.Scope)), &client.FirstParty,
+		pq.Array((*[]string)(&client.UserScope)))
 	if err != nil {
 		return nil, err
 	}
@@ -157,3 +169,25 @@ func (d *clientDaoPG) GetAuthorizedScopesByUser(publicID string, userID int64) d
 
 	return permissions
 }
+
+func (d *clientDaoPG) GetAuthorizationsByUser(userID int64) ([]*domain.Authorization, error) {
+	d.lazyPrepare()
+
+	rows, err := d.stmts["authorizationsByUser"].Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	authorizations := []*domain.Authorization{}
+	for rows.Next() {
+		var clientID int64
+		a := &domain.Authorization{}
+		if err = rows.Scan(&clientID, &a.ClientName, pq.Array((*[]string)(&a.Scope))); err != nil {
+			return nil, err
+		}
+		a.ClientID = convertClientIDIntoPublicID(clientID)
+		authorizations = append(authorizations, a)
+	}
+	return authorizations, rows.Err()
+}
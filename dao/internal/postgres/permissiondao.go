@@ -5,16 +5,21 @@ import (
 	"fmt"
 
 	"github.com/gabriel-araujjo/condominio-auth/domain"
+	"github.com/lib/pq"
 )
 
 var permissionStmts = map[string]string{
 	"insert": `
-			INSERT INTO "scope"(name) VALUES ($1) RETURNING "scope".user_id
+			INSERT INTO "scope"(name) VALUES ($1) RETURNING "scope".scope_id
 	`,
 	"mapIDs": `
 			SELECT s.scope_id FROM "scope" s 
 			WHERE s.name = ANY ($1)
 	`,
+	"mapNames": `
+			SELECT s.name FROM "scope" s
+			WHERE s.scope_id = ANY ($1)
+	`,
 }
 
 // PermissionDao manage queries related to permissions
@@ -29,10 +34,11 @@ func (d *pgPermissionDao) Create(name string) error {
 }
 
 func (d *pgPermissionDao) ScopeIntoPermissionIDs(scope domain.Scope) ([]int64, error) {
-	rows, err := d.stmts["mapIDs"].Query(scope)
+	rows, err := d.stmts["mapIDs"].Query(pq.Array(scope))
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	ids := make([]int64, 0, len(scope))
 	for rows.Next() {
@@ -40,7 +46,23 @@ func (d *pgPermissionDao) ScopeIntoPermissionIDs(scope domain.Scope) ([]int64, e
 		rows.Scan(&id)
 		ids = append(ids, id)
 	}
-	return ids, nil
+	return ids, rows.Err()
+}
+
+func (d *pgPermissionDao) PermissionIDsIntoScope(ids []int64) (domain.Scope, error) {
+	rows, err := d.stmts["mapNames"].Query(pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scope := make(domain.Scope, 0, len(ids))
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		scope = append(scope, name)
+	}
+	return scope, rows.Err()
 }
 
 func newPGPermissionDao(db *sql.DB) *pgPermissionDao {
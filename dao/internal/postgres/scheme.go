@@ -5,9 +5,91 @@ import (
 
 	"github.com/gabriel-araujjo/base62"
 	"github.com/gabriel-araujjo/condominio-auth/config"
+	"github.com/gabriel-araujjo/condominio-auth/domain"
+	"github.com/lib/pq"
 )
 
-const dbVersion = 1
+const dbVersion = 10
+
+// migrations has the statements upgrading the scheme from the version used as key
+// to the next one. OnCreate must create the scheme already on dbVersion.
+var migrations = map[int]string{
+	1: `
+ALTER TABLE "client" ADD COLUMN require_pkce BOOLEAN NOT NULL DEFAULT FALSE;
+`,
+	2: `
+ALTER TABLE "client" ADD COLUMN redirect_uris TEXT[] NOT NULL DEFAULT '{}';
+`,
+	3: `
+ALTER TABLE "client" ADD COLUMN scope TEXT[] NOT NULL DEFAULT '{}';
+`,
+	4: `
+ALTER TABLE "client" ADD COLUMN first_party BOOLEAN NOT NULL DEFAULT FALSE;
+`,
+	5: userIdentityTable + `
+INSERT INTO "user_identity"(user_id, provider, subject)
+	SELECT u.user_id, 'facebook', u.fb_id FROM "user" u WHERE u.fb_id IS NOT NULL;
+ALTER TABLE "user" DROP COLUMN fb_id;
+`,
+	6: userTOTPTables,
+	7: userCredentialTable,
+	8: `
+INSERT INTO "scope"(name) VALUES ` + openIDScopeValues + `
+	ON CONFLICT (name) DO NOTHING;
+`,
+	9: `
+ALTER TABLE "client" ADD COLUMN user_scope TEXT[] NOT NULL DEFAULT '{}';
+`,
+}
+
+// openIDScopeValues are the rows of the scopes advertised on discovery,
+// the authorization end point only grants scopes found on the "scope" table
+const openIDScopeValues = `('` + domain.ScopeOpenID + `'), ('` + domain.ScopeProfile + `'), ('` +
+	domain.ScopeEmail + `'), ('` + domain.ScopePhone + `')`
+
+// userIdentityTable keeps the accounts of upstream identity providers linked to users
+const userIdentityTable = `
+CREATE TABLE "user_identity" (
+  user_id INTEGER NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+  provider TEXT NOT NULL CHECK (provider != ''),
+  subject TEXT NOT NULL CHECK (subject != ''),
+  email TEXT,
+  linked_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    CONSTRAINT ct_user_identity_pk PRIMARY KEY (provider, subject)
+);
+CREATE INDEX user_identity_user_idx ON "user_identity" (user_id);
+`
+
+// userTOTPTables keep the TOTP secrets, the second factor of the login, and
+// the hashes of the recovery codes replacing it
+const userTOTPTables = `
+CREATE TABLE "user_totp" (
+  user_id INTEGER PRIMARY KEY REFERENCES "user"(user_id) ON DELETE CASCADE,
+  secret BYTEA NOT NULL,
+  confirmed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE "user_recovery_code" (
+  user_id INTEGER NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+  code_hash BYTEA NOT NULL,
+    CONSTRAINT ct_user_recovery_code_pk PRIMARY KEY (user_id, code_hash)
+);
+`
+
+// userCredentialTable keeps the WebAuthn public key credentials, the passkeys
+// users log in with instead of a password
+const userCredentialTable = `
+CREATE TABLE "user_credential" (
+  credential_id BYTEA PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+  name TEXT NOT NULL DEFAULT '',
+  public_key BYTEA NOT NULL,
+  sign_count BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  last_used_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX user_credential_user_idx ON "user_credential" (user_id);
+`
 
 type scheme struct {
 	conf *config.Config
@@ -122,7 +204,6 @@ CREATE TABLE "user" (
   user_id SERIAL PRIMARY KEY,
   name TEXT NOT NULL CHECK (name != ''),
   cpf INT8 CHECK (check_cpf(cpf)),
-  fb_id TEXT UNIQUE,
   avatar TEXT,
   hash TEXT,
   phone TEXT CHECK (phone ~ '^\d+$') CHECK (check_phone_uniqueness(phone)),
@@ -146,11 +227,16 @@ CREATE TABLE "user_phone" (
   verified BOOLEAN DEFAULT FALSE,
     CONSTRAINT ct_user_phone_pk PRIMARY KEY (user_id, phone)
 );
-
+` + userIdentityTable + userTOTPTables + userCredentialTable + `
 CREATE TABLE "client" (
 	client_id INTEGER PRIMARY KEY,
 	name TEXT NOT NULL UNIQUE,
-	secret TEXT NOT NULL
+	secret TEXT NOT NULL,
+	require_pkce BOOLEAN NOT NULL DEFAULT FALSE,
+	redirect_uris TEXT[] NOT NULL DEFAULT '{}',
+	scope TEXT[] NOT NULL DEFAULT '{}',
+	first_party BOOLEAN NOT NULL DEFAULT FALSE,
+	user_scope TEXT[] NOT NULL DEFAULT '{}'
 );
 
 CREATE TABLE "scope" (
@@ -166,6 +252,7 @@ CREATE OR REPLACE FUNCTION lowecase_name_on_insert() RETURNS trigger AS $lowecas
 $lowecase_name_on_insert$ LANGUAGE plpgsql;
 CREATE TRIGGER lowecase_name_on_insert_trigger BEFORE INSERT OR UPDATE ON "scope"
     FOR EACH ROW EXECUTE PROCEDURE lowecase_name_on_insert();
+INSERT INTO "scope"(name) VALUES ` + openIDScopeValues + `;
 
 CREATE TABLE "authorization" (
   client_id INTEGER REFERENCES "client"(client_id) ON DELETE CASCADE,
@@ -206,11 +293,12 @@ FOR EACH ROW EXECUTE PROCEDURE hash_password();
 		clientID, _ := base62.ParseUint(c.PublicID)
 		c.ID = int64(clientID)
 		err = db.QueryRow(`
-			INSERT INTO "client"(client_id, name, secret)
-			VALUES ($1, $2, $3)
+			INSERT INTO "client"(client_id, name, secret, require_pkce, redirect_uris, scope, first_party, user_scope)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 			RETURNING "client".client_id
 		`,
-			c.ID, c.Name, c.Secret).Scan(&c.ID)
+			c.ID, c.Name, c.Secret, c.RequirePKCE, pq.Array(c.RedirectURIs), pq.Array([]string(c.Scope)),
+			c.FirstParty, pq.Array([]string(c.UserScope))).Scan(&c.ID)
 		if err != nil {
 			return
 		}
@@ -218,8 +306,17 @@ FOR EACH ROW EXECUTE PROCEDURE hash_password();
 	return
 }
 
-func (s *scheme) OnUpdate(db *sql.DB, oldVersion int) error {
-	return nil
+func (s *scheme) OnUpdate(db *sql.DB, oldVersion int) (err error) {
+	for version := oldVersion; version < dbVersion; version++ {
+		migration, ok := migrations[version]
+		if !ok {
+			continue
+		}
+		if _, err = db.Exec(migration); err != nil {
+			return
+		}
+	}
+	return
 }
 
 func (s *scheme) Version() int {
@@ -31,6 +31,10 @@ var tableNames = []string{
 	"user",
 	"user_email",
 	"user_phone",
+	"user_identity",
+	"user_totp",
+	"user_recovery_code",
+	"user_credential",
 	"client",
 }
 
@@ -148,19 +152,45 @@ func TestScheme(t *testing.T) {
 
 	t.Run("OnUpdate", func(t *testing.T) {
 		s := scheme{conf: nil}
-		err := s.OnUpdate(nil, 0)
+		err := s.OnUpdate(nil, s.Version())
 
 		if err != nil {
 			t.Errorf("err should be nil instead of %q", err)
 		}
 	})
 
+	t.Run("Migrations", func(t *testing.T) {
+		db, m, _ := sqlmock.New()
+		for version := 1; version < dbVersion; version++ {
+			m.ExpectExec(".*").WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+
+		s := scheme{conf: nil}
+		if err := s.OnUpdate(db, 1); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+
+		if err := m.ExpectationsWereMet(); err != nil {
+			t.Errorf("every migration should be executed: %q", err)
+		}
+	})
+
+	t.Run("MigrationError", func(t *testing.T) {
+		db, m, _ := sqlmock.New()
+		m.ExpectExec(".*").WillReturnError(errFoo)
+
+		s := scheme{conf: nil}
+		if err := s.OnUpdate(db, 1); err != errFoo {
+			t.Errorf("should return 'some error' instead of %q", err)
+		}
+	})
+
 	t.Run("Version", func(t *testing.T) {
 		s := scheme{conf: nil}
 		if s.Version() <= 0 {
 			t.Errorf("scheme.Version() should return a integer greater then zero instead of %d", s.Version())
 		}
-		err := s.OnUpdate(nil, 0)
+		err := s.OnUpdate(nil, s.Version())
 
 		if err != nil {
 			t.Errorf("err should be nil instead of %q", err)
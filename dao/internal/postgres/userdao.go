@@ -5,22 +5,28 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gabriel-araujjo/condominio-auth/domain"
 	jsonpointer "github.com/gabriel-araujjo/go-jsonpointer"
 	patcher "github.com/gabriel-araujjo/json-patcher"
+	"github.com/lib/pq"
 )
 
 var userdaoStmts = map[string]string{
 	"insert": `
-			INSERT INTO "user"(name, cpf, fb_id, avatar, hash, phone,
+			INSERT INTO "user"(name, cpf, avatar, hash, phone,
 				phone_verified, email, email_verified)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING "user".user_id
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING "user".user_id
+		`,
+	"lockByID": `
+			SELECT u.user_id FROM "user" u WHERE u.user_id = $1 FOR UPDATE
 		`,
 	"findByID": `
-			SELECT u.user_id, u.name, u.cpf, u.fb_id, u.avatar, u.hash, u.phone,
+			SELECT u.user_id, u.name, u.cpf, u.avatar, u.hash, u.phone,
 				u.phone_verified, u.email, u.email_verified FROM "user" u
 			WHERE u.user_id = $1 LIMIT 1
 		`,
@@ -36,9 +42,9 @@ var userdaoStmts = map[string]string{
 			SELECT u.user_id FROM "user" u
 		  	WHERE u.cpf = $1 LIMIT 1
 		`,
-	"mapFBIDIntoID": `
-			SELECT u.user_id FROM "user" u
-			WHERE u.fb_id = $1 LIMIT 1
+	"mapIdentityIntoID": `
+			SELECT i.user_id FROM "user_identity" i
+			WHERE i.provider = $1 AND i.subject = $2 LIMIT 1
 		`,
 	"mapEmailIntoID": `
 			SELECT l.user_id FROM "email_lookup" l
@@ -53,13 +59,12 @@ var userdaoStmts = map[string]string{
 			SET
 				name = $2,
 				cpf = $3,
-				fb_id = $4,
-				avatar = $5,
-				hash = $6,
-				phone= $7,
-				phone_verified = $8,
-				email = $9,
-				email_verified = $10
+				avatar = $4,
+				hash = $5,
+				phone= $6,
+				phone_verified = $7,
+				email = $8,
+				email_verified = $9
 			WHERE user_id = $1
 		`,
 	"addEmail": `
@@ -102,26 +107,120 @@ var userdaoStmts = map[string]string{
 	"authorizeClient": `
 			INSERT INTO "authorization"(client_id, user_id, scope_id) 
 			SELECT $1 AS client_id, $2 AS user_id, s.scope_id FROM "scope" s 
-			WHERE s.name = ANY ($3) ON CONFLICT DO UPDATE
+			WHERE s.name = ANY ($3)
+			ON CONFLICT ON CONSTRAINT authorization_pk DO UPDATE SET scope_id = EXCLUDED.scope_id
+	`,
+	"verifyPrimaryEmail": `
+			UPDATE "user" SET email_verified = TRUE WHERE user_id = $1 AND email = $2
+	`,
+	"verifyEmail": `
+			UPDATE "user_email" SET verified = TRUE WHERE user_id = $1 AND email = $2
+	`,
+	"verifyPrimaryPhone": `
+			UPDATE "user" SET phone_verified = TRUE WHERE user_id = $1 AND phone = $2
+	`,
+	"verifyPhone": `
+			UPDATE "user_phone" SET verified = TRUE WHERE user_id = $1 AND phone = $2
+	`,
+	"setPassword": `
+			UPDATE "user" SET hash = $2 WHERE user_id = $1
+	`,
+	"revokeClient": `
+			DELETE FROM "authorization" WHERE user_id = $1 AND client_id = $2
+	`,
+	"linkIdentity": `
+			INSERT INTO "user_identity"(user_id, provider, subject, email)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT ON CONSTRAINT ct_user_identity_pk DO UPDATE SET email = EXCLUDED.email
+			WHERE "user_identity".user_id = EXCLUDED.user_id
+			RETURNING linked_at
+	`,
+	"unlinkIdentity": `
+			DELETE FROM "user_identity" WHERE user_id = $1 AND provider = $2 AND subject = $3
+	`,
+	"setTOTPSecret": `
+			INSERT INTO "user_totp"(user_id, secret) VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret
+			WHERE "user_totp".confirmed_at IS NULL
+			RETURNING user_id
+	`,
+	"queryTOTPSecret": `
+			SELECT t.secret, t.confirmed_at IS NOT NULL FROM "user_totp" t WHERE t.user_id = $1
+	`,
+	"confirmTOTP": `
+			UPDATE "user_totp" SET confirmed_at = now() WHERE user_id = $1 AND confirmed_at IS NULL
+	`,
+	"addRecoveryCode": `
+			INSERT INTO "user_recovery_code"(user_id, code_hash) VALUES ($1, $2)
+	`,
+	"removeRecoveryCode": `
+			DELETE FROM "user_recovery_code" WHERE user_id = $1 AND code_hash = $2
+	`,
+	"deleteAllRecoveryCodes": `
+			DELETE FROM "user_recovery_code" WHERE user_id = $1
+	`,
+	"deleteTOTP": `
+			DELETE FROM "user_totp" WHERE user_id = $1
+	`,
+	"addCredential": `
+			INSERT INTO "user_credential"(credential_id, user_id, name, public_key, sign_count)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING created_at
+	`,
+	"queryCredential": `
+			SELECT c.user_id, c.name, c.public_key, c.sign_count, c.created_at, c.last_used_at
+			FROM "user_credential" c WHERE c.credential_id = $1
+	`,
+	"useCredential": `
+			UPDATE "user_credential" SET sign_count = $2, last_used_at = now()
+			WHERE credential_id = $1 AND (sign_count < $2 OR (sign_count = 0 AND $2 = 0))
+	`,
+	"removeCredential": `
+			DELETE FROM "user_credential" WHERE user_id = $1 AND credential_id = $2
+	`,
+	"queryCredentials": `
+			SELECT c.credential_id, c.name, c.public_key, c.sign_count, c.created_at, c.last_used_at
+			FROM "user_credential" c WHERE c.user_id = $1 ORDER BY c.created_at ASC
+	`,
+	"queryIdentities": `
+			SELECT i.provider, i.subject, i.email, i.linked_at FROM "user_identity" i
+			WHERE i.user_id = $1 ORDER BY i.linked_at ASC
 	`,
 }
 
+// updateStmt sets only the changed columns of a user, the uniqueness checks
+// of phone and email fail when they're set to their current values
 type updateStmt struct {
 	updates []string
 	args    []interface{}
 }
 
-type patch struct {
-	user          domain.User
-	userPatch     updateStmt
-	addedEmails   []string
-	deletedEmails []string
-	addedPhones   []string
-	deletedPhones []string
+func (s *updateStmt) set(column string, value interface{}) {
+	s.args = append(s.args, value)
+	// $1 is the user id
+	s.updates = append(s.updates, fmt.Sprintf("%s = $%d", column, len(s.args)+1))
 }
 
+func (s *updateStmt) exec(tx *sql.Tx, id int64) error {
+	if len(s.updates) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`UPDATE "user" SET `+strings.Join(s.updates, ", ")+` WHERE user_id = $1`,
+		append([]interface{}{id}, s.args...)...)
+	return err
+}
+
+// userTailor patches a copy of the user, Update writes the differences
 type userTailor struct{}
 
+func stringValue(value interface{}) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("postgres_userdao: expecting a string instead of %v", value)
+	}
+	return s, nil
+}
+
 func (userTailor) Add(obj interface{}, path string, value interface{}) error {
 
 	pointer, err := jsonpointer.NewJSONPointerFromString(path)
@@ -133,44 +232,209 @@ func (userTailor) Add(obj interface{}, path string, value interface{}) error {
 		return fmt.Errorf("postgres_userdao: invalid path %q", path)
 	}
 
-	updateStmt := obj.(*updateStmt)
+	user := obj.(*domain.User)
 
 	//TODO: Use reflections
 
+	if pointer.Tokens()[0] == "id" {
+		return errors.New("postgres_userdao: can't edit user id")
+	}
+
+	s, err := stringValue(value)
+	if err != nil {
+		return err
+	}
+
 	switch pointer.Tokens()[0] {
 	default:
 		return fmt.Errorf("postgres_userdao: invalid path %q", path)
-	case "id":
-		return errors.New("postgres_userdao: can't edit user id")
 	case "name":
-		updateStmt.updates = append(updateStmt.updates, "name = $")
-		updateStmt.args = append(updateStmt.args, value)
+		user.Name = s
 	case "cpf":
-		updateStmt.updates = append(updateStmt.updates, "cpf = $")
-		updateStmt.args = append(updateStmt.args, value)
-	case "fb_id":
-		updateStmt.updates = append(updateStmt.updates, "fb_id = $")
-		updateStmt.args = append(updateStmt.args, value)
+		user.CPF = s
 	case "avatar":
-		updateStmt.updates = append(updateStmt.updates, "avatar = $")
-		updateStmt.args = append(updateStmt.args, value)
+		url, err := url.Parse(s)
+		if err != nil {
+			return err
+		}
+		user.Avatar = url
+	case "phones":
+		idx, err := getIndex(pointer, len(user.Phones))
+		if err != nil {
+			return err
+		}
+		if phoneIndex(user.Phones, s) >= 0 {
+			return fmt.Errorf("postgres_userdao: phone %q already added", s)
+		}
+		phones := append([]domain.Phone{}, user.Phones[:idx]...)
+		phones = append(phones, domain.Phone{Phone: s, Verified: false})
+		user.Phones = append(phones, user.Phones[idx:]...)
+	case "emails":
+		idx, err := getIndex(pointer, len(user.Emails))
+		if err != nil {
+			return err
+		}
+		if emailIndex(user.Emails, s) >= 0 {
+			return fmt.Errorf("postgres_userdao: email %q already added", s)
+		}
+		emails := append([]domain.Email{}, user.Emails[:idx]...)
+		emails = append(emails, domain.Email{Email: s, Verified: false})
+		user.Emails = append(emails, user.Emails[idx:]...)
 	case "password":
-		updateStmt.updates = append(updateStmt.updates, "hash = $")
-		updateStmt.args = append(updateStmt.args, value)
+		user.PasswordHash = s
 	}
 	return nil
 }
 
 func (userTailor) Remove(obj interface{}, path string) error {
-	return errors.New("implement me")
+	pointer, err := jsonpointer.NewJSONPointerFromString(path)
+	if err != nil {
+		return err
+	}
+
+	if pointer.Depth() > 2 || pointer.Depth() < 1 {
+		return fmt.Errorf("postgres_userdao: invalid path %q", path)
+	}
+
+	user := obj.(*domain.User)
+
+	switch pointer.Tokens()[0] {
+	default:
+		return fmt.Errorf("postgres_userdao: invalid path %q", path)
+	case "id":
+		return errors.New("postgres_userdao: can't remove id")
+	case "name":
+		user.Name = ""
+	case "cpf":
+		user.CPF = ""
+	case "avatar":
+		user.Avatar = nil
+	case "phones":
+		if pointer.Depth() == 1 {
+			user.Phones = nil
+			return nil
+		}
+		idx, err := getIndex(pointer, len(user.Phones)-1)
+		if err != nil {
+			return err
+		}
+		user.Phones = append(append([]domain.Phone{}, user.Phones[:idx]...), user.Phones[idx+1:]...)
+	case "emails":
+		if pointer.Depth() == 1 {
+			user.Emails = nil
+			return nil
+		}
+		idx, err := getIndex(pointer, len(user.Emails)-1)
+		if err != nil {
+			return err
+		}
+		user.Emails = append(append([]domain.Email{}, user.Emails[:idx]...), user.Emails[idx+1:]...)
+	}
+	return nil
 }
 
+// Move reorders the phones or emails, the first ones are the primary ones
 func (userTailor) Move(obj interface{}, path string, from uint64, to uint64) error {
-	return errors.New("implement me")
+	user := obj.(*domain.User)
+
+	switch path {
+	default:
+		return fmt.Errorf("postgres_userdao: can't move on path %q", path)
+	case "/phones":
+		if from >= uint64(len(user.Phones)) || to >= uint64(len(user.Phones)) {
+			return fmt.Errorf("postgres_userdao: out of range move from %d to %d", from, to)
+		}
+		phone := user.Phones[from]
+		phones := append(append([]domain.Phone{}, user.Phones[:from]...), user.Phones[from+1:]...)
+		user.Phones = append(append(phones[:to:to], phone), phones[to:]...)
+	case "/emails":
+		if from >= uint64(len(user.Emails)) || to >= uint64(len(user.Emails)) {
+			return fmt.Errorf("postgres_userdao: out of range move from %d to %d", from, to)
+		}
+		email := user.Emails[from]
+		emails := append(append([]domain.Email{}, user.Emails[:from]...), user.Emails[from+1:]...)
+		user.Emails = append(append(emails[:to:to], email), emails[to:]...)
+	}
+	return nil
 }
 
-func (userTailor) Replace(obj interface{}, path string, value interface{}) error {
-	return errors.New("implement me")
+// Replace sets a field, replaced phones and emails aren't verified unless they keep their value
+func (t userTailor) Replace(obj interface{}, path string, value interface{}) error {
+	pointer, err := jsonpointer.NewJSONPointerFromString(path)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case pointer.Depth() == 2 && (pointer.Tokens()[0] == "phones" || pointer.Tokens()[0] == "emails"):
+		if pointer.Tokens()[1] == "-" {
+			return fmt.Errorf("postgres_userdao: invalid path %q", path)
+		}
+		if sameEntry(obj.(*domain.User), pointer, value) {
+			return nil
+		}
+		if err = t.Remove(obj, path); err != nil {
+			return err
+		}
+		return t.Add(obj, path, value)
+	case pointer.Depth() == 1:
+		return t.Add(obj, path, value)
+	}
+	return fmt.Errorf("postgres_userdao: invalid path %q", path)
+}
+
+// sameEntry tells whether value is already the phone or email on pointer,
+// replacing it would only lose its verified flag
+func sameEntry(user *domain.User, pointer *jsonpointer.JSONPointer, value interface{}) bool {
+	s, ok := value.(string)
+	i, err := strconv.Atoi(pointer.Tokens()[1])
+	if !ok || err != nil || i < 0 {
+		return false
+	}
+	switch pointer.Tokens()[0] {
+	case "phones":
+		return i < len(user.Phones) && user.Phones[i].Phone == s
+	case "emails":
+		return i < len(user.Emails) && user.Emails[i].Email == s
+	}
+	return false
+}
+
+func phoneIndex(phones []domain.Phone, phone string) int {
+	for i := range phones {
+		if phones[i].Phone == phone {
+			return i
+		}
+	}
+	return -1
+}
+
+func emailIndex(emails []domain.Email, email string) int {
+	for i := range emails {
+		if emails[i].Email == email {
+			return i
+		}
+	}
+	return -1
+}
+
+func getIndex(pointer *jsonpointer.JSONPointer, maxValue int) (idx int, err error) {
+	if pointer.Depth() < 2 {
+		err = fmt.Errorf("postgres_userdao: invalid path %v", pointer)
+		return
+	}
+	idx, err = strconv.Atoi(pointer.Tokens()[1])
+	if err != nil {
+		if pointer.Tokens()[1] != "-" {
+			return
+		}
+		idx = maxValue
+		err = nil
+	}
+	if idx < 0 || idx > maxValue {
+		err = fmt.Errorf("postgres_userdao: out of range index (%v) max is (%v)", idx, maxValue)
+	}
+	return idx, err
 }
 
 type userDaoPG struct {
@@ -204,8 +468,8 @@ func (d *userDaoPG) Create(u *domain.User) error {
 
 	name := normalizeString(u.Name)
 	cpf := normalizeString(u.CPF)
-	fbID := normalizeString(u.FbID)
-	password := u.PasswordHash
+	// users logged in through an identity provider may have no password
+	password := normalizeString(u.PasswordHash)
 	primaryEmail, verifiedPrimaryEmail := inflateEmail(u.PrimaryEmail())
 	primaryPhone, verifiedPhone := inflatePhone(u.PrimaryPhone())
 	avatar := safeString(u.Avatar)
@@ -215,7 +479,7 @@ func (d *userDaoPG) Create(u *domain.User) error {
 		return err
 	}
 
-	row := d.stmts["insert"].QueryRow(name, cpf, fbID, avatar, password,
+	row := d.stmts["insert"].QueryRow(name, cpf, avatar, password,
 		primaryPhone, verifiedPhone,
 		primaryEmail, verifiedPrimaryEmail)
 
@@ -275,76 +539,179 @@ func (d *userDaoPG) Delete(id int64) error {
 	return err
 }
 
+// Update applies the whole patch in a single transaction
 func (d *userDaoPG) Update(id int64, patch patcher.Patch) error {
 	d.lazyPrepare()
 
-	return errors.New("unimplemented method")
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = d.update(tx, id, patch); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
-func (d *userDaoPG) Get(id int64) (*domain.User, error) {
-	u := domain.User{}
-	p := domain.Phone{}
-	e := domain.Email{}
+func (d *userDaoPG) update(tx *sql.Tx, id int64, patch patcher.Patch) error {
+	if _, err := tx.Stmt(d.stmts["lockByID"]).Exec(id); err != nil {
+		return err
+	}
 
-	d.lazyPrepare()
+	old, err := d.get(tx, id)
+	if err != nil {
+		return err
+	}
 
-	tx, err := d.db.Begin()
+	user := *old
+	user.Phones = append([]domain.Phone(nil), old.Phones...)
+	user.Emails = append([]domain.Email(nil), old.Emails...)
+	if err = patcher.Mend(userTailor{}, patch, &user); err != nil {
+		return err
+	}
 
-	if err != nil {
-		return nil, err
+	stmt := updateStmt{}
+	if user.Name != old.Name {
+		stmt.set("name", user.Name)
+	}
+	if user.CPF != old.CPF {
+		stmt.set("cpf", normalizeString(user.CPF))
+	}
+	if !reflect.DeepEqual(safeString(user.Avatar), safeString(old.Avatar)) {
+		stmt.set("avatar", safeString(user.Avatar))
+	}
+	if user.PasswordHash != old.PasswordHash {
+		// hashed by the tg_user_hash trigger
+		stmt.set("hash", user.PasswordHash)
+	}
+	if !reflect.DeepEqual(user.PrimaryPhone(), old.PrimaryPhone()) {
+		phone, verified := inflatePhone(user.PrimaryPhone())
+		stmt.set("phone", phone)
+		stmt.set("phone_verified", verified)
+	}
+	if !reflect.DeepEqual(user.PrimaryEmail(), old.PrimaryEmail()) {
+		email, verified := inflateEmail(user.PrimaryEmail())
+		stmt.set("email", email)
+		stmt.set("email_verified", verified)
 	}
 
-	{
-		var avatarString string
-		err = d.stmts["findByID"].QueryRow(id).Scan(&u.ID, &u.Name, &u.CPF, &u.FbID, &avatarString, &u.PasswordHash,
-			&p.Phone, &p.Verified, &e.Email, &e.Verified)
+	// the secondary phones and emails are removed before changing the primary ones and
+	// added after, otherwise the uniqueness checks fail when a primary one is swapped
+	for _, phone := range secondaryPhones(old.Phones) {
+		if phoneIndex(secondaryPhones(user.Phones), phone.Phone) < 0 {
+			if _, err = tx.Stmt(d.stmts["removePhone"]).Exec(id, phone.Phone); err != nil {
+				return err
+			}
+		}
+	}
+	for _, email := range secondaryEmails(old.Emails) {
+		if emailIndex(secondaryEmails(user.Emails), email.Email) < 0 {
+			if _, err = tx.Stmt(d.stmts["removeEmail"]).Exec(id, email.Email); err != nil {
+				return err
+			}
+		}
+	}
 
-		if err != nil {
-			tx.Rollback()
-			return nil, err
+	if err = stmt.exec(tx, id); err != nil {
+		return err
+	}
+
+	for _, phone := range secondaryPhones(user.Phones) {
+		if phoneIndex(secondaryPhones(old.Phones), phone.Phone) < 0 {
+			if _, err = tx.Stmt(d.stmts["addPhone"]).Exec(id, phone.Phone, phone.Verified); err != nil {
+				return err
+			}
 		}
-		u.Avatar, err = url.Parse(avatarString)
-		if err != nil {
-			tx.Rollback()
-			return nil, err
+	}
+	for _, email := range secondaryEmails(user.Emails) {
+		if emailIndex(secondaryEmails(old.Emails), email.Email) < 0 {
+			if _, err = tx.Stmt(d.stmts["addEmail"]).Exec(id, email.Email, email.Verified); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
+}
 
-	emails := []domain.Email{e}
-	phones := []domain.Phone{p}
+func (d *userDaoPG) Get(id int64) (*domain.User, error) {
+	d.lazyPrepare()
 
-	rows, err := d.stmts["queryEmails"].Query(id)
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
 
+	u, err := d.get(tx, id)
 	if err != nil {
 		tx.Rollback()
 		return nil, err
 	}
 
-	for rows.Next() {
-		email := domain.Email{}
-		rows.Scan(&email.Email, &email.Verified)
-		emails = append(emails, email)
+	if err = tx.Commit(); err != nil {
+		return nil, err
 	}
+	return u, nil
+}
 
-	rows, err = d.stmts["queryPhones"].Query(id)
+// get loads a user within tx, the primary phone and email come first
+func (d *userDaoPG) get(tx *sql.Tx, id int64) (*domain.User, error) {
+	u := domain.User{}
+	var cpf sql.NullInt64
+	var avatar, hash, phone, email sql.NullString
+	var phoneVerified, emailVerified sql.NullBool
 
+	err := tx.Stmt(d.stmts["findByID"]).QueryRow(id).Scan(&u.ID, &u.Name, &cpf, &avatar, &hash,
+		&phone, &phoneVerified, &email, &emailVerified)
 	if err != nil {
-		tx.Rollback()
 		return nil, err
 	}
 
-	for rows.Next() {
-		phone := domain.Phone{}
-		rows.Scan(&phone.Phone, &phone.Verified)
-		phones = append(phones, phone)
+	if cpf.Valid {
+		u.CPF = fmt.Sprintf("%011d", cpf.Int64)
+	}
+	u.PasswordHash = hash.String
+	if avatar.Valid {
+		if u.Avatar, err = url.Parse(avatar.String); err != nil {
+			return nil, err
+		}
+	}
+	if phone.Valid {
+		u.Phones = []domain.Phone{{Phone: phone.String, Verified: phoneVerified.Bool}}
+	}
+	if email.Valid {
+		u.Emails = []domain.Email{{Email: email.String, Verified: emailVerified.Bool}}
 	}
 
-	u.Emails = emails
-	u.Phones = phones
+	rows, err := tx.Stmt(d.stmts["queryEmails"]).Query(id)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		email := domain.Email{}
+		if err = rows.Scan(&email.Email, &email.Verified); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		u.Emails = append(u.Emails, email)
+	}
+	rows.Close()
 
-	if err = tx.Commit(); err != nil {
+	rows, err = tx.Stmt(d.stmts["queryPhones"]).Query(id)
+	if err != nil {
 		return nil, err
 	}
+	for rows.Next() {
+		phone := domain.Phone{}
+		if err = rows.Scan(&phone.Phone, &phone.Verified); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		u.Phones = append(u.Phones, phone)
+	}
+	rows.Close()
+
 	return &u, nil
 }
 
@@ -357,6 +724,260 @@ func (d *userDaoPG) Authenticate(credential string, password string) (int64, err
 	return id, err
 }
 
+func (d *userDaoPG) Lookup(credential string) (int64, error) {
+	d.lazyPrepare()
+	var id int64
+
+	lookups := []string{"mapEmailIntoID", "mapPhoneIntoID"}
+	var args []interface{}
+	for range lookups {
+		args = append(args, credential)
+	}
+	if cpf, err := strconv.ParseInt(credential, 10, 64); err == nil {
+		lookups = append([]string{"mapCPFIntoID"}, lookups...)
+		args = append([]interface{}{cpf}, args...)
+	}
+
+	for i, lookup := range lookups {
+		err := d.stmts[lookup].QueryRow(args[i]).Scan(&id)
+		if err == nil {
+			return id, nil
+		}
+		if err != sql.ErrNoRows {
+			return 0, err
+		}
+	}
+	return 0, errors.New("postgres_userdao: user not found")
+}
+
+func (d *userDaoPG) LookupIdentity(provider string, subject string) (int64, error) {
+	d.lazyPrepare()
+
+	var id int64
+	err := d.stmts["mapIdentityIntoID"].QueryRow(provider, subject).Scan(&id)
+	return id, err
+}
+
+// LinkIdentity links the identity to the user or refreshes its email when it's
+// already linked, an identity linked to another user is left untouched
+func (d *userDaoPG) LinkIdentity(userID int64, identity *domain.Identity) error {
+	if identity == nil || identity.Provider == "" || identity.Subject == "" {
+		return errors.New("postgres_userdao: invalid identity")
+	}
+	d.lazyPrepare()
+
+	err := d.stmts["linkIdentity"].QueryRow(userID, identity.Provider, identity.Subject,
+		normalizeString(identity.Email)).Scan(&identity.LinkedAt)
+	if err == sql.ErrNoRows {
+		return errors.New("postgres_userdao: identity linked to another user")
+	}
+	return err
+}
+
+func (d *userDaoPG) UnlinkIdentity(userID int64, provider string, subject string) error {
+	d.lazyPrepare()
+
+	result, err := d.stmts["unlinkIdentity"].Exec(userID, provider, subject)
+	if err != nil {
+		return err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return errors.New("postgres_userdao: identity not linked")
+	}
+	return nil
+}
+
+func (d *userDaoPG) Identities(userID int64) ([]*domain.Identity, error) {
+	d.lazyPrepare()
+
+	rows, err := d.stmts["queryIdentities"].Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*domain.Identity{}
+	for rows.Next() {
+		var identity domain.Identity
+		var email sql.NullString
+		if err = rows.Scan(&identity.Provider, &identity.Subject, &email, &identity.LinkedAt); err != nil {
+			return nil, err
+		}
+		identity.Email = email.String
+		identities = append(identities, &identity)
+	}
+	return identities, rows.Err()
+}
+
+func (d *userDaoPG) SetTOTPSecret(userID int64, secret []byte) error {
+	d.lazyPrepare()
+
+	var id int64
+	err := d.stmts["setTOTPSecret"].QueryRow(userID, secret).Scan(&id)
+	if err == sql.ErrNoRows {
+		return errors.New("postgres_userdao: totp already confirmed")
+	}
+	return err
+}
+
+func (d *userDaoPG) TOTPSecret(userID int64) ([]byte, bool, error) {
+	d.lazyPrepare()
+
+	var secret []byte
+	var confirmed bool
+	err := d.stmts["queryTOTPSecret"].QueryRow(userID).Scan(&secret, &confirmed)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	return secret, confirmed, err
+}
+
+// ConfirmTOTP confirms the pending secret and stores the recovery codes in one transaction
+func (d *userDaoPG) ConfirmTOTP(userID int64, recoveryCodeHashes [][]byte) error {
+	d.lazyPrepare()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Stmt(d.stmts["confirmTOTP"]).Exec(userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		tx.Rollback()
+		return errors.New("postgres_userdao: no totp waiting confirmation")
+	}
+
+	if _, err = tx.Stmt(d.stmts["deleteAllRecoveryCodes"]).Exec(userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err = tx.Stmt(d.stmts["addRecoveryCode"]).Exec(userID, hash); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *userDaoPG) RedeemRecoveryCode(userID int64, recoveryCodeHash []byte) error {
+	d.lazyPrepare()
+
+	result, err := d.stmts["removeRecoveryCode"].Exec(userID, recoveryCodeHash)
+	if err != nil {
+		return err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return errors.New("postgres_userdao: invalid recovery code")
+	}
+	return nil
+}
+
+func (d *userDaoPG) RemoveTOTP(userID int64) error {
+	d.lazyPrepare()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range []string{"deleteAllRecoveryCodes", "deleteTOTP"} {
+		if _, err = tx.Stmt(d.stmts[stmt]).Exec(userID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *userDaoPG) AddCredential(userID int64, credential *domain.Credential) error {
+	if credential == nil || len(credential.ID) == 0 || len(credential.PublicKey) == 0 {
+		return errors.New("postgres_userdao: invalid credential")
+	}
+	d.lazyPrepare()
+
+	return d.stmts["addCredential"].QueryRow(credential.ID, userID, credential.Name,
+		credential.PublicKey, int64(credential.SignCount)).Scan(&credential.CreatedAt)
+}
+
+func (d *userDaoPG) LookupCredential(credentialID []byte) (int64, *domain.Credential, error) {
+	d.lazyPrepare()
+
+	var userID int64
+	var signCount int64
+	var lastUsedAt *time.Time
+	credential := &domain.Credential{ID: credentialID}
+	err := d.stmts["queryCredential"].QueryRow(credentialID).Scan(&userID, &credential.Name,
+		&credential.PublicKey, &signCount, &credential.CreatedAt, &lastUsedAt)
+	if err == sql.ErrNoRows {
+		return 0, nil, errors.New("postgres_userdao: credential not found")
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	credential.SignCount = uint32(signCount)
+	if lastUsedAt != nil {
+		credential.LastUsedAt = *lastUsedAt
+	}
+	return userID, credential, nil
+}
+
+func (d *userDaoPG) UseCredential(credentialID []byte, signCount uint32) error {
+	d.lazyPrepare()
+
+	result, err := d.stmts["useCredential"].Exec(credentialID, int64(signCount))
+	if err != nil {
+		return err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return errors.New("postgres_userdao: sign count didn't increase")
+	}
+	return nil
+}
+
+func (d *userDaoPG) RemoveCredential(userID int64, credentialID []byte) error {
+	d.lazyPrepare()
+
+	result, err := d.stmts["removeCredential"].Exec(userID, credentialID)
+	if err != nil {
+		return err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return errors.New("postgres_userdao: credential not found")
+	}
+	return nil
+}
+
+func (d *userDaoPG) Credentials(userID int64) ([]*domain.Credential, error) {
+	d.lazyPrepare()
+
+	rows, err := d.stmts["queryCredentials"].Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []*domain.Credential{}
+	for rows.Next() {
+		var credential domain.Credential
+		var signCount int64
+		var lastUsedAt *time.Time
+		if err = rows.Scan(&credential.ID, &credential.Name, &credential.PublicKey, &signCount,
+			&credential.CreatedAt, &lastUsedAt); err != nil {
+			return nil, err
+		}
+		credential.SignCount = uint32(signCount)
+		if lastUsedAt != nil {
+			credential.LastUsedAt = *lastUsedAt
+		}
+		credentials = append(credentials, &credential)
+	}
+	return credentials, rows.Err()
+}
+
 func (d *userDaoPG) AuthorizeClient(userID int64, clientPublicID string, scope domain.Scope) error {
 	d.lazyPrepare()
 	clientID, err := convertPublicIDIntoClientID(clientPublicID)
@@ -369,7 +990,7 @@ func (d *userDaoPG) AuthorizeClient(userID int64, clientPublicID string, scope d
 		return err
 	}
 
-	result, err := d.stmts["authorizeClient"].Exec(clientID, userID, []string(scope))
+	result, err := tx.Stmt(d.stmts["authorizeClient"]).Exec(clientID, userID, pq.Array(scope))
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -380,8 +1001,78 @@ func (d *userDaoPG) AuthorizeClient(userID int64, clientPublicID string, scope d
 		return fmt.Errorf("pg: scope not registered: %q", strings.Join(scope, " "))
 	}
 
-	tx.Commit()
+	return tx.Commit()
+}
 
+func (d *userDaoPG) RevokeClient(userID int64, clientPublicID string) error {
+	d.lazyPrepare()
+	clientID, err := convertPublicIDIntoClientID(clientPublicID)
+	if err != nil {
+		return errors.New("pg: invalid clientPublicID")
+	}
+
+	result, err := d.stmts["revokeClient"].Exec(userID, clientID)
+	if err != nil {
+		return err
+	}
+
+	if count, _ := result.RowsAffected(); count == 0 {
+		return errors.New("pg: client not authorized")
+	}
+	return nil
+}
+
+// SetPassword writes the password on the hash column, it's hashed by the tg_user_hash trigger
+func (d *userDaoPG) SetPassword(userID int64, password string) error {
+	d.lazyPrepare()
+
+	result, err := d.stmts["setPassword"].Exec(userID, password)
+	if err != nil {
+		return err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return errors.New("postgres_userdao: no user found")
+	}
+	return nil
+}
+
+// VerifyEmail marks the email of a user as verified, either the primary one or a secondary one
+func (d *userDaoPG) VerifyEmail(userID int64, email string) error {
+	d.lazyPrepare()
+
+	var verified int64
+	for _, stmt := range []string{"verifyPrimaryEmail", "verifyEmail"} {
+		result, err := d.stmts[stmt].Exec(userID, email)
+		if err != nil {
+			return err
+		}
+		count, _ := result.RowsAffected()
+		verified += count
+	}
+
+	if verified == 0 {
+		return errors.New("postgres_userdao: email not found")
+	}
+	return nil
+}
+
+// VerifyPhone marks the phone of a user as verified, either the primary one or a secondary one
+func (d *userDaoPG) VerifyPhone(userID int64, phone string) error {
+	d.lazyPrepare()
+
+	var verified int64
+	for _, stmt := range []string{"verifyPrimaryPhone", "verifyPhone"} {
+		result, err := d.stmts[stmt].Exec(userID, phone)
+		if err != nil {
+			return err
+		}
+		count, _ := result.RowsAffected()
+		verified += count
+	}
+
+	if verified == 0 {
+		return errors.New("postgres_userdao: phone not found")
+	}
 	return nil
 }
 
@@ -394,6 +1085,22 @@ func safeString(url *url.URL) *string {
 	return &s
 }
 
+// secondaryPhones are the phones kept on the user_phone table
+func secondaryPhones(phones []domain.Phone) []domain.Phone {
+	if len(phones) == 0 {
+		return nil
+	}
+	return phones[1:]
+}
+
+// secondaryEmails are the emails kept on the user_email table
+func secondaryEmails(emails []domain.Email) []domain.Email {
+	if len(emails) == 0 {
+		return nil
+	}
+	return emails[1:]
+}
+
 func normalizeString(s string) *string {
 	s = strings.TrimSpace(s)
 	if s == "" {
@@ -3,10 +3,12 @@ package postgres
 import (
 	"database/sql"
 	"net/url"
+	"reflect"
 	"testing"
 
 	"github.com/gabriel-araujjo/condominio-auth/dao/internal/postgres/mock"
 	"github.com/gabriel-araujjo/condominio-auth/domain"
+	patcher "github.com/gabriel-araujjo/json-patcher"
 )
 
 func TestUserDaoPg(t *testing.T) {
@@ -34,7 +36,6 @@ func TestUserDaoPg(t *testing.T) {
 			user: &domain.User{
 				Name:   "Fulano",
 				CPF:    "61772443514",
-				FbID:   "1111111111",
 				Avatar: avatar,
 				Phones: []domain.Phone{{
 					Phone:    "447588164927",
@@ -256,6 +257,186 @@ func TestUserDaoPg(t *testing.T) {
 		}
 	})
 
+	t.Run("Identity", func(t *testing.T) {
+		facebook := &domain.Identity{Provider: "facebook", Subject: "1111111111", Email: "fulano@email.com"}
+		if err := userDao.LinkIdentity(1, facebook); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if err := userDao.LinkIdentity(1, &domain.Identity{Provider: "gov.br", Subject: "61772443514"}); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if id, err := userDao.LookupIdentity("facebook", "1111111111"); err != nil || id != 1 {
+			t.Errorf("1 should be returned instead of %d (err %v)", id, err)
+		}
+		if identities, err := userDao.Identities(1); err != nil || len(identities) != 2 {
+			t.Errorf("the 2 linked identities should be listed instead of %v (err %v)", identities, err)
+		}
+		if err := userDao.LinkIdentity(2, &domain.Identity{Provider: "facebook", Subject: "1111111111"}); err == nil {
+			t.Error("err should be returned for an identity linked to another user")
+		}
+
+		if err := userDao.UnlinkIdentity(1, "gov.br", "61772443514"); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if err := userDao.UnlinkIdentity(1, "gov.br", "61772443514"); err == nil {
+			t.Error("err should be returned for an identity not linked")
+		}
+	})
+
+	t.Run("TOTP", func(t *testing.T) {
+		if err := userDao.SetTOTPSecret(1, []byte("old secret")); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if err := userDao.SetTOTPSecret(1, []byte("secret")); err != nil {
+			t.Errorf("a pending secret should be replaced instead of %q", err)
+		}
+		if secret, confirmed, err := userDao.TOTPSecret(1); err != nil || string(secret) != "secret" || confirmed {
+			t.Errorf("a pending secret should be returned instead of %q, %v (err %v)", secret, confirmed, err)
+		}
+
+		if err := userDao.ConfirmTOTP(1, [][]byte{[]byte("code 1"), []byte("code 2")}); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if _, confirmed, _ := userDao.TOTPSecret(1); !confirmed {
+			t.Error("the secret should be confirmed")
+		}
+		if err := userDao.SetTOTPSecret(1, []byte("other")); err == nil {
+			t.Error("err should be returned replacing a confirmed secret")
+		}
+
+		if err := userDao.RedeemRecoveryCode(1, []byte("code 1")); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if err := userDao.RedeemRecoveryCode(1, []byte("code 1")); err == nil {
+			t.Error("err should be returned for a redeemed recovery code")
+		}
+
+		if err := userDao.RemoveTOTP(1); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if secret, _, err := userDao.TOTPSecret(1); err != nil || secret != nil {
+			t.Errorf("no secret should be returned instead of %q (err %v)", secret, err)
+		}
+		if err := userDao.RedeemRecoveryCode(1, []byte("code 2")); err == nil {
+			t.Error("err should be returned for a removed recovery code")
+		}
+	})
+
+	t.Run("Credentials", func(t *testing.T) {
+		credential := &domain.Credential{ID: []byte("credential"), Name: "Phone", PublicKey: []byte("key")}
+		if err := userDao.AddCredential(1, credential); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if credential.CreatedAt.IsZero() {
+			t.Error("the registration time should be set")
+		}
+		if err := userDao.AddCredential(1, &domain.Credential{ID: []byte("credential"), PublicKey: []byte("key")}); err == nil {
+			t.Error("err should be returned registering a credential twice")
+		}
+
+		userID, found, err := userDao.LookupCredential([]byte("credential"))
+		if err != nil || userID != 1 || found.Name != "Phone" || string(found.PublicKey) != "key" {
+			t.Errorf("the credential of user 1 should be found instead of %d, %v (err %v)", userID, found, err)
+		}
+		if _, _, err = userDao.LookupCredential([]byte("unknown")); err == nil {
+			t.Error("err should be returned for an unknown credential")
+		}
+
+		if err = userDao.UseCredential([]byte("credential"), 5); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if err = userDao.UseCredential([]byte("credential"), 5); err == nil {
+			t.Error("err should be returned when the sign count doesn't increase")
+		}
+		if _, found, _ = userDao.LookupCredential([]byte("credential")); found.SignCount != 5 || found.LastUsedAt.IsZero() {
+			t.Errorf("the sign count should be 5 instead of %d", found.SignCount)
+		}
+
+		if credentials, err := userDao.Credentials(1); err != nil || len(credentials) != 1 {
+			t.Errorf("one credential should be listed instead of %v (err %v)", credentials, err)
+		}
+		if err = userDao.RemoveCredential(2, []byte("credential")); err == nil {
+			t.Error("err should be returned removing a credential of another user")
+		}
+		if err = userDao.RemoveCredential(1, []byte("credential")); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if credentials, err := userDao.Credentials(1); err != nil || len(credentials) != 0 {
+			t.Errorf("no credential should be listed instead of %v (err %v)", credentials, err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		cases := []struct {
+			name      string
+			patch     patcher.Patch
+			expectErr bool
+			check     func(u *domain.User) bool
+		}{{
+			name: "PrimaryEmail",
+			patch: patcher.Patch{
+				{Op: "move", From: "/emails/1", Path: "/emails/0"},
+			},
+			check: func(u *domain.User) bool {
+				return reflect.DeepEqual(u.Emails, []domain.Email{
+					{Email: "fulano@email2.com", Verified: false},
+					{Email: "fulano@email.com", Verified: true},
+				})
+			},
+		}, {
+			name: "ReplacedWithSameEmailKeepsVerified",
+			patch: patcher.Patch{
+				{Op: "replace", Path: "/emails/1", Value: "fulano@email.com"},
+			},
+			check: func(u *domain.User) bool {
+				return reflect.DeepEqual(u.Emails[1], domain.Email{Email: "fulano@email.com", Verified: true})
+			},
+		}, {
+			name: "ReplacedPhoneIsNotVerified",
+			patch: patcher.Patch{
+				{Op: "replace", Path: "/phones/0", Value: "558499990000"},
+			},
+			check: func(u *domain.User) bool {
+				return reflect.DeepEqual(u.Phones[0], domain.Phone{Phone: "558499990000", Verified: false})
+			},
+		}, {
+			name: "RemoveSecondaryPhone",
+			patch: patcher.Patch{
+				{Op: "remove", Path: "/phones/1"},
+			},
+			check: func(u *domain.User) bool {
+				return len(u.Phones) == 1
+			},
+		}, {
+			name: "Atomic",
+			patch: patcher.Patch{
+				{Op: "replace", Path: "/name", Value: "Ciclano"},
+				{Op: "add", Path: "/emails/-", Value: "invalid email"},
+			},
+			expectErr: true,
+			check: func(u *domain.User) bool {
+				return u.Name == "Fulano" && len(u.Emails) == 2
+			},
+		}}
+
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				err := userDao.Update(1, tt.patch)
+				if tt.expectErr {
+					if err == nil {
+						t.Errorf("test %q: should err be returned", tt.name)
+					}
+				} else if err != nil {
+					t.Errorf("test %q: should err be nil instead of %q", tt.name, err)
+				}
+
+				if u, _ := userDao.Get(1); u == nil || !tt.check(u) {
+					t.Errorf("test %q: unexpected user %+v", tt.name, u)
+				}
+			})
+		}
+	})
+
 	t.Run("Delete", func(t *testing.T) {
 		cases := []struct {
 			name      string
@@ -28,8 +28,22 @@ type Claims struct {
 	Locale string `json:"locale"`
 	// Roles has the roles allowed
 	Scope []string `json:"scope"`
+	// AMR are the methods used to authenticate the user
+	AMR []string `json:"amr,omitempty"`
 }
 
+// Authentication methods of the amr claim, see https://tools.ietf.org/html/rfc8176
+const (
+	AMRPassword    = "pwd"
+	AMROTP         = "otp"
+	AMRMultiFactor = "mfa"
+	// AMRHardwareKey is a login with a WebAuthn credential
+	AMRHardwareKey = "hwk"
+	// AMRFederated isn't registered, it tells the user logged in through an
+	// upstream identity provider
+	AMRFederated = "fed"
+)
+
 // ContainScope checks whether this claim cover the scope passed
 func (c *Claims) ContainScope(scope ...string) bool {
 	for _, s := range scope {
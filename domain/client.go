@@ -1,9 +1,51 @@
 package domain
 
+// ScopeRegistration allows a client to create accounts on behalf of new users
+const ScopeRegistration = "registration"
+
 // Client stores oauth client info
 type Client struct {
 	ID       int64  `json:"id"`        // ID is the internal id of the client
 	Name     string `json:"name"`      // Name is the client display name
 	PublicID string `json:"public_id"` // PublicID is the client public id
 	Secret   string `json:"secret"`    // Secret is required for generate an access token to create accounts
+	// RequirePKCE makes the client send a PKCE code challenge on every authorization request
+	RequirePKCE bool `json:"require_pkce"`
+	// RedirectURIs are the only URIs the authorization end point redirects to
+	RedirectURIs []string `json:"redirect_uris"`
+	// FirstParty marks clients developed by us, trusted to handle the user's password
+	FirstParty bool `json:"first_party"`
+	// Scope is what the client may access on its own behalf through the client_credentials grant
+	Scope Scope `json:"scope"`
+	// UserScope is the most a first-party client may ask on behalf of a user through the password grant
+	UserScope Scope `json:"user_scope"`
+}
+
+// Authorization is the scope a user granted to a client
+type Authorization struct {
+	ClientID   string `json:"client_id"`   // ClientID is the client public id
+	ClientName string `json:"client_name"` // ClientName is the client display name
+	Scope      Scope  `json:"scope"`
+}
+
+// HasRedirectURI returns whether uri exactly matches one of the registered redirect URIs
+// https://tools.ietf.org/html/rfc6749#section-3.1.2.3
+func (c *Client) HasRedirectURI(uri string) bool {
+	for _, registered := range c.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPublic returns whether the client can't keep a secret, like SPAs and mobile apps.
+// Public clients must use PKCE.
+func (c *Client) IsPublic() bool {
+	return c.Secret == ""
+}
+
+// MustUsePKCE returns whether authorization requests of the client require a PKCE code challenge
+func (c *Client) MustUsePKCE() bool {
+	return c.RequirePKCE || c.IsPublic()
 }
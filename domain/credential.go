@@ -0,0 +1,17 @@
+package domain
+
+import "time"
+
+// Credential is a WebAuthn public key credential, a passkey the user logs in
+// with instead of a password. A user may register several of them.
+type Credential struct {
+	ID []byte // The credential id chosen by the authenticator
+	// Name is given by the user to tell the credentials apart
+	Name string
+	// PublicKey is the COSE encoded public key of the credential
+	PublicKey []byte
+	// SignCount is the signature counter last reported by the authenticator
+	SignCount  uint32
+	CreatedAt  time.Time
+	LastUsedAt time.Time // Zero when never used to log in
+}
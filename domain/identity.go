@@ -0,0 +1,12 @@
+package domain
+
+import "time"
+
+// Identity is an account of the user on an upstream identity provider,
+// a user may log in with several of them
+type Identity struct {
+	Provider string    `json:"provider"` // The provider name, e.g. facebook
+	Subject  string    `json:"subject"`  // The user id on the provider
+	Email    string    `json:"email,omitempty"`
+	LinkedAt time.Time `json:"linked_at"`
+}
@@ -6,8 +6,8 @@ type Scope []string
 // HasSubscope returns whether this scope has the scope passed as argument
 func (s Scope) HasSubscope(subScope Scope) bool {
 OUTER:
-	for tryScope := range subScope {
-		for allowedScope := range s {
+	for _, tryScope := range subScope {
+		for _, allowedScope := range s {
 			if tryScope == allowedScope {
 				continue OUTER
 			}
@@ -16,3 +16,20 @@ OUTER:
 	}
 	return true
 }
+
+// scopeDescriptions tell the user what each scope grants on the consent screen
+var scopeDescriptions = map[string]string{
+	ScopeOpenID:  "Sign you in with your account",
+	ScopeProfile: "See your name and picture",
+	ScopeEmail:   "See your email address",
+	ScopePhone:   "See your phone number",
+}
+
+// ScopeDescription returns the description of a scope shown to users,
+// scopes without a description are shown by their names
+func ScopeDescription(scope string) string {
+	if description, ok := scopeDescriptions[scope]; ok {
+		return description
+	}
+	return scope
+}
@@ -21,7 +21,6 @@ type User struct {
 	ID           int64    `json:"id"`
 	Name         string   `json:"name"`
 	CPF          string   `json:"cpf"`
-	FbID         string   `json:"fb_id"`
 	Avatar       *url.URL `json:"avatar"`
 	Phones       []Phone  `json:"phones"`
 	Emails       []Email  `json:"emails"`
@@ -0,0 +1,53 @@
+package domain
+
+import "strconv"
+
+// OpenID Connect scopes requesting claims, as defined in
+// https://openid.net/specs/openid-connect-core-1_0.html#ScopeClaims
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+	ScopePhone   = "phone"
+)
+
+// OpenIDScopes are the scopes every server knows, advertised on discovery
+var OpenIDScopes = Scope{ScopeOpenID, ScopeProfile, ScopeEmail, ScopePhone}
+
+// UserInfo holds the claims about a user returned by the userinfo end point
+type UserInfo struct {
+	Subject string `json:"sub"`
+	// profile scope
+	Name    string `json:"name,omitempty"`
+	Picture string `json:"picture,omitempty"`
+	// email scope
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	// phone scope
+	PhoneNumber         string `json:"phone_number,omitempty"`
+	PhoneNumberVerified *bool  `json:"phone_number_verified,omitempty"`
+}
+
+// UserInfo returns the claims about the user allowed by scope.
+// The email and phone claims come from the primary email and phone.
+func (u *User) UserInfo(scope Scope) *UserInfo {
+	info := &UserInfo{Subject: strconv.FormatInt(u.ID, 10)}
+
+	if scope.HasSubscope(Scope{ScopeProfile}) {
+		info.Name = u.Name
+		if u.Avatar != nil {
+			info.Picture = u.Avatar.String()
+		}
+	}
+
+	if email := u.PrimaryEmail(); email != nil && scope.HasSubscope(Scope{ScopeEmail}) {
+		info.Email = email.Email
+		info.EmailVerified = &email.Verified
+	}
+
+	if phone := u.PrimaryPhone(); phone != nil && scope.HasSubscope(Scope{ScopePhone}) {
+		info.PhoneNumber = phone.Phone
+		info.PhoneNumberVerified = &phone.Verified
+	}
+	return info
+}
@@ -0,0 +1,100 @@
+package domain
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var (
+	// emailRegexp is the same check applied by the database
+	emailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+$`)
+	// phoneRegexp matches phones with country and area codes, digits only
+	phoneRegexp = regexp.MustCompile(`^\d{10,13}$`)
+)
+
+// MinPasswordLength is the minimum amount of characters of a password
+const MinPasswordLength = 8
+
+// FieldErrors maps each invalid field to the reason it's invalid
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field, reason := range e {
+		fields = append(fields, fmt.Sprintf("%s: %s", field, reason))
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ", ")
+}
+
+// NormalizeCPF removes the punctuation of a formatted CPF
+func NormalizeCPF(cpf string) string {
+	return strings.NewReplacer(".", "", "-", "", " ", "").Replace(cpf)
+}
+
+// ValidCPF checks the CPF check digits, punctuation is ignored
+func ValidCPF(cpf string) bool {
+	cpf = NormalizeCPF(cpf)
+	if len(cpf) != 11 || strings.Count(cpf, cpf[:1]) == 11 {
+		return false
+	}
+
+	digits := make([]int, 11)
+	for i, r := range cpf {
+		if r < '0' || r > '9' {
+			return false
+		}
+		digits[i] = int(r - '0')
+	}
+
+	for checked := 9; checked <= 10; checked++ {
+		sum := 0
+		for i := 0; i < checked; i++ {
+			sum += digits[i] * (checked + 1 - i)
+		}
+		dv := sum % 11
+		if dv < 2 {
+			dv = 0
+		} else {
+			dv = 11 - dv
+		}
+		if digits[checked] != dv {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidEmail checks the email format
+func ValidEmail(email string) bool {
+	return emailRegexp.MatchString(email)
+}
+
+// ValidPhone checks whether phone has only digits, including country and area codes
+func ValidPhone(phone string) bool {
+	return phoneRegexp.MatchString(phone)
+}
+
+// CheckPasswordStrength returns why a password is weak or an empty string
+func CheckPasswordStrength(password string) string {
+	var letters, digits int
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			letters++
+		case unicode.IsDigit(r):
+			digits++
+		}
+	}
+
+	switch {
+	case len([]rune(password)) < MinPasswordLength:
+		return fmt.Sprintf("must have at least %d characters", MinPasswordLength)
+	case letters == 0 || digits == 0:
+		return "must have letters and digits"
+	}
+	return ""
+}
@@ -0,0 +1,33 @@
+package domain
+
+import "testing"
+
+func TestValidCPF(t *testing.T) {
+	valid := []string{"95115469103", "951.154.691-03", "52998224725"}
+	invalid := []string{"", "95115469104", "11111111111", "9511546910", "9511546910a"}
+
+	for _, cpf := range valid {
+		if !ValidCPF(cpf) {
+			t.Errorf("cpf %q should be valid", cpf)
+		}
+	}
+	for _, cpf := range invalid {
+		if ValidCPF(cpf) {
+			t.Errorf("cpf %q should be invalid", cpf)
+		}
+	}
+}
+
+func TestCheckPasswordStrength(t *testing.T) {
+	tests := map[string]bool{
+		"short1":      false,
+		"onlyletters": false,
+		"1234567890":  false,
+		"condominio1": true,
+	}
+	for password, strong := range tests {
+		if reason := CheckPasswordStrength(password); (reason == "") != strong {
+			t.Errorf("test %q: unexpected strength %q", password, reason)
+		}
+	}
+}
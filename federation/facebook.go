@@ -0,0 +1,123 @@
+package federation
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// facebookEndpoints are the Facebook Login end points
+type facebookEndpoints struct {
+	AuthURL  string
+	TokenURL string
+	GraphURL string
+}
+
+var defaultFacebookEndpoints = facebookEndpoints{
+	AuthURL:  "https://www.facebook.com/v3.2/dialog/oauth",
+	TokenURL: "https://graph.facebook.com/v3.2/oauth/access_token",
+	GraphURL: "https://graph.facebook.com/v3.2",
+}
+
+// Facebook logs users in with Facebook Login, the subject of the identities
+// is the app-scoped user id
+type Facebook struct {
+	name         string
+	clientID     string
+	clientSecret string
+	scope        []string
+	endpoints    facebookEndpoints
+	client       *http.Client
+}
+
+// NewFacebook creates a Facebook provider for the app identified by clientID
+func NewFacebook(name string, clientID string, clientSecret string, scope []string) *Facebook {
+	return &Facebook{name, clientID, clientSecret, scope, defaultFacebookEndpoints, defaultClient}
+}
+
+// Name returns the provider name
+func (f *Facebook) Name() string {
+	return f.name
+}
+
+// AuthCodeURL returns the Facebook login dialog URL, the nonce is not
+// supported by Facebook Login and it's ignored
+func (f *Facebook) AuthCodeURL(state string, nonce string, redirectURI string) (string, error) {
+	query := url.Values{}
+	query.Set("client_id", f.clientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("response_type", "code")
+	query.Set("state", state)
+	if len(f.scope) > 0 {
+		query.Set("scope", strings.Join(f.scope, ","))
+	}
+	return f.endpoints.AuthURL + "?" + query.Encode(), nil
+}
+
+// Exchange redeems the code for an access token and reads the user profile
+// from the Graph API
+func (f *Facebook) Exchange(code string, redirectURI string, nonce string) (*Identity, error) {
+	query := url.Values{}
+	query.Set("client_id", f.clientID)
+	query.Set("client_secret", f.clientSecret)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("code", code)
+
+	res, err := f.client.Get(f.endpoints.TokenURL + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err = decodeResponse(res, &token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, ErrInvalidIdentity
+	}
+
+	// appsecret_proof proves the token is used by the app it was issued to
+	proof := hmac.New(sha256.New, []byte(f.clientSecret))
+	proof.Write([]byte(token.AccessToken))
+
+	query = url.Values{}
+	query.Set("fields", "id,name,email,picture")
+	query.Set("access_token", token.AccessToken)
+	query.Set("appsecret_proof", hex.EncodeToString(proof.Sum(nil)))
+
+	res, err = f.client.Get(f.endpoints.GraphURL + "/me?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var profile struct {
+		ID      string `json:"id"`
+		Name    string `json:"name"`
+		Email   string `json:"email"`
+		Picture struct {
+			Data struct {
+				URL string `json:"url"`
+			} `json:"data"`
+		} `json:"picture"`
+	}
+	if err = decodeResponse(res, &profile); err != nil {
+		return nil, err
+	}
+	if profile.ID == "" {
+		return nil, ErrInvalidIdentity
+	}
+
+	return &Identity{
+		Provider: f.name,
+		Subject:  profile.ID,
+		Name:     profile.Name,
+		Email:    profile.Email,
+		// Facebook doesn't tell whether the user still owns the email,
+		// so it is never trusted to link accounts
+		EmailVerified: false,
+		Picture:       profile.Picture.Data.URL,
+	}, nil
+}
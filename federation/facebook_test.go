@@ -0,0 +1,98 @@
+package federation
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// newFakeFacebook starts a local Graph API knowing a single user
+func newFakeFacebook() (*httptest.Server, *Facebook) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/access_token", func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if query.Get("client_id") != "app" || query.Get("client_secret") != "secret" ||
+			query.Get("code") != "code" || query.Get("redirect_uri") != "http://localhost/callback" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]string{"message": "Invalid verification code format."},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token", "token_type": "bearer", "expires_in": 5183944,
+		})
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, req *http.Request) {
+		proof := hmac.New(sha256.New, []byte("secret"))
+		proof.Write([]byte("token"))
+		query := req.URL.Query()
+		if query.Get("access_token") != "token" || query.Get("appsecret_proof") != hex.EncodeToString(proof.Sum(nil)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"id": "1111111111", "name": "Fulano", "email": "fulano@email.com",
+			"picture": {"data": {"url": "https://example.com/fulano.jpg"}}}`))
+	})
+	server := httptest.NewServer(mux)
+
+	provider := NewFacebook("facebook", "app", "secret", []string{"public_profile", "email"})
+	provider.endpoints = facebookEndpoints{
+		AuthURL:  server.URL + "/dialog/oauth",
+		TokenURL: server.URL + "/oauth/access_token",
+		GraphURL: server.URL,
+	}
+	return server, provider
+}
+
+func TestFacebook_AuthCodeURL(t *testing.T) {
+	server, provider := newFakeFacebook()
+	defer server.Close()
+
+	authURL, err := provider.AuthCodeURL("state", "nonce", "http://localhost/callback")
+	if err != nil {
+		t.Fatalf("err should be nil instead of %q", err)
+	}
+	parsed, _ := url.Parse(authURL)
+	expected := map[string]string{
+		"client_id":    "app",
+		"redirect_uri": "http://localhost/callback",
+		"state":        "state",
+		"scope":        "public_profile,email",
+	}
+	for param, value := range expected {
+		if got := parsed.Query().Get(param); got != value {
+			t.Errorf("%s should be %q instead of %q", param, value, got)
+		}
+	}
+}
+
+func TestFacebook_Exchange(t *testing.T) {
+	server, provider := newFakeFacebook()
+	defer server.Close()
+
+	identity, err := provider.Exchange("code", "http://localhost/callback", "nonce")
+	if err != nil {
+		t.Fatalf("err should be nil instead of %q", err)
+	}
+	expected := Identity{
+		Provider:      "facebook",
+		Subject:       "1111111111",
+		Name:          "Fulano",
+		Email:         "fulano@email.com",
+		EmailVerified: false,
+		Picture:       "https://example.com/fulano.jpg",
+	}
+	if *identity != expected {
+		t.Errorf("identity should be %+v instead of %+v", expected, *identity)
+	}
+
+	if _, err = provider.Exchange("other", "http://localhost/callback", "nonce"); err == nil {
+		t.Error("err should be returned for an invalid code")
+	}
+}
@@ -0,0 +1,187 @@
+package federation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// fakeOIDCProvider is a local OpenID Connect provider answering every code
+// with an ID token carrying claims
+type fakeOIDCProvider struct {
+	*httptest.Server
+	claims map[string]interface{}
+}
+
+func newFakeOIDCProvider() *fakeOIDCProvider {
+	p := &fakeOIDCProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		clientID, secret, _ := req.BasicAuth()
+		if req.Method != http.MethodPost || clientID != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		if req.PostFormValue("code") != "code" || req.PostFormValue("redirect_uri") != "http://localhost/callback" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		payload, _ := json.Marshal(p.claims)
+		idToken := "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(payload) + ".c2lnbmF0dXJl"
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "token", "id_token": idToken})
+	})
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *fakeOIDCProvider) validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            p.URL,
+		"sub":            "248289761001",
+		"aud":            "client",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          "nonce",
+		"name":           "Fulano",
+		"email":          "fulano@email.com",
+		"email_verified": true,
+	}
+}
+
+func TestOIDC_AuthCodeURL(t *testing.T) {
+	fake := newFakeOIDCProvider()
+	defer fake.Close()
+	provider := NewOIDC("fake", fake.URL, "client", "secret", []string{"openid", "email"})
+
+	authURL, err := provider.AuthCodeURL("state", "nonce", "http://localhost/callback")
+	if err != nil {
+		t.Fatalf("err should be nil instead of %q", err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("err should be nil instead of %q", err)
+	}
+	if parsed.Path != "/authorize" {
+		t.Errorf("the authorization end point should be used instead of %q", parsed.Path)
+	}
+	expected := map[string]string{
+		"client_id":     "client",
+		"redirect_uri":  "http://localhost/callback",
+		"response_type": "code",
+		"scope":         "openid email",
+		"state":         "state",
+		"nonce":         "nonce",
+	}
+	for param, value := range expected {
+		if got := parsed.Query().Get(param); got != value {
+			t.Errorf("%s should be %q instead of %q", param, value, got)
+		}
+	}
+
+	if _, err = NewOIDC("fake", fake.URL+"/other", "client", "secret", nil).AuthCodeURL("state", "nonce", ""); err == nil {
+		t.Error("err should be returned for an issuer without discovery")
+	}
+}
+
+func TestOIDC_Exchange(t *testing.T) {
+	fake := newFakeOIDCProvider()
+	defer fake.Close()
+
+	t.Run("Valid", func(t *testing.T) {
+		fake.claims = fake.validClaims()
+		provider := NewOIDC("fake", fake.URL, "client", "secret", nil)
+
+		identity, err := provider.Exchange("code", "http://localhost/callback", "nonce")
+		if err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+		expected := Identity{
+			Provider:      "fake",
+			Subject:       "248289761001",
+			Name:          "Fulano",
+			Email:         "fulano@email.com",
+			EmailVerified: true,
+		}
+		if *identity != expected {
+			t.Errorf("identity should be %+v instead of %+v", expected, *identity)
+		}
+	})
+
+	t.Run("EmailVerifiedAsString", func(t *testing.T) {
+		fake.claims = fake.validClaims()
+		fake.claims["email_verified"] = "false"
+		provider := NewOIDC("fake", fake.URL, "client", "secret", nil)
+
+		identity, err := provider.Exchange("code", "http://localhost/callback", "nonce")
+		if err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+		if identity.EmailVerified {
+			t.Error("the email should not be verified")
+		}
+	})
+
+	cases := []struct {
+		name   string
+		change func(claims map[string]interface{})
+		code   string
+		secret string
+	}{{
+		name:   "WrongIssuer",
+		change: func(claims map[string]interface{}) { claims["iss"] = "https://other.example.com" },
+	}, {
+		name:   "WrongAudience",
+		change: func(claims map[string]interface{}) { claims["aud"] = []string{"other"} },
+	}, {
+		name:   "MultipleAudiencesWithoutAzp",
+		change: func(claims map[string]interface{}) { claims["aud"] = []string{"client", "other"} },
+	}, {
+		name:   "Expired",
+		change: func(claims map[string]interface{}) { claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+	}, {
+		name:   "WrongNonce",
+		change: func(claims map[string]interface{}) { claims["nonce"] = "other" },
+	}, {
+		name:   "MissingSubject",
+		change: func(claims map[string]interface{}) { delete(claims, "sub") },
+	}, {
+		name: "InvalidCode",
+		code: "other",
+	}, {
+		name:   "InvalidClient",
+		secret: "other",
+	}}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			fake.claims = fake.validClaims()
+			if tt.change != nil {
+				tt.change(fake.claims)
+			}
+			code, secret := "code", "secret"
+			if tt.code != "" {
+				code = tt.code
+			}
+			if tt.secret != "" {
+				secret = tt.secret
+			}
+			provider := NewOIDC("fake", fake.URL, "client", secret, nil)
+
+			if _, err := provider.Exchange(code, "http://localhost/callback", "nonce"); err == nil {
+				t.Errorf("test %q: err should be returned", tt.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+// Package federation logs users in through upstream identity providers
+package federation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gabriel-araujjo/condominio-auth/config"
+)
+
+// ErrInvalidIdentity is returned when the provider answer can't be trusted
+var ErrInvalidIdentity = errors.New("federation: invalid identity")
+
+// Identity is the user as known by an upstream identity provider
+type Identity struct {
+	// Provider is the name of the provider that asserted the identity
+	Provider string
+	// Subject identifies the user on the provider, it never changes
+	Subject       string
+	Name          string
+	Email         string
+	EmailVerified bool
+	Picture       string
+}
+
+// Provider runs the authorization code flow against an upstream identity provider
+type Provider interface {
+	// Name identifies the provider on the login path
+	Name() string
+	// AuthCodeURL is where the user is redirected to log in on the provider,
+	// the state and the nonce are bound to the session starting the login
+	AuthCodeURL(state string, nonce string, redirectURI string) (string, error)
+	// Exchange redeems the code sent to the redirectURI and returns the identity
+	// of the logged in user
+	Exchange(code string, redirectURI string, nonce string) (*Identity, error)
+}
+
+// Providers are the configured providers indexed by name
+type Providers map[string]Provider
+
+// NewProvidersFromConfig creates the providers listed on config
+func NewProvidersFromConfig(config *config.Config) (Providers, error) {
+	providers := Providers{}
+	for _, p := range config.Federation.Providers {
+		if _, ok := providers[p.Name]; ok {
+			return nil, fmt.Errorf("federation: duplicated provider %q", p.Name)
+		}
+		switch p.Type {
+		case "facebook":
+			providers[p.Name] = NewFacebook(p.Name, p.ClientID, p.ClientSecret, p.Scope)
+		case "oidc":
+			providers[p.Name] = NewOIDC(p.Name, p.Issuer, p.ClientID, p.ClientSecret, p.Scope)
+		default:
+			return nil, fmt.Errorf("federation: invalid provider type %q", p.Type)
+		}
+	}
+	return providers, nil
+}
+
+// defaultClient is the client reaching the providers
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// maxResponseSize limits how much of a provider response is read
+const maxResponseSize = 1 << 20
+
+// providerError is the body of an OAuth2 error answered by a provider
+type providerError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// decodeResponse decodes a JSON answer of a provider into v, failing on
+// unsuccessful answers
+func decodeResponse(res *http.Response, v interface{}) error {
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		var e providerError
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return fmt.Errorf("federation: provider answered %s: %s", e.Error, e.Description)
+		}
+		return fmt.Errorf("federation: provider answered %s", res.Status)
+	}
+	return json.Unmarshal(body, v)
+}
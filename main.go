@@ -1,12 +1,26 @@
-package app
+package main
 
 import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/gabriel-araujjo/condominio-auth/config"
 	"github.com/gabriel-araujjo/condominio-auth/dao"
+	"github.com/gabriel-araujjo/condominio-auth/federation"
+	"github.com/gabriel-araujjo/condominio-auth/notification"
+	"github.com/gabriel-araujjo/condominio-auth/routes"
+	"github.com/gabriel-araujjo/condominio-auth/security"
 	"github.com/gabriel-araujjo/condominio-auth/sessions"
-	"github.com/gin-gonic/gin"
 )
 
+// shutdownTimeout is how long the server waits for pending requests when stopping
+const shutdownTimeout = 15 * time.Second
+
 func database(config *config.Config) *dao.Dao {
 	dao, err := dao.NewFromConfig(config)
 	if err != nil {
@@ -23,18 +37,83 @@ func sessionsStore(config *config.Config) sessions.Store {
 	return store
 }
 
-func main() {
-	conf := config.DefaultConfig()
+func notary(config *config.Config) *security.Notary {
+	notary, err := security.NewNotary(config)
+	if err != nil {
+		panic(err)
+	}
+	return notary
+}
+
+func mailer(config *config.Config) notification.Mailer {
+	mailer, err := notification.NewMailerFromConfig(config)
+	if err != nil {
+		panic(err)
+	}
+	return mailer
+}
+
+func smsSender(config *config.Config) notification.SMSSender {
+	sender, err := notification.NewSMSSenderFromConfig(config)
+	if err != nil {
+		panic(err)
+	}
+	return sender
+}
+
+func identityProviders(config *config.Config) federation.Providers {
+	providers, err := federation.NewProvidersFromConfig(config)
+	if err != nil {
+		panic(err)
+	}
+	return providers
+}
+
+func port() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return port
+	}
+	return "8080"
+}
 
+// serve runs the server until it's signaled to stop, closing the stores on return
+func serve(conf *config.Config) error {
 	db := database(conf)
 	session := sessionsStore(conf)
+	n := notary(conf)
 	defer db.Close()
 	defer session.Close()
+	defer n.Close()
+
+	server := &http.Server{
+		Addr:    ":" + port(),
+		Handler: routes.NewServeAuth(conf, db, session, n, mailer(conf), smsSender(conf), identityProviders(conf)),
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
 
-	engine := gin.Default()
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("can't shutdown gracefully: %v", err)
+		}
+		close(stopped)
+	}()
 
-	// v1 := engine.Group("/api/v1")
-	// routes.ConfigureEngine(v1, db)
+	log.Printf("listening on %s", server.Addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	<-stopped
+	return nil
+}
 
-	engine.Run()
+func main() {
+	if err := serve(config.DefaultConfig()); err != nil {
+		log.Fatalf("server stopped: %v", err)
+	}
 }
@@ -0,0 +1,24 @@
+package notification
+
+import (
+	"fmt"
+
+	"github.com/gabriel-araujjo/condominio-auth/config"
+)
+
+// Mailer sends emails to users
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// NewMailerFromConfig creates the Mailer chosen on config
+func NewMailerFromConfig(config *config.Config) (Mailer, error) {
+	switch config.Mail.MailerType {
+	case "smtp":
+		return NewSMTPMailer(config), nil
+	case "memory":
+		return &MemoryMailer{}, nil
+	default:
+		return nil, fmt.Errorf("invalid mailer type: %q", config.Mail.MailerType)
+	}
+}
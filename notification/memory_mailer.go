@@ -0,0 +1,31 @@
+package notification
+
+import "sync"
+
+// Mail is an email kept by MemoryMailer
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// MemoryMailer keeps the sent emails on memory, it's meant to be used on tests
+type MemoryMailer struct {
+	mutex sync.Mutex
+	mails []Mail
+}
+
+// Send keeps the email on memory
+func (m *MemoryMailer) Send(to string, subject string, body string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.mails = append(m.mails, Mail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// Mails returns the sent emails
+func (m *MemoryMailer) Mails() []Mail {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]Mail(nil), m.mails...)
+}
@@ -0,0 +1,74 @@
+package notification
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/gabriel-araujjo/condominio-auth/config"
+)
+
+// SMSSender sends text messages to phones
+type SMSSender interface {
+	Send(phone string, message string) error
+}
+
+// NewSMSSenderFromConfig creates the SMSSender chosen on config
+func NewSMSSenderFromConfig(config *config.Config) (SMSSender, error) {
+	switch config.SMS.SenderType {
+	case "log":
+		return LogSMSSender{}, nil
+	case "memory":
+		return &MemorySMSSender{}, nil
+	default:
+		return nil, fmt.Errorf("invalid sms sender type: %q", config.SMS.SenderType)
+	}
+}
+
+// LogSMSSender writes the messages on the log instead of sending them,
+// with the digits masked so codes don't leak to whoever reads the log
+type LogSMSSender struct{}
+
+// Send logs the masked message
+func (LogSMSSender) Send(phone string, message string) error {
+	log.Printf("sms to %s: %s", phone, maskDigits(message))
+	return nil
+}
+
+// maskDigits replaces the digits of message by "*"
+func maskDigits(message string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return '*'
+		}
+		return r
+	}, message)
+}
+
+// SMS is a text message kept by MemorySMSSender
+type SMS struct {
+	Phone   string
+	Message string
+}
+
+// MemorySMSSender keeps the sent messages on memory, it's meant to be used on tests
+type MemorySMSSender struct {
+	mutex    sync.Mutex
+	messages []SMS
+}
+
+// Send keeps the message on memory
+func (s *MemorySMSSender) Send(phone string, message string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.messages = append(s.messages, SMS{Phone: phone, Message: message})
+	return nil
+}
+
+// Messages returns the sent messages
+func (s *MemorySMSSender) Messages() []SMS {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]SMS(nil), s.messages...)
+}
@@ -0,0 +1,40 @@
+package notification
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+
+	"github.com/gabriel-araujjo/condominio-auth/config"
+)
+
+// SMTPMailer sends plain text emails through a SMTP server
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer creates a mailer authenticating on the SMTP server when a username is configured
+func NewSMTPMailer(config *config.Config) *SMTPMailer {
+	m := &SMTPMailer{addr: config.Mail.SMTPAddr, from: config.Mail.From}
+	if config.Mail.SMTPUsername != "" {
+		host, _, _ := net.SplitHostPort(config.Mail.SMTPAddr)
+		m.auth = smtp.PlainAuth("", config.Mail.SMTPUsername, config.Mail.SMTPPassword, host)
+	}
+	return m
+}
+
+// Send sends a plain text email to a single recipient
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(body)
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, msg.Bytes())
+}
@@ -1,23 +1,37 @@
 package routes
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gabriel-araujjo/condominio-auth/config"
 	"github.com/gabriel-araujjo/condominio-auth/dao"
+	"github.com/gabriel-araujjo/condominio-auth/security"
 	"github.com/gabriel-araujjo/condominio-auth/sessions"
 )
 
-const userKey = "user"
+const (
+	userKey = "user"
+	// loginKey is when the user logged in, in nanoseconds
+	loginKey = "login"
+	// amrKey are the methods the user logged in with, separated by spaces
+	amrKey  = "amr"
+	csrfKey = "csrf"
+)
 
 type context struct {
 	sessionName   string
 	dao           *dao.Dao
 	sessionsStore sessions.Store
+	notary        *security.Notary
 }
 
-func newContext(conf *config.Config, dao *dao.Dao, sessionsStore sessions.Store) *context {
-	return &context{conf.Session.CookieName, dao, sessionsStore}
+func newContext(conf *config.Config, dao *dao.Dao, sessionsStore sessions.Store, notary *security.Notary) *context {
+	return &context{conf.Session.CookieName, dao, sessionsStore, notary}
 }
 
 func (c *context) Session(req *http.Request) (sessions.Session, error) {
@@ -32,23 +46,77 @@ func (c *context) PersistSession(req *http.Request, w http.ResponseWriter) error
 	return c.sessionsStore.Save(req, w, s)
 }
 
-func (c *context) SetCurrentUserID(req *http.Request, userID int64) error {
+// SetCurrentUserID logs the user in, amr are the methods the user authenticated with
+func (c *context) SetCurrentUserID(req *http.Request, userID int64, amr ...string) error {
 	session, err := c.Session(req)
 	if err != nil {
 		return err
 	}
 	session.Set(userKey, userID)
+	session.Set(loginKey, time.Now().UnixNano())
+	session.Set(amrKey, strings.Join(amr, " "))
 	return nil
 }
 
+// CurrentAMR returns the methods the logged in user authenticated with
+func (c *context) CurrentAMR(req *http.Request) []string {
+	session, err := c.Session(req)
+	if err != nil {
+		return nil
+	}
+	amr, _ := session.Get(amrKey).(string)
+	return strings.Fields(amr)
+}
+
 func (c *context) CurrentUserID(req *http.Request) (int64, error) {
 	session, err := c.Session(req)
 	if err != nil {
 		return 0, err
 	}
-	userID := session.Get(userKey)
-	if userID == nil {
+	userID, _ := session.Get(userKey).(int64)
+	if userID == 0 {
+		return 0, nil
+	}
+
+	// sessions started before a password reset are revoked
+	loginAt, _ := session.Get(loginKey).(int64)
+	revoked, err := c.notary.SessionRevoked(userID, loginAt)
+	if err != nil {
+		return 0, err
+	}
+	if revoked {
+		session.Set(userKey, int64(0))
 		return 0, nil
 	}
-	return userID.(int64), nil
+	return userID, nil
+}
+
+// CSRFToken returns the token forms posted by the current session must carry,
+// creating one when the session has none. Call PersistSession to keep it.
+func (c *context) CSRFToken(req *http.Request) (string, error) {
+	session, err := c.Session(req)
+	if err != nil {
+		return "", err
+	}
+	if token, ok := session.Get(csrfKey).(string); ok {
+		return token, nil
+	}
+	var token [32]byte
+	if _, err = rand.Read(token[:]); err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(token[:])
+	session.Set(csrfKey, encoded)
+	return encoded, nil
+}
+
+// VerifyCSRFToken checks the token posted on the csrf_token form field
+func (c *context) VerifyCSRFToken(req *http.Request) bool {
+	session, err := c.Session(req)
+	if err != nil {
+		return false
+	}
+	token, ok := session.Get(csrfKey).(string)
+	posted := req.PostFormValue("csrf_token")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(posted)) == 1
 }
@@ -0,0 +1,195 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gabriel-araujjo/condominio-auth/domain"
+	"github.com/gabriel-araujjo/condominio-auth/errors"
+	"github.com/gabriel-araujjo/condominio-auth/security"
+)
+
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// deviceAuthorizationResponse is defined in https://tools.ietf.org/html/rfc8628#section-3.2
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// deviceAuthorization starts the authorization of devices without a browser or a keyboard
+// https://tools.ietf.org/html/rfc8628#section-3.1
+func (o *oAuth2) deviceAuthorization(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		errors.WriteErrorWithCode(w, http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	client, err := o.authenticateClient(req)
+	if err != nil {
+		if _, _, basic := req.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="device_authorization"`)
+		}
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	scope := domain.Scope(strings.Fields(req.PostForm.Get("scope")))
+	scopeIDs, err := o.context.dao.Permission.ScopeIntoPermissionIDs(scope)
+	if err != nil || len(scope) == 0 || len(scopeIDs) != len(scope) {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_scope")
+		return
+	}
+
+	code, err := o.notary.NewDeviceCode(client.PublicID, scope)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	verificationURI := o.notary.Issuer() + devicePath
+	json.NewEncoder(w).Encode(&deviceAuthorizationResponse{
+		DeviceCode:              code.DeviceCode,
+		UserCode:                code.UserCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + code.UserCode,
+		ExpiresIn:               int64(code.ExpiresIn / time.Second),
+		Interval:                int64(code.Interval / time.Second),
+	})
+}
+
+// device is the page where a logged in user types the code shown
+// on the device and approves or denies its authorization
+// https://tools.ietf.org/html/rfc8628#section-3.3
+func (o *oAuth2) device(w http.ResponseWriter, req *http.Request) {
+	userID, err := o.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		renderErrorPage(w, http.StatusUnauthorized, "Login required",
+			"Log in to your account before connecting a device.")
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		o.confirmDevice(w, req)
+	case http.MethodPost:
+		o.decideDevice(w, req, userID)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		renderErrorPage(w, http.StatusMethodNotAllowed, "Invalid request", "Unexpected request method.")
+	}
+}
+
+func (o *oAuth2) confirmDevice(w http.ResponseWriter, req *http.Request) {
+	userCode := req.URL.Query().Get("user_code")
+	if userCode == "" {
+		renderPage(w, http.StatusOK, "device", struct {
+			UserCode string
+			Invalid  bool
+		}{})
+		return
+	}
+
+	authorization, err := o.notary.DeviceAuthorization(userCode)
+	if err != nil {
+		renderPage(w, http.StatusNotFound, "device", struct {
+			UserCode string
+			Invalid  bool
+		}{userCode, true})
+		return
+	}
+
+	client, err := o.context.dao.Client.Get(authorization.ClientID)
+	if err != nil {
+		renderErrorPage(w, http.StatusInternalServerError, "Unexpected error", "Try again later.")
+		return
+	}
+
+	csrfToken, err := o.context.CSRFToken(req)
+	if err == nil {
+		err = o.context.PersistSession(req, w)
+	}
+	if err != nil {
+		renderErrorPage(w, http.StatusInternalServerError, "Unexpected error", "Try again later.")
+		return
+	}
+
+	renderPage(w, http.StatusOK, "device_confirm", struct {
+		ClientName string
+		UserCode   string
+		Scope      []scopeItem
+		CSRFToken  string
+	}{client.Name, security.FormatUserCode(authorization.UserCode), describeScope(authorization.Scope), csrfToken})
+}
+
+func (o *oAuth2) decideDevice(w http.ResponseWriter, req *http.Request, userID int64) {
+	if !o.context.VerifyCSRFToken(req) {
+		renderErrorPage(w, http.StatusForbidden, "Invalid request", "Reload the page and try again.")
+		return
+	}
+
+	userCode := req.PostForm.Get("user_code")
+	approved := req.PostForm.Get("action") == "approve"
+
+	authorization, err := o.notary.DeviceAuthorization(userCode)
+	if err != nil {
+		renderPage(w, http.StatusNotFound, "device", struct {
+			UserCode string
+			Invalid  bool
+		}{userCode, true})
+		return
+	}
+
+	if approved {
+		err = o.context.dao.User.AuthorizeClient(userID, authorization.ClientID, authorization.Scope)
+	}
+	if err == nil {
+		err = o.notary.DecideDeviceAuthorization(userCode, userID, approved)
+	}
+	if err != nil {
+		renderErrorPage(w, http.StatusInternalServerError, "Unexpected error", "Try again later.")
+		return
+	}
+
+	renderPage(w, http.StatusOK, "device_done", struct{ Approved bool }{approved})
+}
+
+// deviceCodeGrant is polled by the device until the user decides on its authorization
+// https://tools.ietf.org/html/rfc8628#section-3.4
+func (o *oAuth2) deviceCodeGrant(w http.ResponseWriter, req *http.Request, client *domain.Client) {
+	deviceCode := req.PostForm.Get("device_code")
+	if deviceCode == "" {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	tokens, err := o.notary.NewTokensFromDeviceCode(deviceCode, client.PublicID)
+	switch err {
+	case nil:
+	case security.ErrAuthorizationPending, security.ErrSlowDown,
+		security.ErrAccessDenied, security.ErrDeviceCodeNotFound:
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, err.Error())
+		return
+	default:
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	json.NewEncoder(w).Encode(o.newUserTokenResponse(tokens, client, tokens.UserID, nil, ""))
+}
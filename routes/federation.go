@@ -0,0 +1,286 @@
+package routes
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gabriel-araujjo/condominio-auth/domain"
+	"github.com/gabriel-araujjo/condominio-auth/errors"
+	"github.com/gabriel-araujjo/condominio-auth/federation"
+)
+
+// federatedCallbackSuffix ends the path the user comes back to from a provider,
+// the federated login starts on loginPath/{provider} and ends on
+// loginPath/{provider}/callback
+const federatedCallbackSuffix = "/callback"
+
+// identitiesPath lists the provider accounts linked to the logged in user, an
+// account is unlinked on identitiesPath/{provider}/{subject}
+const identitiesPath = "/users/me/identities"
+
+// Session keys of a federated login in progress
+const (
+	federationProviderKey = "federation_provider"
+	federationStateKey    = "federation_state"
+	federationNonceKey    = "federation_nonce"
+	federationReturnToKey = "federation_return_to"
+)
+
+// federatedLogin dispatches the requests of the federated login end points
+func (c *userContext) federatedLogin(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, loginPath+"/")
+	callback := strings.HasSuffix(name, federatedCallbackSuffix)
+	name = strings.TrimSuffix(name, federatedCallbackSuffix)
+
+	provider, ok := c.providers[name]
+	if !ok {
+		renderErrorPage(w, http.StatusNotFound, "Unknown provider", "It's not possible to log in with "+name+".")
+		return
+	}
+	if callback {
+		c.federatedCallback(w, req, provider)
+		return
+	}
+	c.startFederatedLogin(w, req, provider)
+}
+
+// federatedRedirectURI is where the provider sends the user back to
+func (c *userContext) federatedRedirectURI(provider federation.Provider) string {
+	return c.notary.Issuer() + loginPath + "/" + provider.Name() + federatedCallbackSuffix
+}
+
+// startFederatedLogin redirects the user to the provider. The return_to query
+// parameter is where the user goes after logging in, usually the authorization
+// end point, it must be a path on this server.
+func (c *userContext) startFederatedLogin(w http.ResponseWriter, req *http.Request, provider federation.Provider) {
+	session, err := c.context.Session(req)
+	if err != nil {
+		renderErrorPage(w, http.StatusInternalServerError, "Unexpected error", "Try again later.")
+		return
+	}
+
+	state, err := randomString()
+	if err != nil {
+		renderErrorPage(w, http.StatusInternalServerError, "Unexpected error", "Try again later.")
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		renderErrorPage(w, http.StatusInternalServerError, "Unexpected error", "Try again later.")
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(state, nonce, c.federatedRedirectURI(provider))
+	if err != nil {
+		log.Printf("routes: can't reach provider %q: %v", provider.Name(), err)
+		renderErrorPage(w, http.StatusBadGateway, "Provider unavailable", "Try again later.")
+		return
+	}
+
+	session.Set(federationProviderKey, provider.Name())
+	session.Set(federationStateKey, state)
+	session.Set(federationNonceKey, nonce)
+	session.Set(federationReturnToKey, localPath(req.URL.Query().Get("return_to")))
+	if err = c.context.PersistSession(req, w); err != nil {
+		renderErrorPage(w, http.StatusInternalServerError, "Unexpected error", "Try again later.")
+		return
+	}
+
+	http.Redirect(w, req, authURL, http.StatusFound)
+}
+
+// federatedCallback logs in the user identified by the provider
+func (c *userContext) federatedCallback(w http.ResponseWriter, req *http.Request, provider federation.Provider) {
+	session, err := c.context.Session(req)
+	if err != nil {
+		renderErrorPage(w, http.StatusInternalServerError, "Unexpected error", "Try again later.")
+		return
+	}
+
+	// the login in progress is consumed whatever the outcome
+	name, _ := session.Get(federationProviderKey).(string)
+	state, _ := session.Get(federationStateKey).(string)
+	nonce, _ := session.Get(federationNonceKey).(string)
+	returnTo, _ := session.Get(federationReturnToKey).(string)
+	for _, key := range []string{federationProviderKey, federationStateKey, federationNonceKey, federationReturnToKey} {
+		session.Set(key, "")
+	}
+
+	query := req.URL.Query()
+	if name != provider.Name() || state == "" ||
+		subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		c.context.PersistSession(req, w)
+		renderErrorPage(w, http.StatusBadRequest, "Invalid request", "Go back to the application and try again.")
+		return
+	}
+	if query.Get("error") != "" || query.Get("code") == "" {
+		c.context.PersistSession(req, w)
+		renderErrorPage(w, http.StatusUnauthorized, "Login canceled", "You didn't log in with "+provider.Name()+".")
+		return
+	}
+
+	identity, err := provider.Exchange(query.Get("code"), c.federatedRedirectURI(provider), nonce)
+	if err != nil {
+		log.Printf("routes: can't log in with provider %q: %v", provider.Name(), err)
+		c.context.PersistSession(req, w)
+		renderErrorPage(w, http.StatusBadGateway, "Login failed", "It wasn't possible to log in with "+provider.Name()+".")
+		return
+	}
+
+	userID, err := c.federatedUser(req, identity)
+	if err != nil {
+		log.Printf("routes: can't find the user of %q on provider %q: %v", identity.Subject, provider.Name(), err)
+		c.context.PersistSession(req, w)
+		renderErrorPage(w, http.StatusInternalServerError, "Unexpected error", "Try again later.")
+		return
+	}
+
+	mfaRequired, err := c.logIn(req, userID, returnTo, domain.AMRFederated)
+	if err != nil {
+		c.context.PersistSession(req, w)
+		renderErrorPage(w, http.StatusInternalServerError, "Unexpected error", "Try again later.")
+		return
+	}
+	c.context.PersistSession(req, w)
+	if mfaRequired {
+		http.Redirect(w, req, loginTOTPPath, http.StatusFound)
+		return
+	}
+	if returnTo == "" {
+		returnTo = "/"
+	}
+	http.Redirect(w, req, returnTo, http.StatusFound)
+}
+
+// federatedUser returns the user linked to the identity. An identity not linked
+// yet is linked to the logged in user or, when the provider verified the email,
+// to the user owning the same verified email. Otherwise a new user is created.
+func (c *userContext) federatedUser(req *http.Request, identity *federation.Identity) (int64, error) {
+	if userID, err := c.dao.User.LookupIdentity(identity.Provider, identity.Subject); err == nil {
+		return userID, nil
+	}
+
+	userID, err := c.context.CurrentUserID(req)
+	if err != nil {
+		return 0, err
+	}
+
+	// an email is only trusted when both sides verified it, otherwise anyone
+	// could register it beforehand and take over the account
+	emailTaken := false
+	if userID == 0 && identity.Email != "" {
+		if ownerID, err := c.dao.User.Lookup(identity.Email); err == nil {
+			emailTaken = true
+			owner, err := c.dao.User.Get(ownerID)
+			if err == nil && identity.EmailVerified && verifiedEmail(owner.Emails, identity.Email) {
+				userID = ownerID
+			}
+		}
+	}
+
+	if userID == 0 {
+		user := &domain.User{Name: identity.Name}
+		if avatar, err := url.Parse(identity.Picture); err == nil && avatar.IsAbs() {
+			user.Avatar = avatar
+		}
+		if identity.Email != "" && !emailTaken {
+			user.Emails = []domain.Email{{Email: identity.Email, Verified: identity.EmailVerified}}
+		}
+		if err = c.dao.User.Create(user); err != nil {
+			return 0, err
+		}
+		userID = user.ID
+	}
+
+	err = c.dao.User.LinkIdentity(userID, &domain.Identity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	})
+	return userID, err
+}
+
+// identities lists the provider accounts the logged in user can log in with
+func (c *userContext) identities(w http.ResponseWriter, req *http.Request) {
+	userID, err := c.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	identities, err := c.dao.User.Identities(userID)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "can't list identities")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	json.NewEncoder(w).Encode(identities)
+}
+
+// unlinkIdentity removes the provider account identitiesPath/{provider}/{subject}
+// from the logged in user, unless it's the only way left to log in
+func (c *userContext) unlinkIdentity(w http.ResponseWriter, req *http.Request) {
+	userID, err := c.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, identitiesPath+"/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		errors.WriteErrorWithCode(w, http.StatusNotFound, "identity not found")
+		return
+	}
+	provider, subject := parts[0], parts[1]
+
+	last, err := c.lastLoginMethod(userID)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	if last {
+		errors.WriteErrorWithCode(w, http.StatusConflict, "last_login_method")
+		return
+	}
+
+	if err = c.dao.User.UnlinkIdentity(userID, provider, subject); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusNotFound, "identity not linked")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifiedEmail tells whether the email is among the verified ones
+func verifiedEmail(emails []domain.Email, email string) bool {
+	for _, e := range emails {
+		if e.Verified && strings.EqualFold(e.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// localPath returns the path when it's a path on this server, so it's safe to
+// redirect to it, or "" otherwise
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return ""
+	}
+	return path
+}
+
+// randomString returns 32 random bytes encoded as URL safe base64
+func randomString() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:]), nil
+}
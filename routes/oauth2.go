@@ -1,11 +1,16 @@
 package routes
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gabriel-araujjo/condominio-auth/domain"
 	"github.com/gabriel-araujjo/condominio-auth/security"
 
 	"github.com/gabriel-araujjo/condominio-auth/errors"
@@ -13,7 +18,7 @@ import (
 
 type oAuth2 struct {
 	*context
-	notary security.Notary
+	notary *security.Notary
 }
 
 func (o *oAuth2) verifyTokenScope(req *http.Request, scope ...string) bool {
@@ -37,118 +42,541 @@ func (o *oAuth2) requireScope(scopes ...string) *Middleware {
 	})
 }
 
+// scopeItem is a scope listed to the user
+type scopeItem struct {
+	Name        string
+	Description string
+}
+
+func describeScope(scope domain.Scope) []scopeItem {
+	items := make([]scopeItem, len(scope))
+	for i, name := range scope {
+		items[i] = scopeItem{name, domain.ScopeDescription(name)}
+	}
+	return items
+}
+
+// consentParams are the authorization parameters posted back by the consent form
+var consentParams = []string{
+	"response_type", "client_id", "redirect_uri", "scope", "state",
+	"code_challenge", "code_challenge_method", "nonce",
+}
+
+// renderConsent asks the user to approve the scope requested by the client
+func (o *oAuth2) renderConsent(w http.ResponseWriter, req *http.Request, client *domain.Client, scope domain.Scope) error {
+	csrfToken, err := o.context.CSRFToken(req)
+	if err != nil {
+		return err
+	}
+	if err = o.context.PersistSession(req, w); err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	for _, name := range consentParams {
+		if value := req.Form.Get(name); value != "" {
+			params.Set(name, value)
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	renderPage(w, http.StatusOK, "consent", struct {
+		ClientName string
+		Scope      []scopeItem
+		Params     url.Values
+		CSRFToken  string
+	}{client.Name, describeScope(scope), params, csrfToken})
+	return nil
+}
+
 func (o *oAuth2) authorize(w http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
-	redirectUri, _ := url.Parse(req.Form.Get("redirect_uri"))
+	rawRedirectURI := req.Form.Get("redirect_uri")
 	responseType := req.Form.Get("response_type")
-	scope := strings.Fields(req.Form.Get("scope"))
-	clientID := req.Form.Get("cleint_id")
+	scope := domain.Scope(strings.Fields(req.Form.Get("scope")))
+	clientID := req.Form.Get("client_id")
 	state := req.Form.Get("state")
+	codeChallenge := req.Form.Get("code_challenge")
+	codeChallengeMethod := req.Form.Get("code_challenge_method")
+	prompt := domain.Scope(strings.Fields(req.Form.Get("prompt")))
+	consent := req.PostForm.Get("consent")
 
-	client, err := o.context.dao.Client.Get(clientID)
 	var scopeIDs []int64
 	var code string
+	var challenge *security.CodeChallenge
+	var userID int64
 
-	if err != nil || strings.EqualFold(responseType, "code") || redirectUri == nil {
-		errors.WriteErrorWithCode(w, http.StatusNotFound, "not found")
+	client, err := o.context.dao.Client.Get(clientID)
+	if err != nil {
+		renderErrorPage(w, http.StatusBadRequest, "Unknown application",
+			"The application that sent you here is not registered.")
 		return
 	}
 
-	userID, err := o.context.CurrentUserID(req)
-	if err != nil {
-		redirectUri.Query().Set("error", "login_required")
+	if rawRedirectURI == "" && len(client.RedirectURIs) == 1 {
+		rawRedirectURI = client.RedirectURIs[0]
+	}
+
+	// never redirect to an unregistered URI, otherwise it's an open redirector
+	redirectURI, err := url.Parse(rawRedirectURI)
+	if err != nil || !client.HasRedirectURI(rawRedirectURI) {
+		renderErrorPage(w, http.StatusBadRequest, "Invalid redirect URI",
+			"The application that sent you here asked to return to an address it has not registered.")
+		return
+	}
+
+	query := redirectURI.Query()
+
+	if !strings.EqualFold(responseType, "code") {
+		query.Set("error", "unsupported_response_type")
 		goto respond
 	}
 
-	err = o.context.dao.User.AuthorizeClient(userID, clientID, scope)
-	if err != nil {
-		redirectUri.Query().Set("error", "invalid_request_uri")
+	if codeChallenge != "" || codeChallengeMethod != "" || client.MustUsePKCE() {
+		challenge, err = security.NewCodeChallenge(codeChallenge, codeChallengeMethod)
+		if err != nil {
+			query.Set("error", "invalid_request")
+			query.Set("error_description", "invalid or missing code challenge")
+			goto respond
+		}
+	}
+
+	// prompt=none forbids any interaction, so it can't be mixed with other values
+	// https://openid.net/specs/openid-connect-core-1_0.html#AuthRequest
+	if prompt.HasSubscope(domain.Scope{"none"}) && len(prompt) > 1 {
+		query.Set("error", "invalid_request")
 		goto respond
 	}
 
 	scopeIDs, err = o.context.dao.Permission.ScopeIntoPermissionIDs(scope)
+	if err != nil || len(scopeIDs) == 0 || len(scopeIDs) != len(scope) {
+		query.Set("error", "invalid_scope")
+		goto respond
+	}
 
-	if len(scopeIDs) == 0 {
-		redirectUri.Query().Set("error", "invalid_request_uri")
+	userID, err = o.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		query.Set("error", "login_required")
 		goto respond
 	}
 
-	code, err = o.notary.NewClientCode(client.ID, scopeIDs, userID)
+	switch {
+	case consent != "":
+		if req.Method != http.MethodPost || !o.context.VerifyCSRFToken(req) {
+			renderErrorPage(w, http.StatusForbidden, "Invalid request", "Go back to the application and try again.")
+			return
+		}
+		if consent != "approve" {
+			query.Set("error", "access_denied")
+			goto respond
+		}
+		if err = o.context.dao.User.AuthorizeClient(userID, clientID, scope); err != nil {
+			query.Set("error", "invalid_scope")
+			goto respond
+		}
+	case prompt.HasSubscope(domain.Scope{"none"}):
+		if !o.context.dao.Client.GetAuthorizedScopesByUser(clientID, userID).HasSubscope(scope) {
+			query.Set("error", "consent_required")
+			goto respond
+		}
+	case prompt.HasSubscope(domain.Scope{"consent"}) ||
+		!o.context.dao.Client.GetAuthorizedScopesByUser(clientID, userID).HasSubscope(scope):
+		if err = o.renderConsent(w, req, client, scope); err != nil {
+			errors.WriteErrorWithCode(w, http.StatusInternalServerError, "can't ask for consent")
+		}
+		return
+	}
+
+	code, err = o.notary.NewClientCode(client.ID, scopeIDs, userID, o.context.CurrentAMR(req)...)
+
+	if err == nil {
+		// the redirect_uri sent, not the defaulted one, must be repeated on the token end point
+		err = o.notary.BindCode(code, &security.CodeBinding{
+			Challenge:   challenge,
+			RedirectURI: req.Form.Get("redirect_uri"),
+			Nonce:       req.Form.Get("nonce"),
+		})
+	}
 
 	if err != nil {
 		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "can't generate code")
 		return
 	}
 
-	redirectUri.Query().Set("code", code)
+	query.Set("code", code)
 
 respond:
 	if len(state) != 0 {
-		redirectUri.Query().Set("state", state)
+		query.Set("state", state)
 	}
-	w.Header().Set("Location", redirectUri.String())
+	redirectURI.RawQuery = query.Encode()
+	w.Header().Set("Location", redirectURI.String())
 	w.WriteHeader(http.StatusFound)
 }
 
-// Token exchange - get an access_token sending an id_token
-//https://tools.ietf.org/html/draft-ietf-oauth-token-exchange-12
+// tokenResponse is the successful response of the token end point
+// as defined in https://tools.ietf.org/html/rfc6749#section-5.1
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+func newTokenResponse(tokens *security.Tokens) *tokenResponse {
+	return &tokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokens.ExpiresIn / time.Second),
+		RefreshToken: tokens.RefreshToken,
+		Scope:        strings.Join(tokens.Scope, " "),
+	}
+}
+
+// authenticateClient checks the client credentials sent either through
+// HTTP Basic authentication or through the client_id and client_secret
+// form parameters
+func (o *oAuth2) authenticateClient(req *http.Request) (*domain.Client, error) {
+	publicID, secret, ok := req.BasicAuth()
+	if !ok {
+		publicID = req.PostForm.Get("client_id")
+		secret = req.PostForm.Get("client_secret")
+	}
+
+	publicID, err := o.context.dao.Client.Auth(publicID, secret)
+	if err != nil {
+		return nil, err
+	}
+	return o.context.dao.Client.Get(publicID)
+}
 
 func (o *oAuth2) token(w http.ResponseWriter, req *http.Request) {
-	// 	req.ParseForm()
-	// 	grantType := req.Form.Get("grant_type")
-	// 	code := req.Form.Get("code")
-	// 	clientPublicID := req.Form.Get("cleint_id")
-	// 	clientSecret := req.Form.Get("client_secret")
-
-	// 	if !strings.EqualFold(grantType, "authorization_code") ||
-	// 		code == "" ||
-	// 		clientSecret == "" ||
-	// 		clientPublicID == "" {
-	// 		errors.WriteErrorWithCode(http.StatusBadRequest, "invalid_request")
-	// 		return
-	// 	}
-
-	// 	client, err := o.context.dao.Client.Get(clientPublicID)
-	// 	if client == nil {
-	// 		errors.WriteErrorWithCode(w, http.StatusNotFound, "not found")
-	// 		return
-	// 	}
-
-	// 	clientID, scope, uID, err := o.notary.DecipherCode(code)
-
-	// 	if clientID != client.ID || clientSecret != clientSecret {
-	// 		errors.WriteErrorWithCode(w, http.StatusNotFound, "not found")
-	// 		return
-	// 	}
-
-	// 	expiresAt time.Now() + 60 * time.Minute
-	// 	accessToken, err := o.notary.NewAccessToken(60 *time.Minute, uID, scope...)
-	// 	var idToken string
-
-	// 	for s := range scope {
-	// 		if strings.EqualFold(s, "openid") {
-	// 			idToken := o.notary.NewIDTokenWithClaims()
-	// 		}
-	// 	}
-
-	// respond:
-	// 	if len(state) != 0 {
-	// 		redirectUri.Query().Set("state", state)
-	// 	}
-	// 	w.Header().Set("Location", redirectUri.String())
-	// 	w.WriteHeader(http.StatusFound)
-}
-
-func (o *oAuth2) revokeAccess() *Middleware {
-	return newMiddleware(func(w http.ResponseWriter, req *http.Request) bool {
-		fields := strings.Fields(req.Header.Get("Authorization"))
-		if len(fields) == 2 && strings.EqualFold(fields[0], "Bearer") {
-			if err := o.notary.RevokeAccessToken(fields[1]); err != nil {
-				errors.WriteErrorWithCode(w, http.StatusInternalServerError, "Unexpected error")
-				return true
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		errors.WriteErrorWithCode(w, http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	client, err := o.authenticateClient(req)
+	if err != nil {
+		if _, _, basic := req.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	switch req.PostForm.Get("grant_type") {
+	case "":
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_request")
+	case "authorization_code":
+		o.authorizationCodeGrant(w, req, client)
+	case "refresh_token":
+		o.refreshTokenGrant(w, req, client)
+	case "client_credentials":
+		o.clientCredentialsGrant(w, req, client)
+	case "password":
+		o.passwordGrant(w, req, client)
+	case deviceCodeGrantType:
+		o.deviceCodeGrant(w, req, client)
+	default:
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "unsupported_grant_type")
+	}
+}
+
+// authorizationCodeGrant exchanges a code issued by the authorization end point
+// https://tools.ietf.org/html/rfc6749#section-4.1.3
+func (o *oAuth2) authorizationCodeGrant(w http.ResponseWriter, req *http.Request, client *domain.Client) {
+	code := req.PostForm.Get("code")
+	if code == "" {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	redeemed, err := o.notary.RedeemCode(code, client.ID, req.PostForm.Get("code_verifier"),
+		req.PostForm.Get("redirect_uri"))
+	switch err {
+	case nil:
+	case security.ErrInvalidCode, security.ErrCodeReused:
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_grant")
+		return
+	default:
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	if client.MustUsePKCE() && !redeemed.Challenged {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	userID := redeemed.UserID
+
+	scope, err := o.context.dao.Permission.PermissionIDsIntoScope(redeemed.ScopeIDs)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	tokens, err := o.notary.NewTokensFromCode(redeemed, client.PublicID, scope)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	json.NewEncoder(w).Encode(o.newUserTokenResponse(tokens, client, userID, redeemed.AMR, redeemed.Nonce))
+}
+
+// newUserTokenResponse adds an ID token to the response when the openid scope was granted,
+// amr are the methods the user authenticated with when known and nonce is the one sent
+// on the authorization request, if any
+func (o *oAuth2) newUserTokenResponse(tokens *security.Tokens, client *domain.Client, userID int64,
+	amr []string, nonce string) *tokenResponse {
+	resp := newTokenResponse(tokens)
+
+	if tokens.Scope.HasSubscope(domain.Scope{domain.ScopeOpenID}) {
+		claims := &domain.Claims{
+			StandardClaims: jwt.StandardClaims{
+				Subject:  strconv.FormatInt(userID, 10),
+				Audience: client.PublicID,
+				IssuedAt: time.Now().Unix(),
+			},
+			Nonce: nonce,
+			Scope: tokens.Scope,
+			AMR:   amr,
+		}
+		// email_verified comes from the flag set by the email verification
+		if user, err := o.context.dao.User.Get(userID); err == nil && user != nil {
+			if info := user.UserInfo(tokens.Scope); info.EmailVerified != nil {
+				claims.Email, claims.EmailVerified = info.Email, *info.EmailVerified
 			}
 		}
-		w.WriteHeader(http.StatusNoContent)
-		return true
-	})
+		resp.IDToken = o.notary.NewIDTokenWithClaims(claims)
+	}
+	return resp
+}
+
+// passwordGrant exchanges the user's credentials for tokens, only first-party
+// clients are trusted with them
+// https://tools.ietf.org/html/rfc6749#section-4.3
+func (o *oAuth2) passwordGrant(w http.ResponseWriter, req *http.Request, client *domain.Client) {
+	if !client.FirstParty {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "unauthorized_client")
+		return
+	}
+
+	username := req.PostForm.Get("username")
+	password := req.PostForm.Get("password")
+	scope := domain.Scope(strings.Fields(req.PostForm.Get("scope")))
+	if username == "" || password == "" {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	// username is either the CPF, an email or a phone as on the login form
+	userID, err := o.context.dao.User.Authenticate(username, password)
+	if err != nil || userID == 0 {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	// the grant has no room for a second factor, users enrolled on TOTP log in
+	// through the authorization end point
+	if _, enrolled, err := o.context.dao.User.TOTPSecret(userID); err != nil || enrolled {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	// the grant skips the consent page, so it is limited to the scope registered for users
+	if len(scope) == 0 || !client.UserScope.HasSubscope(scope) {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_scope")
+		return
+	}
+
+	if err = o.context.dao.User.AuthorizeClient(userID, client.PublicID, scope); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_scope")
+		return
+	}
+
+	tokens, err := o.notary.NewTokens(client.PublicID, userID, scope)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	json.NewEncoder(w).Encode(o.newUserTokenResponse(tokens, client, userID, []string{domain.AMRPassword}, ""))
+}
+
+// refreshTokenGrant rotates a refresh token
+// https://tools.ietf.org/html/rfc6749#section-6
+func (o *oAuth2) refreshTokenGrant(w http.ResponseWriter, req *http.Request, client *domain.Client) {
+	refreshToken := req.PostForm.Get("refresh_token")
+	if refreshToken == "" {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	scope := domain.Scope(strings.Fields(req.PostForm.Get("scope")))
+	tokens, err := o.notary.RefreshTokens(refreshToken, client.PublicID, scope)
+	switch err {
+	case nil:
+	case security.ErrTokenNotFound, security.ErrInvalidRefreshToken, security.ErrRefreshTokenReused:
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_grant")
+		return
+	default:
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	json.NewEncoder(w).Encode(newTokenResponse(tokens))
+}
+
+// clientCredentialsGrant issues an access token to the client itself
+// https://tools.ietf.org/html/rfc6749#section-4.4
+func (o *oAuth2) clientCredentialsGrant(w http.ResponseWriter, req *http.Request, client *domain.Client) {
+	if client.IsPublic() {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "unauthorized_client")
+		return
+	}
+
+	scope := domain.Scope(strings.Fields(req.PostForm.Get("scope")))
+	if len(scope) == 0 {
+		scope = client.Scope
+	}
+	if len(scope) == 0 || !client.Scope.HasSubscope(scope) {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_scope")
+		return
+	}
+
+	tokens, err := o.notary.NewClientTokens(client.PublicID, scope)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	json.NewEncoder(w).Encode(newTokenResponse(tokens))
+}
+
+// introspectionResponse is the response of the introspection end point
+// as defined in https://tools.ietf.org/html/rfc7662#section-2.2
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// introspect tells resource servers whether a token is active
+// https://tools.ietf.org/html/rfc7662
+func (o *oAuth2) introspect(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		errors.WriteErrorWithCode(w, http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	// public clients can't keep credentials, so anyone could introspect tokens through them
+	client, err := o.authenticateClient(req)
+	if err != nil || client.IsPublic() {
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspect"`)
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	rawToken := req.PostForm.Get("token")
+	if rawToken == "" {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	token, err := o.notary.Introspect(rawToken)
+	switch err {
+	case nil:
+	case security.ErrTokenNotFound:
+		json.NewEncoder(w).Encode(&introspectionResponse{Active: false})
+		return
+	default:
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	resp := &introspectionResponse{
+		Active:    true,
+		Scope:     strings.Join(token.Scope, " "),
+		ClientID:  token.ClientID,
+		ExpiresAt: token.ExpiresAt,
+		IssuedAt:  token.IssuedAt,
+	}
+	if token.Kind == security.AccessToken {
+		resp.TokenType = "Bearer"
+	}
+	resp.Subject = token.Subject()
+	json.NewEncoder(w).Encode(resp)
+}
+
+// revoke revokes access tokens and refresh tokens
+// https://tools.ietf.org/html/rfc7009
+func (o *oAuth2) revoke(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		errors.WriteErrorWithCode(w, http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	client, err := o.authenticateClient(req)
+	if err != nil {
+		if _, _, basic := req.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="revoke"`)
+		}
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	token := req.PostForm.Get("token")
+	if token == "" {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	// token_type_hint is only an optimization, every kind of token is searched
+	// on the same store, so it doesn't need to be validated
+	switch err := o.notary.RevokeToken(token, client.PublicID); err {
+	case nil:
+		w.WriteHeader(http.StatusOK)
+	case security.ErrTokenOfOtherClient:
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "unauthorized_client")
+	default:
+		errors.WriteErrorWithCode(w, http.StatusServiceUnavailable, "server_error")
+	}
 }
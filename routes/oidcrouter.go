@@ -1,14 +1,141 @@
 package routes
 
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gabriel-araujjo/condominio-auth/domain"
+	"github.com/gabriel-araujjo/condominio-auth/errors"
+	"github.com/gabriel-araujjo/condominio-auth/security"
+)
+
+// OAuth2 and OpenID Connect end points, relative to the issuer
+const (
+	authorizePath     = "/authorize"
+	tokenPath         = "/token"
+	introspectionPath = "/introspect"
+	revocationPath    = "/revoke"
+	devicePath        = "/device"
+	deviceAuthPath    = "/device_authorization"
+	userInfoPath      = "/userinfo"
+	discoveryPath     = "/.well-known/openid-configuration"
+	jsonWebKeySetPath = "/.well-known/jwks.json"
+)
+
 type oidcRouter struct {
 	*context
+	notary *security.Notary
+}
+
+// providerMetadata is the discovery document defined in
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type providerMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
 }
 
-// func (o *oidcRouter) authorize(w http.ResponseWriter, req *http.Request) {
-// 	req.ParseForm()
-// 	redirectUri := req.Form.Get("redirect_uri")
-// 	responseType := req.Form.Get("response_type")
-// 	scope := req.Form.Get("scope")
-// 	clientID := req.Form.Get("cleint_id")
+func (o *oidcRouter) discovery(w http.ResponseWriter, req *http.Request) {
+	issuer := o.notary.Issuer()
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	json.NewEncoder(w).Encode(&providerMetadata{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + authorizePath,
+		TokenEndpoint:                     issuer + tokenPath,
+		IntrospectionEndpoint:             issuer + introspectionPath,
+		RevocationEndpoint:                issuer + revocationPath,
+		DeviceAuthorizationEndpoint:       issuer + deviceAuthPath,
+		UserInfoEndpoint:                  issuer + userInfoPath,
+		JWKSURI:                           issuer + jsonWebKeySetPath,
+		ScopesSupported:                   domain.OpenIDScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials", "password", deviceCodeGrantType},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  o.notary.SigningAlgorithms(),
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{security.CodeChallengeS256, security.CodeChallengePlain},
+	})
+}
+
+func (o *oidcRouter) jsonWebKeySet(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	json.NewEncoder(w).Encode(o.notary.JSONWebKeySet())
+}
+
+// bearerToken returns the token sent on the Authorization header
+// https://tools.ietf.org/html/rfc6750#section-2.1
+func bearerToken(req *http.Request) string {
+	fields := strings.Fields(req.Header.Get("Authorization"))
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "Bearer") {
+		return ""
+	}
+	return fields[1]
+}
 
-// }
+// writeBearerError writes a bearer token error as defined in
+// https://tools.ietf.org/html/rfc6750#section-3
+func writeBearerError(w http.ResponseWriter, code int, err string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s"`, err))
+	errors.WriteErrorWithCode(w, code, err)
+}
+
+// userInfo returns the claims about the token owner allowed by the granted scope
+// https://openid.net/specs/openid-connect-core-1_0.html#UserInfo
+func (o *oidcRouter) userInfo(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		errors.WriteErrorWithCode(w, http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+
+	accessToken := bearerToken(req)
+	if accessToken == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "invalid_request")
+		return
+	}
+
+	token, err := o.notary.AccessToken(accessToken)
+	if err == security.ErrTokenNotFound || (err == nil && token.UserID == 0) {
+		writeBearerError(w, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	if !token.Scope.HasSubscope(domain.Scope{domain.ScopeOpenID}) {
+		writeBearerError(w, http.StatusForbidden, "insufficient_scope")
+		return
+	}
+
+	// the user may have been deleted while the token was still valid
+	user, err := o.context.dao.User.Get(token.UserID)
+	if err != nil || user == nil {
+		writeBearerError(w, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	json.NewEncoder(w).Encode(user.UserInfo(token.Scope))
+}
@@ -0,0 +1,142 @@
+package routes
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+const layoutTemplate = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>{{template "title" .}}</title>
+</head>
+<body>
+{{template "body" .}}
+</body>
+</html>
+`
+
+// pages are the HTML pages shown to the end user
+var pages = map[string]*template.Template{
+	"error": parsePage(`
+{{define "title"}}Error{{end}}
+{{define "body"}}
+	<h1>{{.Title}}</h1>
+	<p>{{.Description}}</p>
+{{end}}
+`),
+	"consent": parsePage(`
+{{define "title"}}Authorize {{.ClientName}}{{end}}
+{{define "body"}}
+	<h1>{{.ClientName}} wants to access your account</h1>
+	<p>It will be allowed to:</p>
+	<ul>{{range .Scope}}<li title="{{.Name}}">{{.Description}}</li>{{end}}</ul>
+	<form method="post">
+		{{range $name, $values := .Params}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">
+		{{end}}{{end}}<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+		<button type="submit" name="consent" value="deny">Deny</button>
+		<button type="submit" name="consent" value="approve">Allow</button>
+	</form>
+{{end}}
+`),
+	"device": parsePage(`
+{{define "title"}}Connect a device{{end}}
+{{define "body"}}
+	<h1>Connect a device</h1>
+	{{if .Invalid}}<p>The code is invalid or has expired, check the code shown on the device.</p>{{end}}
+	<form method="get">
+		<label>Code shown on the device <input name="user_code" value="{{.UserCode}}" autocomplete="off" autocapitalize="characters" required></label>
+		<button type="submit">Continue</button>
+	</form>
+{{end}}
+`),
+	"device_confirm": parsePage(`
+{{define "title"}}Connect a device{{end}}
+{{define "body"}}
+	<h1>Connect {{.ClientName}}</h1>
+	<p>Check that the device shows the code <strong>{{.UserCode}}</strong>. It will be allowed to:</p>
+	<ul>{{range .Scope}}<li title="{{.Name}}">{{.Description}}</li>{{end}}</ul>
+	<form method="post">
+		<input type="hidden" name="user_code" value="{{.UserCode}}">
+		<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+		<button type="submit" name="action" value="deny">Deny</button>
+		<button type="submit" name="action" value="approve">Allow</button>
+	</form>
+{{end}}
+`),
+	"device_done": parsePage(`
+{{define "title"}}Connect a device{{end}}
+{{define "body"}}
+	{{if .Approved}}
+	<h1>Device connected</h1>
+	<p>You can go back to the device.</p>
+	{{else}}
+	<h1>Device denied</h1>
+	<p>The device was not allowed to access your account.</p>
+	{{end}}
+{{end}}
+`),
+	"email_confirmed": parsePage(`
+{{define "title"}}Email confirmed{{end}}
+{{define "body"}}
+	<h1>Email confirmed</h1>
+	<p>{{.Email}} was confirmed as your email address.</p>
+{{end}}
+`),
+	"reset_password": parsePage(`
+{{define "title"}}Reset your password{{end}}
+{{define "body"}}
+	<h1>Choose a new password</h1>
+	{{if .Error}}<p>{{.Error}}</p>{{end}}
+	<form method="post">
+		<input type="hidden" name="token" value="{{.Token}}">
+		<label>New password <input type="password" name="password" autocomplete="new-password" required></label>
+		<button type="submit">Save</button>
+	</form>
+{{end}}
+`),
+	"totp": parsePage(`
+{{define "title"}}Two-factor authentication{{end}}
+{{define "body"}}
+	<h1>Two-factor authentication</h1>
+	{{if .Error}}<p>{{.Error}}</p>{{end}}
+	<form method="post">
+		<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+		<label>Code of your authenticator app or a recovery code <input name="code" autocomplete="one-time-code" autocapitalize="none" required></label>
+		<button type="submit">Continue</button>
+	</form>
+{{end}}
+`),
+	"password_reset_done": parsePage(`
+{{define "title"}}Password changed{{end}}
+{{define "body"}}
+	<h1>Password changed</h1>
+	<p>Log in again with your new password on every device.</p>
+{{end}}
+`),
+}
+
+func parsePage(page string) *template.Template {
+	return template.Must(template.Must(template.New("layout").Parse(layoutTemplate)).Parse(page))
+}
+
+// renderPage writes the named page with status
+func renderPage(w http.ResponseWriter, status int, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := pages[name].Execute(w, data); err != nil {
+		log.Printf("routes: can't render page %q: %v", name, err)
+	}
+}
+
+// renderErrorPage shows an error to the end user when the request
+// can't be answered through a redirect
+func renderErrorPage(w http.ResponseWriter, status int, title string, description string) {
+	renderPage(w, status, "error", struct {
+		Title       string
+		Description string
+	}{title, description})
+}
@@ -0,0 +1,435 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabriel-araujjo/condominio-auth/domain"
+	"github.com/gabriel-araujjo/condominio-auth/errors"
+	"github.com/gabriel-araujjo/condominio-auth/webauthn"
+)
+
+// Passkey end points
+const (
+	// passkeysPath lists the passkeys of the logged in user on GET and registers
+	// one on POST, a passkey is removed on passkeysPath/{id}
+	passkeysPath = "/users/me/passkeys"
+	// passkeyOptionsPath starts the registration of a passkey
+	passkeyOptionsPath = "/users/me/passkeys/options"
+	// loginPasskeyPath logs the user in with a passkey
+	loginPasskeyPath = "/users/login/passkey"
+	// loginPasskeyOptionsPath starts the login with a passkey
+	loginPasskeyOptionsPath = "/users/login/passkey/options"
+)
+
+// Session keys of a WebAuthn ceremony in progress
+const (
+	webauthnCeremonyKey  = "webauthn_ceremony"
+	webauthnChallengeKey = "webauthn_challenge"
+	webauthnAtKey        = "webauthn_at"
+)
+
+// WebAuthn ceremonies
+const (
+	registerCeremony = "register"
+	loginCeremony    = "login"
+)
+
+// ceremonyTimeout is how long the browser waits for the authenticator and
+// how long the challenge is kept
+const ceremonyTimeout = 5 * time.Minute
+
+// base64URL is a binary value encoded as URL safe base64, the encoding
+// WebAuthn clients use on JSON
+type base64URL []byte
+
+func (b base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *base64URL) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// credentialDescriptor identifies a credential on the ceremony options
+type credentialDescriptor struct {
+	Type string    `json:"type"`
+	ID   base64URL `json:"id"`
+}
+
+// passkey is a credential as listed to the user
+type passkey struct {
+	ID         base64URL  `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func newPasskey(credential *domain.Credential) *passkey {
+	p := &passkey{ID: credential.ID, Name: credential.Name, CreatedAt: credential.CreatedAt}
+	if !credential.LastUsedAt.IsZero() {
+		p.LastUsedAt = &credential.LastUsedAt
+	}
+	return p
+}
+
+// userHandle identifies the user on the authenticator, it's returned when
+// logging in with the passkey
+func userHandle(userID int64) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+// startCeremony keeps a new challenge of the ceremony on the session
+func (c *userContext) startCeremony(w http.ResponseWriter, req *http.Request, ceremony string) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	session, err := c.context.Session(req)
+	if err != nil {
+		return nil, err
+	}
+	session.Set(webauthnCeremonyKey, ceremony)
+	session.Set(webauthnChallengeKey, base64.RawURLEncoding.EncodeToString(challenge))
+	session.Set(webauthnAtKey, time.Now().UnixNano())
+	return challenge, c.context.PersistSession(req, w)
+}
+
+// finishCeremony returns the challenge of the ceremony in progress, or nil when
+// there's none or it expired. The challenge is consumed whatever the outcome.
+func (c *userContext) finishCeremony(w http.ResponseWriter, req *http.Request, ceremony string) []byte {
+	session, err := c.context.Session(req)
+	if err != nil {
+		return nil
+	}
+	started, _ := session.Get(webauthnCeremonyKey).(string)
+	encoded, _ := session.Get(webauthnChallengeKey).(string)
+	startedAt, _ := session.Get(webauthnAtKey).(int64)
+	session.Set(webauthnCeremonyKey, "")
+	session.Set(webauthnChallengeKey, "")
+	session.Set(webauthnAtKey, int64(0))
+	c.context.PersistSession(req, w)
+
+	if started != ceremony || time.Since(time.Unix(0, startedAt)) > ceremonyTimeout {
+		return nil
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(challenge) == 0 {
+		return nil
+	}
+	return challenge
+}
+
+// passkeyRegistrationOptions answers the options of navigator.credentials.create
+// registering a passkey for the logged in user
+func (c *userContext) passkeyRegistrationOptions(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+
+	userID, err := c.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	user, err := c.dao.User.Get(userID)
+	if err != nil || user == nil {
+		errors.WriteErrorWithCode(w, http.StatusNotFound, "user not found")
+		return
+	}
+	credentials, err := c.dao.User.Credentials(userID)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "can't list passkeys")
+		return
+	}
+
+	challenge, err := c.startCeremony(w, req, registerCeremony)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	type parameter struct {
+		Type      string `json:"type"`
+		Algorithm int64  `json:"alg"`
+	}
+	params := []parameter{}
+	for _, alg := range webauthn.SupportedAlgorithms {
+		params = append(params, parameter{"public-key", alg})
+	}
+	// the authenticators already holding a passkey of the user don't create another
+	exclude := []credentialDescriptor{}
+	for _, credential := range credentials {
+		exclude = append(exclude, credentialDescriptor{"public-key", credential.ID})
+	}
+
+	displayName := user.Name
+	if displayName == "" {
+		displayName = accountName(user)
+	}
+
+	options := map[string]interface{}{
+		"challenge": base64URL(challenge),
+		"rp": map[string]string{
+			"id":   c.relyingParty.ID,
+			"name": c.relyingParty.Name,
+		},
+		"user": map[string]interface{}{
+			"id":          base64URL(userHandle(userID)),
+			"name":        accountName(user),
+			"displayName": displayName,
+		},
+		"pubKeyCredParams":   params,
+		"timeout":            int64(ceremonyTimeout / time.Millisecond),
+		"excludeCredentials": exclude,
+		"authenticatorSelection": map[string]interface{}{
+			"residentKey":        "required",
+			"requireResidentKey": true,
+			"userVerification":   "preferred",
+		},
+		"attestation": "none",
+	}
+	json.NewEncoder(w).Encode(options)
+}
+
+// registerPasskey keeps the credential created by navigator.credentials.create
+func (c *userContext) registerPasskey(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+
+	userID, err := c.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var params struct {
+		RawID    base64URL `json:"rawId"`
+		Name     string    `json:"name"`
+		Response struct {
+			ClientDataJSON    base64URL `json:"clientDataJSON"`
+			AttestationObject base64URL `json:"attestationObject"`
+		} `json:"response"`
+	}
+	if err = json.NewDecoder(req.Body).Decode(&params); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "cannot decode json")
+		return
+	}
+
+	challenge := c.finishCeremony(w, req, registerCeremony)
+	if challenge == nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "ceremony_expired")
+		return
+	}
+
+	registration, err := c.relyingParty.VerifyRegistration(challenge, params.Response.ClientDataJSON,
+		params.Response.AttestationObject)
+	if err != nil || (len(params.RawID) > 0 && !bytes.Equal(params.RawID, registration.CredentialID)) {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "invalid_credential")
+		return
+	}
+
+	credential := &domain.Credential{
+		ID:        registration.CredentialID,
+		Name:      strings.TrimSpace(params.Name),
+		PublicKey: registration.PublicKey,
+		SignCount: registration.SignCount,
+	}
+	if err = c.dao.User.AddCredential(userID, credential); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusConflict, "passkey_registered")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newPasskey(credential))
+}
+
+// passkeys lists the passkeys the logged in user can log in with
+func (c *userContext) passkeys(w http.ResponseWriter, req *http.Request) {
+	userID, err := c.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	credentials, err := c.dao.User.Credentials(userID)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "can't list passkeys")
+		return
+	}
+	passkeys := []*passkey{}
+	for _, credential := range credentials {
+		passkeys = append(passkeys, newPasskey(credential))
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	json.NewEncoder(w).Encode(passkeys)
+}
+
+// removePasskey removes the passkey passkeysPath/{id} of the logged in user,
+// unless it's the only way left to log in
+func (c *userContext) removePasskey(w http.ResponseWriter, req *http.Request) {
+	userID, err := c.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	credentialID, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(req.URL.Path, passkeysPath+"/"))
+	if err != nil || len(credentialID) == 0 {
+		errors.WriteErrorWithCode(w, http.StatusNotFound, "passkey not found")
+		return
+	}
+
+	last, err := c.lastLoginMethod(userID)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	if last {
+		errors.WriteErrorWithCode(w, http.StatusConflict, "last_login_method")
+		return
+	}
+
+	if err = c.dao.User.RemoveCredential(userID, credentialID); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusNotFound, "passkey not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// passkeyLoginOptions answers the options of navigator.credentials.get, the
+// passkeys are discoverable so the authenticator offers the ones it holds
+func (c *userContext) passkeyLoginOptions(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+
+	challenge, err := c.startCeremony(w, req, loginCeremony)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"challenge":        base64URL(challenge),
+		"rpId":             c.relyingParty.ID,
+		"timeout":          int64(ceremonyTimeout / time.Millisecond),
+		"userVerification": "preferred",
+		"allowCredentials": []credentialDescriptor{},
+	})
+}
+
+// loginPasskey logs the user in with the assertion of navigator.credentials.get.
+// A passkey verifying the user is a second factor by itself, otherwise users
+// enrolled on TOTP still need to send its code.
+func (c *userContext) loginPasskey(w http.ResponseWriter, req *http.Request) {
+	var params struct {
+		RawID    base64URL `json:"rawId"`
+		Response struct {
+			ClientDataJSON    base64URL `json:"clientDataJSON"`
+			AuthenticatorData base64URL `json:"authenticatorData"`
+			Signature         base64URL `json:"signature"`
+			UserHandle        base64URL `json:"userHandle"`
+		} `json:"response"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "cannot decode json")
+		return
+	}
+
+	challenge := c.finishCeremony(w, req, loginCeremony)
+	if challenge == nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "ceremony_expired")
+		return
+	}
+
+	userID, credential, err := c.dao.User.LookupCredential(params.RawID)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if len(params.Response.UserHandle) > 0 && !bytes.Equal(params.Response.UserHandle, userHandle(userID)) {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	assertion, err := c.relyingParty.VerifyAssertion(challenge, credential.PublicKey, params.Response.ClientDataJSON,
+		params.Response.AuthenticatorData, params.Response.Signature)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	// a sign count not increasing may be an authenticator that was cloned
+	if err = c.dao.User.UseCredential(credential.ID, assertion.SignCount); err != nil {
+		log.Printf("routes: passkey of user %d rejected: %v", userID, err)
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	mfaRequired := false
+	if assertion.UserVerified {
+		err = c.context.SetCurrentUserID(req, userID, domain.AMRHardwareKey, domain.AMRMultiFactor)
+	} else {
+		mfaRequired, err = c.logIn(req, userID, "", domain.AMRHardwareKey)
+	}
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	c.context.PersistSession(req, w)
+	if mfaRequired {
+		// the TOTP code must be posted to loginTOTPPath
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "mfa_required")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lastLoginMethod tells whether the user has a single way to log in among the
+// password, the linked identities and the passkeys
+func (c *userContext) lastLoginMethod(userID int64) (bool, error) {
+	user, err := c.dao.User.Get(userID)
+	if err != nil {
+		return false, err
+	}
+	identities, err := c.dao.User.Identities(userID)
+	if err != nil {
+		return false, err
+	}
+	credentials, err := c.dao.User.Credentials(userID)
+	if err != nil {
+		return false, err
+	}
+
+	methods := len(identities) + len(credentials)
+	if user.PasswordHash != "" {
+		methods++
+	}
+	return methods <= 1, nil
+}
+
+// accountName is how the user is known on authenticators, the primary email
+// or else the CPF or the id
+func accountName(user *domain.User) string {
+	if email := user.PrimaryEmail(); email != nil {
+		return email.Email
+	}
+	if user.CPF != "" {
+		return user.CPF
+	}
+	return strconv.FormatInt(user.ID, 10)
+}
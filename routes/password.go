@@ -0,0 +1,206 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"github.com/gabriel-araujjo/condominio-auth/domain"
+	"github.com/gabriel-araujjo/condominio-auth/errors"
+	"github.com/gabriel-araujjo/condominio-auth/security"
+)
+
+// Password reset end points
+const (
+	// forgotPasswordPath sends a reset link or code to the user identified by an email, phone or CPF
+	forgotPasswordPath = "/users/password/forgot"
+	// resetPasswordPath sets a new password, it's also the reset link sent by email
+	resetPasswordPath = "/users/password/reset"
+)
+
+// passwordResetParams is the body of a reset request, either the token of the
+// reset link or the phone with the code sent by SMS must be sent
+type passwordResetParams struct {
+	Token    string `json:"token"`
+	Phone    string `json:"phone"`
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+// forgotPassword answers the same whether the identifier exists or not,
+// the reset link or code is sent on background
+func (c *userContext) forgotPassword(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+
+	var params struct {
+		Identifier string `json:"identifier"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&params); err != nil || params.Identifier == "" {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "cannot decode json")
+		return
+	}
+
+	identifier := params.Identifier
+	if domain.ValidCPF(identifier) {
+		identifier = domain.NormalizeCPF(identifier)
+	}
+	go c.sendPasswordReset(identifier)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendPasswordReset sends a reset link to the identifier when it's an email, a code
+// when it's a phone, or uses the primary email or phone of the user otherwise.
+// Only verified emails and phones get it, the other ones may not belong to the user.
+func (c *userContext) sendPasswordReset(identifier string) {
+	userID, err := c.dao.User.Lookup(identifier)
+	if err != nil {
+		return
+	}
+	user, err := c.dao.User.Get(userID)
+	if err != nil {
+		return
+	}
+
+	email, phone := "", ""
+	primaryEmail, primaryPhone := user.PrimaryEmail(), user.PrimaryPhone()
+	switch {
+	case verifiedEmail(user.Emails, identifier):
+		email = identifier
+	case verifiedPhone(user.Phones, identifier):
+		phone = identifier
+	case primaryEmail != nil && primaryEmail.Verified:
+		email = primaryEmail.Email
+	case primaryPhone != nil && primaryPhone.Verified:
+		phone = primaryPhone.Phone
+	default:
+		return
+	}
+
+	if email != "" {
+		err = c.sendPasswordResetLink(userID, email)
+	} else {
+		err = c.sendPasswordResetOTP(userID, phone)
+	}
+	if err != nil {
+		log.Printf("routes: can't send password reset to user %d: %v", userID, err)
+	}
+}
+
+func (c *userContext) sendPasswordResetLink(userID int64, email string) error {
+	token, err := c.notary.NewPasswordResetToken(userID)
+	if err != nil {
+		return err
+	}
+
+	link := c.notary.Issuer() + resetPasswordPath + "?token=" + url.QueryEscape(token)
+	return c.mailer.Send(email, "Reset your password", fmt.Sprintf(
+		"Open the link below to choose a new password:\n\n%s\n\n"+
+			"If you didn't ask for it, ignore this message, your password wasn't changed.\n", link))
+}
+
+func (c *userContext) sendPasswordResetOTP(userID int64, phone string) error {
+	code, err := c.notary.NewPasswordResetOTP(userID, phone)
+	if err != nil {
+		return err
+	}
+	return c.sms.Send(phone, fmt.Sprintf("%s is your password reset code", code))
+}
+
+// resetPasswordPage is the form opened by the reset link
+func (c *userContext) resetPasswordPage(w http.ResponseWriter, req *http.Request) {
+	renderPage(w, http.StatusOK, "reset_password", struct {
+		Token string
+		Error string
+	}{req.URL.Query().Get("token"), ""})
+}
+
+// resetPassword sets a new password and revokes every session and token of the user.
+// It's posted either as JSON or by the form of the reset link page.
+func (c *userContext) resetPassword(w http.ResponseWriter, req *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	form := mediaType != "application/json"
+
+	var params passwordResetParams
+	if form {
+		params.Token = req.PostFormValue("token")
+		params.Password = req.PostFormValue("password")
+	} else if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "cannot decode json")
+		return
+	}
+
+	fail := func(status int, message string, body interface{}) {
+		if form {
+			renderPage(w, status, "reset_password", struct {
+				Token string
+				Error string
+			}{params.Token, message})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		errors.WriteErrorWithCode(w, status, body)
+	}
+
+	if reason := domain.CheckPasswordStrength(params.Password); reason != "" {
+		fail(http.StatusBadRequest, "The password "+reason+".",
+			&invalidUser{"invalid_user", domain.FieldErrors{"password": reason}})
+		return
+	}
+
+	userID, err := c.passwordResetUser(&params)
+	switch err {
+	case nil:
+	case security.ErrOTPAttemptsExceeded:
+		fail(http.StatusTooManyRequests, "Too many attempts, ask for a new code.", err.Error())
+		return
+	case security.ErrInvalidVerificationToken, security.ErrInvalidOTP, security.ErrOTPNotFound:
+		fail(http.StatusBadRequest, "The link is invalid, has expired or was already used.", "invalid_token")
+		return
+	default:
+		fail(http.StatusInternalServerError, "Unexpected error, try again later.", "server_error")
+		return
+	}
+
+	if err = c.dao.User.SetPassword(userID, params.Password); err == nil {
+		err = c.notary.RevokeUser(userID)
+	}
+	if err != nil {
+		fail(http.StatusInternalServerError, "Unexpected error, try again later.", "server_error")
+		return
+	}
+
+	if form {
+		renderPage(w, http.StatusOK, "password_reset_done", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// passwordResetUser redeems the reset token or the code sent by SMS, an unknown
+// phone is reported as an invalid code to not reveal whether it exists
+func (c *userContext) passwordResetUser(params *passwordResetParams) (int64, error) {
+	if params.Token != "" {
+		verification, err := c.notary.RedeemPasswordResetToken(params.Token)
+		if err != nil {
+			return 0, err
+		}
+		return verification.UserID, nil
+	}
+
+	if params.Phone == "" {
+		return 0, security.ErrInvalidOTP
+	}
+	userID, err := c.dao.User.Lookup(params.Phone)
+	if err != nil {
+		return 0, security.ErrInvalidOTP
+	}
+	if err = c.notary.VerifyPasswordResetOTP(userID, params.Phone, params.Code); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
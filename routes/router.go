@@ -2,31 +2,101 @@ package routes
 
 import (
 	"net/http"
+	"strings"
 
+	"github.com/gabriel-araujjo/condominio-auth/config"
 	"github.com/gabriel-araujjo/condominio-auth/dao"
+	"github.com/gabriel-araujjo/condominio-auth/errors"
+	"github.com/gabriel-araujjo/condominio-auth/federation"
+	"github.com/gabriel-araujjo/condominio-auth/notification"
+	"github.com/gabriel-araujjo/condominio-auth/security"
 	"github.com/gabriel-araujjo/condominio-auth/sessions"
+	"github.com/gabriel-araujjo/condominio-auth/webauthn"
 )
 
-//TODO: Make Dao an interface
+// User end points
+const (
+	loginPath = "/users/login"
+	usersPath = "/users"
+)
+
+// methodHandler dispatches a request to the handler of its method
+type methodHandler map[string]http.HandlerFunc
+
+func (h methodHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if handler, ok := h[req.Method]; ok {
+		handler(w, req)
+		return
+	}
+
+	allowed := make([]string, 0, len(h))
+	for method := range h {
+		allowed = append(allowed, method)
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	errors.WriteErrorWithCode(w, http.StatusMethodNotAllowed, "method not allowed")
+}
 
-func NewServeAuth(dao *dao.Dao, s sessions.Store) http.Handler {
+// NewServeAuth mounts the user, OAuth2 and OpenID Connect end points
+func NewServeAuth(conf *config.Config, dao *dao.Dao, s sessions.Store, notary *security.Notary,
+	mailer notification.Mailer, sms notification.SMSSender, providers federation.Providers) http.Handler {
+	ctx := newContext(conf, dao, s, notary)
+	relyingParty := webauthn.NewRelyingParty(conf.WebAuthn.RPID, conf.WebAuthn.RPName, conf.WebAuthn.Origins)
+	user := &userContext{ctx, notary, mailer, sms, providers, relyingParty}
+	oauth := &oAuth2{ctx, notary}
+	oidc := &oidcRouter{ctx, notary}
 
 	routes := http.NewServeMux()
-	// context = &context{dao, sessions}
-	// userRoutes := newUserRoutes(context)
-	// routes.Handle()
-	// r.HandleFunc
-	// user := &userRouter{dao}
-	// oidc := &oidcRouter{}
-
-	// router.GET("/oidc/auth", oidc.auth)
-	// router.POST("/oidc/auth", oidc.auth)
-	// router.POST("/user/login", user.login)
-	// router.GET("/user/:id", user.get)
-	// router.POST("/user", user.create)
-	// router.DELETE("/user/:id", user.delete)
-
-	//client := &ClientRouter{dao}
-	//router.GET("/client/token", )
+
+	routes.Handle(loginPath, methodHandler{http.MethodPost: user.login})
+	routes.Handle(loginPath+"/", methodHandler{http.MethodGet: user.federatedLogin})
+	routes.Handle(loginTOTPPath, methodHandler{
+		http.MethodGet:  user.loginTOTPPage,
+		http.MethodPost: user.loginTOTP,
+	})
+	routes.Handle(loginPasskeyPath, methodHandler{http.MethodPost: user.loginPasskey})
+	routes.Handle(loginPasskeyOptionsPath, methodHandler{http.MethodPost: user.passkeyLoginOptions})
+	routes.Handle(usersPath, methodHandler{http.MethodPost: user.create})
+	routes.Handle(usersPath+"/", methodHandler{
+		http.MethodGet:    user.get,
+		http.MethodPatch:  user.update,
+		http.MethodDelete: user.delete,
+	})
+	routes.Handle(emailVerificationPath, methodHandler{http.MethodPost: user.requestEmailVerification})
+	routes.Handle(confirmEmailPath, methodHandler{http.MethodGet: user.confirmEmail})
+	routes.Handle(phoneVerificationPath, methodHandler{http.MethodPost: user.requestPhoneVerification})
+	routes.Handle(confirmPhonePath, methodHandler{http.MethodPost: user.confirmPhone})
+	routes.Handle(forgotPasswordPath, methodHandler{http.MethodPost: user.forgotPassword})
+	routes.Handle(resetPasswordPath, methodHandler{
+		http.MethodGet:  user.resetPasswordPage,
+		http.MethodPost: user.resetPassword,
+	})
+	routes.Handle(authorizationsPath, methodHandler{http.MethodGet: user.authorizations})
+	routes.Handle(authorizationsPath+"/", methodHandler{http.MethodDelete: user.revokeAuthorization})
+	routes.Handle(identitiesPath, methodHandler{http.MethodGet: user.identities})
+	routes.Handle(identitiesPath+"/", methodHandler{http.MethodDelete: user.unlinkIdentity})
+	routes.Handle(totpPath, methodHandler{
+		http.MethodPost:   user.enrollTOTP,
+		http.MethodDelete: user.removeTOTP,
+	})
+	routes.Handle(confirmTOTPPath, methodHandler{http.MethodPost: user.confirmTOTP})
+	routes.Handle(passkeysPath, methodHandler{
+		http.MethodGet:  user.passkeys,
+		http.MethodPost: user.registerPasskey,
+	})
+	routes.Handle(passkeysPath+"/", methodHandler{http.MethodDelete: user.removePasskey})
+	routes.Handle(passkeyOptionsPath, methodHandler{http.MethodPost: user.passkeyRegistrationOptions})
+
+	routes.HandleFunc(authorizePath, oauth.authorize)
+	routes.HandleFunc(tokenPath, oauth.token)
+	routes.HandleFunc(introspectionPath, oauth.introspect)
+	routes.HandleFunc(revocationPath, oauth.revoke)
+	routes.HandleFunc(deviceAuthPath, oauth.deviceAuthorization)
+	routes.HandleFunc(devicePath, oauth.device)
+
+	routes.HandleFunc(userInfoPath, oidc.userInfo)
+	routes.Handle(discoveryPath, methodHandler{http.MethodGet: oidc.discovery})
+	routes.Handle(jsonWebKeySetPath, methodHandler{http.MethodGet: oidc.jsonWebKeySet})
+
 	return routes
 }
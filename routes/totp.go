@@ -0,0 +1,353 @@
+package routes
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabriel-araujjo/condominio-auth/domain"
+	"github.com/gabriel-araujjo/condominio-auth/errors"
+	"github.com/gabriel-araujjo/condominio-auth/security"
+)
+
+// TOTP end points
+const (
+	// totpPath enrolls the logged in user on TOTP on POST and removes it on DELETE
+	totpPath = "/users/me/totp"
+	// confirmTOTPPath confirms the enrollment with the first code of the authenticator app
+	confirmTOTPPath = "/users/me/totp/confirm"
+	// loginTOTPPath is the second step of the login of users enrolled on TOTP
+	loginTOTPPath = "/users/login/totp"
+)
+
+// Session keys of a login waiting for the TOTP code
+const (
+	mfaUserKey     = "mfa_user"
+	mfaAMRKey      = "mfa_amr"
+	mfaAtKey       = "mfa_at"
+	mfaAttemptsKey = "mfa_attempts"
+	mfaReturnToKey = "mfa_return_to"
+)
+
+const (
+	// mfaTimeout is how long the TOTP code can be sent after the first step of the login
+	mfaTimeout = 5 * time.Minute
+	// mfaMaxAttempts is how many codes can be tried before logging in again
+	mfaMaxAttempts = 5
+	// recoveryCodesCount is how many recovery codes are issued on enrollment
+	recoveryCodesCount = 10
+)
+
+// totpCode is the body of the requests carrying a TOTP or a recovery code
+type totpCode struct {
+	Code string `json:"code"`
+}
+
+// logIn sets the user of the session, unless the user is enrolled on TOTP.
+// Then the login waits for the TOTP code on loginTOTPPath and mfaRequired
+// is returned. returnTo is where the TOTP login page goes after it.
+func (c *userContext) logIn(req *http.Request, userID int64, returnTo string, amr ...string) (mfaRequired bool, err error) {
+	_, enrolled, err := c.dao.User.TOTPSecret(userID)
+	if err != nil {
+		return false, err
+	}
+	if !enrolled {
+		return false, c.context.SetCurrentUserID(req, userID, amr...)
+	}
+
+	session, err := c.context.Session(req)
+	if err != nil {
+		return false, err
+	}
+	session.Set(mfaUserKey, userID)
+	session.Set(mfaAMRKey, strings.Join(amr, " "))
+	session.Set(mfaAtKey, time.Now().UnixNano())
+	session.Set(mfaAttemptsKey, int64(0))
+	session.Set(mfaReturnToKey, returnTo)
+	return true, nil
+}
+
+// pendingLogin returns the user whose login waits for the TOTP code
+func (c *userContext) pendingLogin(req *http.Request) (int64, bool) {
+	session, err := c.context.Session(req)
+	if err != nil {
+		return 0, false
+	}
+	userID, _ := session.Get(mfaUserKey).(int64)
+	startedAt, _ := session.Get(mfaAtKey).(int64)
+	if userID == 0 || time.Since(time.Unix(0, startedAt)) > mfaTimeout {
+		return 0, false
+	}
+	return userID, true
+}
+
+// clearPendingLogin discards the login waiting for the TOTP code
+func (c *userContext) clearPendingLogin(req *http.Request) {
+	session, err := c.context.Session(req)
+	if err != nil {
+		return
+	}
+	session.Set(mfaUserKey, int64(0))
+	session.Set(mfaAMRKey, "")
+	session.Set(mfaAtKey, int64(0))
+	session.Set(mfaAttemptsKey, int64(0))
+	session.Set(mfaReturnToKey, "")
+}
+
+// verifySecondFactor checks a TOTP code or, when it doesn't look like one,
+// redeems a recovery code. The attempts of the user are limited whatever the
+// end point, so the codes can't be guessed.
+func (c *userContext) verifySecondFactor(userID int64, code string) error {
+	secret, enrolled, err := c.dao.User.TOTPSecret(userID)
+	if err != nil {
+		return err
+	}
+	if !enrolled {
+		return security.ErrInvalidOTP
+	}
+	if err = c.notary.AttemptSecondFactor(userID); err != nil {
+		return err
+	}
+
+	err = security.ErrInvalidOTP
+	if digits := strings.Replace(code, " ", "", -1); len(digits) == 6 {
+		if _, convErr := strconv.Atoi(digits); convErr == nil {
+			err = c.notary.VerifyTOTP(userID, secret, digits)
+		}
+	} else if c.dao.User.RedeemRecoveryCode(userID, security.HashRecoveryCode(code)) == nil {
+		err = nil
+	}
+	if err == nil {
+		c.notary.ResetSecondFactorAttempts(userID)
+	}
+	return err
+}
+
+// loginTOTPPage is the form of the second step, shown after a federated login
+func (c *userContext) loginTOTPPage(w http.ResponseWriter, req *http.Request) {
+	if _, ok := c.pendingLogin(req); !ok {
+		renderErrorPage(w, http.StatusUnauthorized, "Login expired", "Go back to the application and log in again.")
+		return
+	}
+	c.renderTOTPPage(w, req, http.StatusOK, "")
+}
+
+// renderTOTPPage shows the form of the second step with an optional error
+func (c *userContext) renderTOTPPage(w http.ResponseWriter, req *http.Request, status int, message string) {
+	csrfToken, err := c.context.CSRFToken(req)
+	if err == nil {
+		err = c.context.PersistSession(req, w)
+	}
+	if err != nil {
+		renderErrorPage(w, http.StatusInternalServerError, "Unexpected error", "Try again later.")
+		return
+	}
+
+	renderPage(w, status, "totp", struct {
+		Error     string
+		CSRFToken string
+	}{message, csrfToken})
+}
+
+// loginTOTP finishes the login waiting for the TOTP code. It's posted either as
+// JSON after the login end point answers mfa_required or by the TOTP login page.
+func (c *userContext) loginTOTP(w http.ResponseWriter, req *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	form := mediaType != "application/json"
+
+	var params totpCode
+	if form {
+		if !c.context.VerifyCSRFToken(req) {
+			renderErrorPage(w, http.StatusForbidden, "Invalid request", "Reload the page and try again.")
+			return
+		}
+		params.Code = req.PostFormValue("code")
+	} else if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "cannot decode json")
+		return
+	}
+
+	fail := func(status int, title string, message string, body string) {
+		if form {
+			if status == http.StatusBadRequest {
+				c.renderTOTPPage(w, req, status, message)
+			} else {
+				renderErrorPage(w, status, title, message)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		errors.WriteErrorWithCode(w, status, body)
+	}
+
+	userID, ok := c.pendingLogin(req)
+	session, err := c.context.Session(req)
+	if !ok || err != nil {
+		fail(http.StatusUnauthorized, "Login expired", "Go back to the application and log in again.", "login_required")
+		return
+	}
+
+	attempts, _ := session.Get(mfaAttemptsKey).(int64)
+	if attempts >= mfaMaxAttempts {
+		c.clearPendingLogin(req)
+		c.context.PersistSession(req, w)
+		fail(http.StatusTooManyRequests, "Too many attempts", "Go back to the application and log in again.",
+			security.ErrOTPAttemptsExceeded.Error())
+		return
+	}
+	session.Set(mfaAttemptsKey, attempts+1)
+
+	switch err = c.verifySecondFactor(userID, params.Code); err {
+	case nil:
+	case security.ErrInvalidOTP:
+		c.context.PersistSession(req, w)
+		fail(http.StatusBadRequest, "", "The code is invalid, check your authenticator app.", err.Error())
+		return
+	case security.ErrOTPAttemptsExceeded:
+		c.clearPendingLogin(req)
+		c.context.PersistSession(req, w)
+		fail(http.StatusTooManyRequests, "Too many attempts", "Wait a few minutes and log in again.", err.Error())
+		return
+	default:
+		c.context.PersistSession(req, w)
+		fail(http.StatusInternalServerError, "Unexpected error", "Try again later.", "server_error")
+		return
+	}
+
+	amr, _ := session.Get(mfaAMRKey).(string)
+	returnTo, _ := session.Get(mfaReturnToKey).(string)
+	c.clearPendingLogin(req)
+	c.context.SetCurrentUserID(req, userID, append(strings.Fields(amr), domain.AMROTP, domain.AMRMultiFactor)...)
+	c.context.PersistSession(req, w)
+
+	if form {
+		if returnTo == "" {
+			returnTo = "/"
+		}
+		http.Redirect(w, req, returnTo, http.StatusFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// enrollTOTP creates a TOTP secret for the logged in user, it's required on
+// login once confirmed by confirmTOTP
+func (c *userContext) enrollTOTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+
+	userID, err := c.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	user, err := c.dao.User.Get(userID)
+	if err != nil || user == nil {
+		errors.WriteErrorWithCode(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	if _, enrolled, err := c.dao.User.TOTPSecret(userID); err != nil || enrolled {
+		errors.WriteErrorWithCode(w, http.StatusConflict, "totp_enrolled")
+		return
+	}
+
+	secret, err := security.NewTOTPSecret()
+	if err == nil {
+		err = c.dao.User.SetTOTPSecret(userID, secret)
+	}
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	// the authenticator apps list the accounts by issuer and account name
+	issuer := c.notary.Issuer()
+	if parsed, err := url.Parse(issuer); err == nil && parsed.Host != "" {
+		issuer = parsed.Host
+	}
+	json.NewEncoder(w).Encode(struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}{security.EncodeTOTPSecret(secret), security.TOTPURI(issuer, accountName(user), secret)})
+}
+
+// confirmTOTP checks the first code of the authenticator app and answers the
+// recovery codes, they are shown only this time
+func (c *userContext) confirmTOTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+
+	userID, err := c.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var params totpCode
+	if err = json.NewDecoder(req.Body).Decode(&params); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "cannot decode json")
+		return
+	}
+
+	secret, enrolled, err := c.dao.User.TOTPSecret(userID)
+	if err != nil || secret == nil || enrolled {
+		errors.WriteErrorWithCode(w, http.StatusConflict, "totp_not_pending")
+		return
+	}
+	if err = c.notary.VerifyTOTP(userID, secret, params.Code); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, security.ErrInvalidOTP.Error())
+		return
+	}
+
+	codes, hashes, err := security.NewRecoveryCodes(recoveryCodesCount)
+	if err == nil {
+		err = c.dao.User.ConfirmTOTP(userID, hashes)
+	}
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{codes})
+}
+
+// removeTOTP stops requiring TOTP codes on login, a TOTP or a recovery code
+// must be sent to prove the user still holds the second factor
+func (c *userContext) removeTOTP(w http.ResponseWriter, req *http.Request) {
+	userID, err := c.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var params totpCode
+	if err = json.NewDecoder(req.Body).Decode(&params); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "cannot decode json")
+		return
+	}
+
+	switch err = c.verifySecondFactor(userID, params.Code); err {
+	case nil:
+	case security.ErrInvalidOTP:
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, err.Error())
+		return
+	case security.ErrOTPAttemptsExceeded:
+		errors.WriteErrorWithCode(w, http.StatusTooManyRequests, err.Error())
+		return
+	default:
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	if err = c.dao.User.RemoveTOTP(userID); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,13 +2,36 @@ package routes
 
 import (
 	"encoding/json"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/gabriel-araujjo/condominio-auth/domain"
 	"github.com/gabriel-araujjo/condominio-auth/errors"
+	"github.com/gabriel-araujjo/condominio-auth/federation"
+	"github.com/gabriel-araujjo/condominio-auth/notification"
+	"github.com/gabriel-araujjo/condominio-auth/security"
+	"github.com/gabriel-araujjo/condominio-auth/webauthn"
+	jp "github.com/gabriel-araujjo/json-patcher"
 )
 
+// jsonPatchMediaType is the content type of the user updates
+const jsonPatchMediaType = "application/json-patch+json"
+
+// authorizationsPath lists the clients authorized by the logged in user,
+// a client is revoked on authorizationsPath/{client_id}
+const authorizationsPath = "/users/me/authorizations"
+
 type userContext struct {
 	*context
+	notary *security.Notary
+	mailer notification.Mailer
+	sms    notification.SMSSender
+	// providers are the upstream identity providers users can log in with
+	providers federation.Providers
+	// relyingParty verifies the passkeys users log in with
+	relyingParty *webauthn.RelyingParty
 }
 
 func (c *userContext) login(w http.ResponseWriter, req *http.Request) {
@@ -29,19 +52,343 @@ func (c *userContext) login(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	c.context.SetCurrentUserID(req, userID)
+	mfaRequired, err := c.logIn(req, userID, "", domain.AMRPassword)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
 	c.context.PersistSession(req, w)
+	if mfaRequired {
+		// the TOTP code must be posted to loginTOTPPath
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "mfa_required")
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// currentUserOnPath returns the logged in user id when it's on the path
+// /users/{id}, the id "me" also refers to the logged in user
+func (c *userContext) currentUserOnPath(w http.ResponseWriter, req *http.Request) (int64, bool) {
+	userID, err := c.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return 0, false
+	}
+
+	pathID := strings.TrimPrefix(req.URL.Path, usersPath+"/")
+	if pathID != "me" && pathID != strconv.FormatInt(userID, 10) {
+		errors.WriteErrorWithCode(w, http.StatusForbidden, "forbidden")
+		return 0, false
+	}
+	return userID, true
+}
+
 func (c *userContext) get(w http.ResponseWriter, req *http.Request) {
+	userID, ok := c.currentUserOnPath(w, req)
+	if !ok {
+		return
+	}
 
+	user, err := c.dao.User.Get(userID)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	json.NewEncoder(w).Encode(user)
+}
+
+// registration is the body of a self registration request
+type registration struct {
+	Name     string `json:"name"`
+	CPF      string `json:"cpf"`
+	Email    string `json:"email"`
+	Phone    string `json:"phone"`
+	Password string `json:"password"`
+}
+
+// invalidUser tells which fields of a registration are invalid
+type invalidUser struct {
+	Error  string             `json:"error"`
+	Fields domain.FieldErrors `json:"fields"`
+}
+
+func (r *registration) validate() domain.FieldErrors {
+	fields := domain.FieldErrors{}
+	r.Name = strings.TrimSpace(r.Name)
+	r.Email = strings.TrimSpace(r.Email)
+	r.Phone = strings.TrimSpace(r.Phone)
+	r.CPF = domain.NormalizeCPF(r.CPF)
+
+	if r.Name == "" {
+		fields["name"] = "is required"
+	}
+	if r.CPF != "" && !domain.ValidCPF(r.CPF) {
+		fields["cpf"] = "is invalid"
+	}
+	if r.Email == "" && r.Phone == "" {
+		fields["email"] = "email or phone is required"
+	}
+	if r.Email != "" && !domain.ValidEmail(r.Email) {
+		fields["email"] = "is invalid"
+	}
+	if r.Phone != "" && !domain.ValidPhone(r.Phone) {
+		fields["phone"] = "must have only digits, including country and area codes"
+	}
+	if reason := domain.CheckPasswordStrength(r.Password); reason != "" {
+		fields["password"] = reason
+	}
+	return fields
 }
 
+// taken returns the fields already used by other users
+func (c *userContext) taken(r *registration) domain.FieldErrors {
+	fields := domain.FieldErrors{}
+	for field, value := range map[string]string{"cpf": r.CPF, "email": r.Email, "phone": r.Phone} {
+		if value == "" {
+			continue
+		}
+		if _, err := c.dao.User.Lookup(value); err == nil {
+			fields[field] = "is already registered"
+		}
+	}
+	return fields
+}
+
+// create registers a new user, it requires an access token issued to a
+// client through the client_credentials grant with the registration scope
 func (c *userContext) create(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+
+	token, err := c.notary.AccessToken(bearerToken(req))
+	if err != nil || token.UserID != 0 {
+		writeBearerError(w, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+	if !token.Scope.HasSubscope(domain.Scope{domain.ScopeRegistration}) {
+		writeBearerError(w, http.StatusForbidden, "insufficient_scope")
+		return
+	}
+
+	var params registration
+	if err = json.NewDecoder(req.Body).Decode(&params); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "cannot decode json")
+		return
+	}
+
+	if fields := params.validate(); len(fields) > 0 {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, &invalidUser{"invalid_user", fields})
+		return
+	}
+	if fields := c.taken(&params); len(fields) > 0 {
+		errors.WriteErrorWithCode(w, http.StatusConflict, &invalidUser{"user_exists", fields})
+		return
+	}
 
+	user := &domain.User{
+		Name: params.Name,
+		CPF:  params.CPF,
+		// the password is hashed by the database
+		PasswordHash: params.Password,
+	}
+	if params.Email != "" {
+		user.Emails = []domain.Email{{Email: params.Email}}
+	}
+	if params.Phone != "" {
+		user.Phones = []domain.Phone{{Phone: params.Phone}}
+	}
+
+	if err = c.dao.User.Create(user); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "can't create user")
+		return
+	}
+
+	c.verifyNewEmails(user, nil)
+	c.verifyNewPhones(user, nil)
+
+	w.Header().Set("Location", usersPath+"/"+strconv.FormatInt(user.ID, 10))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// patchableFields are the user fields editable through update. The password is
+// left to the reset end point, which proves access to the account and revokes
+// the other sessions.
+var patchableFields = map[string]bool{
+	"name":   true,
+	"cpf":    true,
+	"avatar": true,
+	"phones": true,
+	"emails": true,
+}
+
+// patchField returns the user field on the top of a JSON pointer
+func patchField(path string) string {
+	return strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+}
+
+// validatePatch checks the values set by each operation, CPFs are normalized
+func (c *userContext) validatePatch(userID int64, patch jp.Patch) (invalid domain.FieldErrors, taken domain.FieldErrors) {
+	invalid = domain.FieldErrors{}
+	taken = domain.FieldErrors{}
+
+	for i := range patch {
+		op := &patch[i]
+		if !patchableFields[patchField(op.Path)] {
+			invalid[op.Path] = "can't be changed"
+		}
+		if op.From != "" && !patchableFields[patchField(op.From)] {
+			invalid[op.From] = "can't be changed"
+		}
+		if op.Op != "add" && op.Op != "replace" {
+			continue
+		}
+
+		value, _ := op.Value.(string)
+		field := patchField(op.Path)
+		switch field {
+		case "name":
+			if strings.TrimSpace(value) == "" {
+				invalid[op.Path] = "is required"
+			}
+		case "cpf":
+			if !domain.ValidCPF(value) {
+				invalid[op.Path] = "is invalid"
+			}
+			value = domain.NormalizeCPF(value)
+			op.Value = value
+		case "emails":
+			if !domain.ValidEmail(value) {
+				invalid[op.Path] = "is invalid"
+			}
+		case "phones":
+			if !domain.ValidPhone(value) {
+				invalid[op.Path] = "must have only digits, including country and area codes"
+			}
+		}
+
+		if field == "cpf" || field == "emails" || field == "phones" {
+			if id, err := c.dao.User.Lookup(value); err == nil && id != userID {
+				taken[op.Path] = "is already registered"
+			}
+		}
+	}
+	return
+}
+
+// update applies a JSON patch on the logged in user
+// https://tools.ietf.org/html/rfc6902
+func (c *userContext) update(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+
+	userID, ok := c.currentUserOnPath(w, req)
+	if !ok {
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType != jsonPatchMediaType {
+		w.Header().Set("Accept-Patch", jsonPatchMediaType)
+		errors.WriteErrorWithCode(w, http.StatusUnsupportedMediaType, "unsupported media type")
+		return
+	}
+
+	var patch jp.Patch
+	if err := json.NewDecoder(req.Body).Decode(&patch); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "cannot decode json")
+		return
+	}
+
+	invalid, taken := c.validatePatch(userID, patch)
+	if len(invalid) > 0 {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, &invalidUser{"invalid_user", invalid})
+		return
+	}
+	if len(taken) > 0 {
+		errors.WriteErrorWithCode(w, http.StatusConflict, &invalidUser{"user_exists", taken})
+		return
+	}
+
+	previous, err := c.dao.User.Get(userID)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	if err = c.dao.User.Update(userID, patch); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusUnprocessableEntity, "can't apply patch")
+		return
+	}
+
+	user, err := c.dao.User.Get(userID)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusNotFound, "user not found")
+		return
+	}
+	c.verifyNewEmails(user, previous.Emails)
+	c.verifyNewPhones(user, previous.Phones)
+
+	json.NewEncoder(w).Encode(user)
 }
 
 func (c *userContext) delete(w http.ResponseWriter, req *http.Request) {
+	userID, ok := c.currentUserOnPath(w, req)
+	if !ok {
+		return
+	}
+
+	if err := c.dao.User.Delete(userID); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	c.context.SetCurrentUserID(req, 0)
+	c.context.PersistSession(req, w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizations lists the clients authorized by the logged in user with the granted scopes
+func (c *userContext) authorizations(w http.ResponseWriter, req *http.Request) {
+	userID, err := c.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	authorizations, err := c.dao.Client.GetAuthorizationsByUser(userID)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "can't list authorizations")
+		return
+	}
 
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	json.NewEncoder(w).Encode(authorizations)
+}
+
+// revokeAuthorization removes the scopes granted to a client and invalidates
+// every token issued to it on behalf of the logged in user
+func (c *userContext) revokeAuthorization(w http.ResponseWriter, req *http.Request) {
+	userID, err := c.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	clientID := strings.TrimPrefix(req.URL.Path, authorizationsPath+"/")
+	if clientID == "" || strings.Contains(clientID, "/") {
+		errors.WriteErrorWithCode(w, http.StatusNotFound, "client not found")
+		return
+	}
+
+	if err = c.dao.User.RevokeClient(userID, clientID); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusNotFound, "client not authorized")
+		return
+	}
+
+	if err = c.notary.RevokeGrant(userID, clientID); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "can't revoke tokens")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
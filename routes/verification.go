@@ -0,0 +1,254 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gabriel-araujjo/condominio-auth/domain"
+	"github.com/gabriel-araujjo/condominio-auth/errors"
+	"github.com/gabriel-araujjo/condominio-auth/security"
+)
+
+// Email and phone verification end points
+const (
+	// emailVerificationPath sends the verification link of an email of the logged in user again
+	emailVerificationPath = "/users/me/emails/verification"
+	// confirmEmailPath is the verification link sent by email
+	confirmEmailPath = "/users/emails/confirm"
+	// phoneVerificationPath sends a code by SMS to a phone of the logged in user
+	phoneVerificationPath = "/users/me/phones/verification"
+	// confirmPhonePath receives the code sent by SMS
+	confirmPhonePath = "/users/me/phones/confirm"
+)
+
+// sendEmailVerification sends a link proving that the user owns the email
+func (c *userContext) sendEmailVerification(userID int64, email string) error {
+	token, err := c.notary.NewEmailVerificationToken(userID, email)
+	if err != nil {
+		return err
+	}
+
+	link := c.notary.Issuer() + confirmEmailPath + "?token=" + url.QueryEscape(token)
+	return c.mailer.Send(email, "Confirm your email address", fmt.Sprintf(
+		"Open the link below to confirm your email address:\n\n%s\n\n"+
+			"If you didn't add this email to your account, ignore this message.\n", link))
+}
+
+// verifyNewEmails sends verification links to the unverified emails not in previous
+func (c *userContext) verifyNewEmails(user *domain.User, previous []domain.Email) {
+	for _, email := range user.Emails {
+		if email.Verified || containsEmail(previous, email.Email) {
+			continue
+		}
+		if err := c.sendEmailVerification(user.ID, email.Email); err != nil {
+			log.Printf("routes: can't send verification to %q: %v", email.Email, err)
+		}
+	}
+}
+
+// sendPhoneOTP sends a code by SMS proving that the user owns the phone
+func (c *userContext) sendPhoneOTP(userID int64, phone string) error {
+	code, err := c.notary.NewPhoneOTP(userID, phone)
+	if err != nil {
+		return err
+	}
+	return c.sms.Send(phone, fmt.Sprintf("%s is your verification code", code))
+}
+
+// verifyNewPhones sends codes to the unverified phones not in previous
+func (c *userContext) verifyNewPhones(user *domain.User, previous []domain.Phone) {
+	for _, phone := range user.Phones {
+		if phone.Verified || containsPhone(previous, phone.Phone) {
+			continue
+		}
+		if err := c.sendPhoneOTP(user.ID, phone.Phone); err != nil {
+			log.Printf("routes: can't send verification to %q: %v", phone.Phone, err)
+		}
+	}
+}
+
+func containsPhone(phones []domain.Phone, phone string) bool {
+	for _, p := range phones {
+		if p.Phone == phone {
+			return true
+		}
+	}
+	return false
+}
+
+// verifiedPhone tells whether the phone is among the verified ones
+func verifiedPhone(phones []domain.Phone, phone string) bool {
+	for _, p := range phones {
+		if p.Verified && p.Phone == phone {
+			return true
+		}
+	}
+	return false
+}
+
+func containsEmail(emails []domain.Email, email string) bool {
+	for _, e := range emails {
+		if e.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// requestEmailVerification sends the verification link of an unverified email again
+func (c *userContext) requestEmailVerification(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+
+	userID, err := c.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var params struct {
+		Email string `json:"email"`
+	}
+	if err = json.NewDecoder(req.Body).Decode(&params); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "cannot decode json")
+		return
+	}
+
+	user, err := c.dao.User.Get(userID)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	var email *domain.Email
+	for i := range user.Emails {
+		if user.Emails[i].Email == params.Email {
+			email = &user.Emails[i]
+		}
+	}
+	if email == nil {
+		errors.WriteErrorWithCode(w, http.StatusNotFound, "email not found")
+		return
+	}
+	if email.Verified {
+		errors.WriteErrorWithCode(w, http.StatusConflict, "email already verified")
+		return
+	}
+
+	if err = c.sendEmailVerification(userID, email.Email); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusServiceUnavailable, "can't send email")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// confirmEmail marks the email proven by the token of the verification link as verified
+func (c *userContext) confirmEmail(w http.ResponseWriter, req *http.Request) {
+	verification, err := c.notary.RedeemEmailVerificationToken(req.URL.Query().Get("token"))
+	if err == nil {
+		err = c.dao.User.VerifyEmail(verification.UserID, verification.Subject)
+	}
+	if err != nil {
+		renderErrorPage(w, http.StatusBadRequest, "Invalid link",
+			"The link is invalid, has expired or was already used. Ask for a new one on your account.")
+		return
+	}
+
+	renderPage(w, http.StatusOK, "email_confirmed", struct{ Email string }{verification.Subject})
+}
+
+// phoneVerification is the body of the phone verification requests
+type phoneVerification struct {
+	Phone string `json:"phone"`
+	// Code is the code sent by SMS, only sent on confirmation
+	Code string `json:"code"`
+}
+
+// unverifiedPhone decodes the phone of the logged in user on the request body,
+// writing an error when it's missing or already verified
+func (c *userContext) unverifiedPhone(w http.ResponseWriter, req *http.Request) (int64, *phoneVerification, bool) {
+	userID, err := c.context.CurrentUserID(req)
+	if err != nil || userID == 0 {
+		errors.WriteErrorWithCode(w, http.StatusUnauthorized, "unauthorized")
+		return 0, nil, false
+	}
+
+	var params phoneVerification
+	if err = json.NewDecoder(req.Body).Decode(&params); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, "cannot decode json")
+		return 0, nil, false
+	}
+
+	user, err := c.dao.User.Get(userID)
+	if err != nil {
+		errors.WriteErrorWithCode(w, http.StatusNotFound, "user not found")
+		return 0, nil, false
+	}
+
+	for _, p := range user.Phones {
+		if p.Phone != params.Phone {
+			continue
+		}
+		if p.Verified {
+			errors.WriteErrorWithCode(w, http.StatusConflict, "phone already verified")
+			return 0, nil, false
+		}
+		return userID, &params, true
+	}
+	errors.WriteErrorWithCode(w, http.StatusNotFound, "phone not found")
+	return 0, nil, false
+}
+
+// requestPhoneVerification sends a code by SMS to an unverified phone
+func (c *userContext) requestPhoneVerification(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+
+	userID, params, ok := c.unverifiedPhone(w, req)
+	if !ok {
+		return
+	}
+
+	err := c.sendPhoneOTP(userID, params.Phone)
+	switch err {
+	case nil:
+	case security.ErrOTPResendTooSoon:
+		errors.WriteErrorWithCode(w, http.StatusTooManyRequests, err.Error())
+		return
+	default:
+		errors.WriteErrorWithCode(w, http.StatusServiceUnavailable, "can't send sms")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// confirmPhone marks the phone as verified when the code sent by SMS matches
+func (c *userContext) confirmPhone(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+
+	userID, params, ok := c.unverifiedPhone(w, req)
+	if !ok {
+		return
+	}
+
+	err := c.notary.VerifyPhoneOTP(userID, params.Phone, params.Code)
+	switch err {
+	case nil:
+	case security.ErrInvalidOTP, security.ErrOTPNotFound:
+		errors.WriteErrorWithCode(w, http.StatusBadRequest, err.Error())
+		return
+	case security.ErrOTPAttemptsExceeded:
+		errors.WriteErrorWithCode(w, http.StatusTooManyRequests, err.Error())
+		return
+	default:
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	if err = c.dao.User.VerifyPhone(userID, params.Phone); err != nil {
+		errors.WriteErrorWithCode(w, http.StatusInternalServerError, "can't verify phone")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,233 @@
+package security
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gabriel-araujjo/condominio-auth/domain"
+)
+
+// Device authorization errors, their messages are the error codes defined in
+// https://tools.ietf.org/html/rfc8628#section-3.5
+var (
+	// ErrDeviceCodeNotFound is returned when a device code or a user code is unknown or expired
+	ErrDeviceCodeNotFound = errors.New("expired_token")
+	// ErrAuthorizationPending is returned while the user hasn't decided on the authorization
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	// ErrSlowDown is returned when the device polls faster than the interval
+	ErrSlowDown = errors.New("slow_down")
+	// ErrAccessDenied is returned when the user denies the authorization
+	ErrAccessDenied = errors.New("access_denied")
+	// ErrDeviceAlreadyDecided is returned when approving or denying an authorization twice
+	ErrDeviceAlreadyDecided = errors.New("device authorization already decided")
+)
+
+// errUserCodeTaken is returned by DeviceStore.Add when the user code is in use
+var errUserCodeTaken = errors.New("user code taken")
+
+// DeviceStatus is the user decision about a device authorization
+type DeviceStatus string
+
+// Device authorization statuses
+const (
+	DevicePending  DeviceStatus = "pending"
+	DeviceApproved DeviceStatus = "approved"
+	DeviceDenied   DeviceStatus = "denied"
+)
+
+// slowDownIncrement is added to the polling interval each time a device polls too fast
+// https://tools.ietf.org/html/rfc8628#section-3.5
+const slowDownIncrement = 5
+
+// userCodeAlphabet has no vowels, avoiding words, and no easily confused characters
+// https://tools.ietf.org/html/rfc8628#section-6.1
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// userCodeLength is the length of the user code without the dash
+const userCodeLength = 8
+
+// DeviceAuthorization is the record kept by a DeviceStore for each device code
+type DeviceAuthorization struct {
+	UserCode string
+	ClientID string
+	Scope    domain.Scope
+	Status   DeviceStatus
+	// UserID is who approved or denied the authorization
+	UserID int64
+	// Interval is the minimum amount of seconds between polls
+	Interval  int64
+	ExpiresAt int64
+}
+
+// DeviceCode is issued by the device authorization end point
+type DeviceCode struct {
+	DeviceCode string
+	UserCode   string
+	ExpiresIn  time.Duration
+	Interval   time.Duration
+}
+
+// DeviceStore keeps the pending device authorizations until they expire
+type DeviceStore interface {
+	// Add stores the authorization until its expiration,
+	// returning errUserCodeTaken when its user code is in use
+	Add(deviceCode string, d *DeviceAuthorization) error
+	// Get returns ErrDeviceCodeNotFound when the device code doesn't exist
+	Get(deviceCode string) (*DeviceAuthorization, error)
+	// DeviceCode returns the device code of a user code or ErrDeviceCodeNotFound
+	DeviceCode(userCode string) (string, error)
+	// Decide sets the status of a pending authorization,
+	// returning false when it was already decided
+	Decide(deviceCode string, status DeviceStatus, userID int64) (bool, error)
+	// Poll records a poll at polledAt, returning the time of the previous one or zero
+	Poll(deviceCode string, polledAt int64) (int64, error)
+	// SlowDown increases the polling interval
+	SlowDown(deviceCode string, seconds int64) error
+	// Remove removes an authorization returning false when it didn't exist
+	Remove(deviceCode string) (bool, error)
+}
+
+// NormalizeUserCode removes the separators and the case typed by the user
+func NormalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+}
+
+// FormatUserCode splits a user code in two halves, easing its typing
+func FormatUserCode(userCode string) string {
+	if len(userCode) != userCodeLength {
+		return userCode
+	}
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
+
+func newUserCode() (string, error) {
+	var code [userCodeLength]byte
+	if _, err := rand.Read(code[:]); err != nil {
+		return "", err
+	}
+	for i, b := range code {
+		// 256 isn't multiple of the alphabet size, the bias is irrelevant here
+		code[i] = userCodeAlphabet[int(b)%len(userCodeAlphabet)]
+	}
+	return string(code[:]), nil
+}
+
+// NewDeviceCode starts a device authorization requested by clientID
+// https://tools.ietf.org/html/rfc8628#section-3.2
+func (a *Notary) NewDeviceCode(clientID string, scope domain.Scope) (*DeviceCode, error) {
+	deviceCode, err := a.newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// the store refuses user codes still in use, so a new one is drawn
+	var userCode string
+	for err = errUserCodeTaken; err == errUserCodeTaken; {
+		if userCode, err = newUserCode(); err != nil {
+			return nil, err
+		}
+		err = a.deviceStore.Add(deviceCode, &DeviceAuthorization{
+			UserCode:  userCode,
+			ClientID:  clientID,
+			Scope:     scope,
+			Status:    DevicePending,
+			Interval:  int64(a.devicePollingInterval / time.Second),
+			ExpiresAt: time.Now().Add(a.deviceCodeTTL).Unix(),
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeviceCode{
+		DeviceCode: deviceCode,
+		UserCode:   FormatUserCode(userCode),
+		ExpiresIn:  a.deviceCodeTTL,
+		Interval:   a.devicePollingInterval,
+	}, nil
+}
+
+// DeviceAuthorization returns the pending authorization of a user code typed by the user
+func (a *Notary) DeviceAuthorization(userCode string) (*DeviceAuthorization, error) {
+	deviceCode, err := a.deviceStore.DeviceCode(NormalizeUserCode(userCode))
+	if err != nil {
+		return nil, err
+	}
+	d, err := a.deviceStore.Get(deviceCode)
+	if err != nil {
+		return nil, err
+	}
+	if d.Status != DevicePending {
+		return nil, ErrDeviceAlreadyDecided
+	}
+	return d, nil
+}
+
+// DecideDeviceAuthorization approves or denies the authorization of a user code on behalf of userID
+func (a *Notary) DecideDeviceAuthorization(userCode string, userID int64, approved bool) error {
+	deviceCode, err := a.deviceStore.DeviceCode(NormalizeUserCode(userCode))
+	if err != nil {
+		return err
+	}
+	status := DeviceDenied
+	if approved {
+		status = DeviceApproved
+	}
+	decided, err := a.deviceStore.Decide(deviceCode, status, userID)
+	if err != nil {
+		return err
+	}
+	if !decided {
+		return ErrDeviceAlreadyDecided
+	}
+	return nil
+}
+
+// NewTokensFromDeviceCode is polled by the device until the user decides on the authorization.
+// Once approved, tokens are issued only once.
+// https://tools.ietf.org/html/rfc8628#section-3.4
+func (a *Notary) NewTokensFromDeviceCode(deviceCode string, clientID string) (*Tokens, error) {
+	d, err := a.deviceStore.Get(deviceCode)
+	if err != nil {
+		return nil, err
+	}
+	if d.ClientID != clientID {
+		return nil, ErrDeviceCodeNotFound
+	}
+
+	now := time.Now().Unix()
+	previous, err := a.deviceStore.Poll(deviceCode, now)
+	if err != nil {
+		return nil, err
+	}
+	if previous != 0 && now-previous < d.Interval {
+		if err = a.deviceStore.SlowDown(deviceCode, slowDownIncrement); err != nil {
+			return nil, err
+		}
+		return nil, ErrSlowDown
+	}
+
+	switch d.Status {
+	case DevicePending:
+		return nil, ErrAuthorizationPending
+	case DeviceDenied:
+		a.deviceStore.Remove(deviceCode)
+		return nil, ErrAccessDenied
+	}
+
+	removed, err := a.deviceStore.Remove(deviceCode)
+	if err != nil {
+		return nil, err
+	}
+	if !removed {
+		return nil, ErrDeviceCodeNotFound
+	}
+	return a.NewTokens(clientID, d.UserID, d.Scope)
+}
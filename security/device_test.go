@@ -0,0 +1,109 @@
+package security
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gabriel-araujjo/condominio-auth/domain"
+)
+
+func TestDeviceCode(t *testing.T) {
+	var userID int64 = 233
+	scope := domain.Scope{"openid"}
+
+	t.Run("UserCode", func(t *testing.T) {
+		notary := newTestNotary(t)
+		code, err := notary.NewDeviceCode("kiosk", scope)
+		if err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+		if len(code.UserCode) != userCodeLength+1 || code.UserCode[userCodeLength/2] != '-' {
+			t.Errorf("unexpected user code format %q", code.UserCode)
+		}
+		authorization, err := notary.DeviceAuthorization(strings.ToLower(code.UserCode))
+		if err != nil {
+			t.Fatalf("user code typed in lower case should be found, err = %q", err)
+		}
+		if authorization.ClientID != "kiosk" {
+			t.Errorf("client should be kiosk instead of %q", authorization.ClientID)
+		}
+	})
+
+	t.Run("UserCodeTaken", func(t *testing.T) {
+		notary := newTestNotary(t)
+		code, _ := notary.NewDeviceCode("kiosk", scope)
+		authorization, _ := notary.deviceStore.Get(code.DeviceCode)
+		if err := notary.deviceStore.Add("other", authorization); err != errUserCodeTaken {
+			t.Errorf("err should be errUserCodeTaken instead of %v", err)
+		}
+		if deviceCode, _ := notary.deviceStore.DeviceCode(authorization.UserCode); deviceCode != code.DeviceCode {
+			t.Errorf("user code should keep its device code instead of %q", deviceCode)
+		}
+	})
+
+	t.Run("Pending", func(t *testing.T) {
+		notary := newTestNotary(t)
+		code, _ := notary.NewDeviceCode("kiosk", scope)
+		if _, err := notary.NewTokensFromDeviceCode(code.DeviceCode, "kiosk"); err != ErrAuthorizationPending {
+			t.Errorf("err should be ErrAuthorizationPending instead of %v", err)
+		}
+	})
+
+	t.Run("SlowDown", func(t *testing.T) {
+		notary := newTestNotary(t)
+		code, _ := notary.NewDeviceCode("kiosk", scope)
+		notary.NewTokensFromDeviceCode(code.DeviceCode, "kiosk")
+		if _, err := notary.NewTokensFromDeviceCode(code.DeviceCode, "kiosk"); err != ErrSlowDown {
+			t.Errorf("err should be ErrSlowDown instead of %v", err)
+		}
+		authorization, _ := notary.deviceStore.Get(code.DeviceCode)
+		if authorization.Interval != 5+slowDownIncrement {
+			t.Errorf("interval should be increased instead of %d", authorization.Interval)
+		}
+	})
+
+	t.Run("Approved", func(t *testing.T) {
+		notary := newTestNotary(t)
+		code, _ := notary.NewDeviceCode("kiosk", scope)
+		if err := notary.DecideDeviceAuthorization(code.UserCode, userID, true); err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+		tokens, err := notary.NewTokensFromDeviceCode(code.DeviceCode, "kiosk")
+		if err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+		if tokens.UserID != userID || tokens.RefreshToken == "" {
+			t.Errorf("tokens should be issued to user %d", userID)
+		}
+		if _, err := notary.NewTokensFromDeviceCode(code.DeviceCode, "kiosk"); err != ErrDeviceCodeNotFound {
+			t.Errorf("device code should be used once, err = %v", err)
+		}
+	})
+
+	t.Run("Denied", func(t *testing.T) {
+		notary := newTestNotary(t)
+		code, _ := notary.NewDeviceCode("kiosk", scope)
+		notary.DecideDeviceAuthorization(code.UserCode, userID, false)
+		if _, err := notary.NewTokensFromDeviceCode(code.DeviceCode, "kiosk"); err != ErrAccessDenied {
+			t.Errorf("err should be ErrAccessDenied instead of %v", err)
+		}
+	})
+
+	t.Run("DecidedTwice", func(t *testing.T) {
+		notary := newTestNotary(t)
+		code, _ := notary.NewDeviceCode("kiosk", scope)
+		notary.DecideDeviceAuthorization(code.UserCode, userID, false)
+		if err := notary.DecideDeviceAuthorization(code.UserCode, userID, true); err != ErrDeviceAlreadyDecided {
+			t.Errorf("err should be ErrDeviceAlreadyDecided instead of %v", err)
+		}
+	})
+
+	t.Run("OtherClient", func(t *testing.T) {
+		notary := newTestNotary(t)
+		code, _ := notary.NewDeviceCode("kiosk", scope)
+		notary.DecideDeviceAuthorization(code.UserCode, userID, true)
+		if _, err := notary.NewTokensFromDeviceCode(code.DeviceCode, "other"); err != ErrDeviceCodeNotFound {
+			t.Errorf("err should be ErrDeviceCodeNotFound instead of %v", err)
+		}
+	})
+}
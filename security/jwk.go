@@ -0,0 +1,79 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// JSONWebKey is the public part of a signing key as defined in
+// https://tools.ietf.org/html/rfc7517
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	// RSA public key members
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC public key members
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is the document served on the jwks_uri
+type JSONWebKeySet struct {
+	Keys []*JSONWebKey `json:"keys"`
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// encodeCoordinate encodes an EC coordinate padded to the curve size
+// https://tools.ietf.org/html/rfc7518#section-6.2.1.2
+func encodeCoordinate(i *big.Int, size int) string {
+	b := make([]byte, (size+7)/8)
+	coordinate := i.Bytes()
+	copy(b[len(b)-len(coordinate):], coordinate)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// NewJSONWebKey creates the JWK of a RSA or an ECDSA public key used to verify signatures of alg.
+// The key id is the key thumbprint defined in https://tools.ietf.org/html/rfc7638
+func NewJSONWebKey(key interface{}, alg string) (*JSONWebKey, error) {
+	var (
+		jwk       *JSONWebKey
+		canonical string
+	)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		jwk = &JSONWebKey{
+			Kty: "RSA",
+			N:   encodeBigInt(key.N),
+			E:   encodeBigInt(big.NewInt(int64(key.E))),
+		}
+		canonical = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, jwk.E, jwk.Kty, jwk.N)
+	case *ecdsa.PublicKey:
+		size := key.Curve.Params().BitSize
+		jwk = &JSONWebKey{
+			Kty: "EC",
+			Crv: key.Curve.Params().Name,
+			X:   encodeCoordinate(key.X, size),
+			Y:   encodeCoordinate(key.Y, size),
+		}
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, jwk.Crv, jwk.Kty, jwk.X, jwk.Y)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	thumbprint := sha256.Sum256([]byte(canonical))
+	jwk.Kid = base64.RawURLEncoding.EncodeToString(thumbprint[:])
+	jwk.Use = "sig"
+	jwk.Alg = alg
+	return jwk, nil
+}
@@ -0,0 +1,58 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+)
+
+func TestNewJSONWebKey(t *testing.T) {
+	t.Run("RSAThumbprint", func(t *testing.T) {
+		// example of https://tools.ietf.org/html/rfc7638#section-3.1
+		n, _ := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+		jwk, err := NewJSONWebKey(key, "RS256")
+		if err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+		if jwk.Kid != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+			t.Errorf("kid should be the key thumbprint instead of %q", jwk.Kid)
+		}
+		if jwk.E != "AQAB" {
+			t.Errorf("e should be %q instead of %q", "AQAB", jwk.E)
+		}
+		if jwk.Alg != "RS256" || jwk.Use != "sig" {
+			t.Errorf("alg and use should be RS256 and sig instead of %q and %q", jwk.Alg, jwk.Use)
+		}
+	})
+
+	t.Run("ECDSACoordinatesPadding", func(t *testing.T) {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jwk, err := NewJSONWebKey(&privateKey.PublicKey, "ES512")
+		if err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+		if jwk.Crv != "P-521" {
+			t.Errorf("crv should be P-521 instead of %q", jwk.Crv)
+		}
+		for _, coordinate := range []string{jwk.X, jwk.Y} {
+			if size := base64.RawURLEncoding.DecodedLen(len(coordinate)); size != 66 {
+				t.Errorf("coordinates should have 66 bytes instead of %d", size)
+			}
+		}
+	})
+
+	t.Run("UnsupportedKey", func(t *testing.T) {
+		if _, err := NewJSONWebKey([]byte("secret"), "HS256"); err == nil {
+			t.Error("err should not be nil for symmetric keys")
+		}
+	})
+}
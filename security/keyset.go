@@ -0,0 +1,129 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gabriel-araujjo/condominio-auth/config"
+)
+
+// idTokenTTL is how long an ID token lasts. A replaced key keeps
+// verifying tokens for this long after its successor is promoted.
+const idTokenTTL = 30 * 24 * time.Hour
+
+// signingKey is a key of the keySet
+type signingKey struct {
+	method     jwt.SigningMethod
+	privateKey interface{}
+	publicKey  interface{}
+	jwk        *JSONWebKey
+	promoteAt  time.Time
+	retireAt   time.Time
+}
+
+func (k *signingKey) retired(now time.Time) bool {
+	return !k.retireAt.IsZero() && !now.Before(k.retireAt)
+}
+
+// keySet holds the keys signing and verifying ID tokens, sorted by promotion time
+type keySet struct {
+	keys []*signingKey
+}
+
+func newKeySet(keys []*config.SigningKey) (*keySet, error) {
+	set := &keySet{}
+	for _, key := range keys {
+		method := jwt.GetSigningMethod(key.Algorithm)
+		if method == nil {
+			return nil, fmt.Errorf("invalid signing algorithm %q", key.Algorithm)
+		}
+		jwk, err := NewJSONWebKey(key.VerifyingKey, method.Alg())
+		if err != nil {
+			return nil, err
+		}
+		set.keys = append(set.keys, &signingKey{
+			method:     method,
+			privateKey: key.SigningKey,
+			publicKey:  key.VerifyingKey,
+			jwk:        jwk,
+			promoteAt:  key.PromoteAt,
+			retireAt:   key.RetireAt,
+		})
+	}
+
+	sort.SliceStable(set.keys, func(i, j int) bool {
+		return set.keys[i].promoteAt.Before(set.keys[j].promoteAt)
+	})
+
+	// schedule the retirement of each signing key once the tokens
+	// it signed before the next promotion have expired
+	var successor *signingKey
+	for i := len(set.keys) - 1; i >= 0; i-- {
+		key := set.keys[i]
+		if key.privateKey == nil {
+			continue
+		}
+		if key.retireAt.IsZero() && successor != nil {
+			key.retireAt = successor.promoteAt.Add(idTokenTTL)
+		}
+		successor = key
+	}
+
+	if successor == nil {
+		return nil, errors.New("no signing key")
+	}
+	return set, nil
+}
+
+// signing returns the latest promoted key, or nil if none was promoted by now
+func (s *keySet) signing(now time.Time) *signingKey {
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		key := s.keys[i]
+		if key.privateKey != nil && !now.Before(key.promoteAt) && !key.retired(now) {
+			return key
+		}
+	}
+	return nil
+}
+
+// verifying returns the key identified by kid unless it's retired.
+// Tokens issued without kid are verified with the signing key.
+func (s *keySet) verifying(kid string, now time.Time) *signingKey {
+	if kid == "" {
+		return s.signing(now)
+	}
+	for _, key := range s.keys {
+		if key.jwk.Kid == kid && !key.retired(now) {
+			return key
+		}
+	}
+	return nil
+}
+
+// published returns the keys not retired by now, including the
+// ones waiting for promotion
+func (s *keySet) published(now time.Time) []*JSONWebKey {
+	keys := []*JSONWebKey{}
+	for _, key := range s.keys {
+		if !key.retired(now) {
+			keys = append(keys, key.jwk)
+		}
+	}
+	return keys
+}
+
+// algorithms returns the signing algorithms of the published keys
+func (s *keySet) algorithms(now time.Time) []string {
+	var algs []string
+	seen := map[string]bool{}
+	for _, key := range s.keys {
+		if alg := key.method.Alg(); !key.retired(now) && !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
@@ -0,0 +1,108 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/gabriel-araujjo/condominio-auth/config"
+)
+
+func newTestSigningKey(t *testing.T, promoteAt time.Time) *config.SigningKey {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &config.SigningKey{
+		Algorithm:    "ES256",
+		SigningKey:   privateKey,
+		VerifyingKey: &privateKey.PublicKey,
+		PromoteAt:    promoteAt,
+	}
+}
+
+func TestKeySet(t *testing.T) {
+	now := time.Now()
+	promotion := now.Add(time.Hour)
+
+	current := newTestSigningKey(t, time.Time{})
+	next := newTestSigningKey(t, promotion)
+	previous := newTestSigningKey(t, time.Time{})
+	previous.SigningKey = nil
+	previous.RetireAt = now.Add(time.Minute)
+
+	set, err := newKeySet([]*config.SigningKey{next, current, previous})
+	if err != nil {
+		t.Fatalf("err should be nil instead of %q", err)
+	}
+	kid := func(key *config.SigningKey) string {
+		jwk, _ := NewJSONWebKey(key.VerifyingKey, key.Algorithm)
+		return jwk.Kid
+	}
+	currentKid, nextKid, previousKid := kid(current), kid(next), kid(previous)
+
+	t.Run("SigningBeforePromotion", func(t *testing.T) {
+		if key := set.signing(now); key.jwk.Kid != currentKid {
+			t.Errorf("current key should sign before the promotion")
+		}
+	})
+
+	t.Run("SigningAfterPromotion", func(t *testing.T) {
+		if key := set.signing(promotion); key.jwk.Kid != nextKid {
+			t.Errorf("next key should sign after the promotion")
+		}
+	})
+
+	t.Run("PublishedBeforePromotion", func(t *testing.T) {
+		if keys := set.published(now); len(keys) != 3 {
+			t.Errorf("every key should be published instead of %d keys", len(keys))
+		}
+	})
+
+	t.Run("VerifyingByKid", func(t *testing.T) {
+		for _, kid := range []string{previousKid, currentKid, nextKid} {
+			if key := set.verifying(kid, now); key == nil || key.jwk.Kid != kid {
+				t.Errorf("key %q should verify tokens", kid)
+			}
+		}
+		if key := set.verifying("unknown", now); key != nil {
+			t.Error("unknown kid should not verify tokens")
+		}
+	})
+
+	t.Run("VerifyingWithoutKid", func(t *testing.T) {
+		if key := set.verifying("", now); key == nil || key.jwk.Kid != currentKid {
+			t.Error("tokens without kid should be verified by the signing key")
+		}
+	})
+
+	t.Run("ExplicitRetirement", func(t *testing.T) {
+		later := now.Add(2 * time.Minute)
+		if key := set.verifying(previousKid, later); key != nil {
+			t.Error("previous key should be retired")
+		}
+		if keys := set.published(later); len(keys) != 2 {
+			t.Errorf("retired key should not be published, got %d keys", len(keys))
+		}
+	})
+
+	t.Run("ScheduledRetirement", func(t *testing.T) {
+		if key := set.verifying(currentKid, promotion.Add(idTokenTTL-time.Second)); key == nil {
+			t.Error("replaced key should verify tokens until they expire")
+		}
+		if key := set.verifying(currentKid, promotion.Add(idTokenTTL)); key != nil {
+			t.Error("replaced key should be retired once its tokens expire")
+		}
+		if key := set.verifying(nextKid, promotion.Add(idTokenTTL)); key == nil {
+			t.Error("the latest key should never be retired")
+		}
+	})
+
+	t.Run("NoSigningKey", func(t *testing.T) {
+		if _, err := newKeySet([]*config.SigningKey{previous}); err == nil {
+			t.Error("a key set without signing keys should err")
+		}
+	})
+}
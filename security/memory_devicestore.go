@@ -0,0 +1,121 @@
+package security
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryDevice struct {
+	DeviceAuthorization
+	polledAt int64
+}
+
+// memoryDeviceStore keeps device authorizations on the process memory, it's meant
+// to be used on tests and development environments
+type memoryDeviceStore struct {
+	mutex     sync.Mutex
+	devices   map[string]*memoryDevice
+	userCodes map[string]string
+}
+
+func (s *memoryDeviceStore) get(deviceCode string) *memoryDevice {
+	d, ok := s.devices[deviceCode]
+	if !ok {
+		return nil
+	}
+	if d.ExpiresAt <= time.Now().Unix() {
+		delete(s.devices, deviceCode)
+		delete(s.userCodes, d.UserCode)
+		return nil
+	}
+	return d
+}
+
+func (s *memoryDeviceStore) Add(deviceCode string, d *DeviceAuthorization) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if taken, ok := s.userCodes[d.UserCode]; ok && s.get(taken) != nil {
+		return errUserCodeTaken
+	}
+	s.devices[deviceCode] = &memoryDevice{DeviceAuthorization: *d}
+	s.userCodes[d.UserCode] = deviceCode
+	return nil
+}
+
+func (s *memoryDeviceStore) Get(deviceCode string) (*DeviceAuthorization, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d := s.get(deviceCode)
+	if d == nil {
+		return nil, ErrDeviceCodeNotFound
+	}
+	copied := d.DeviceAuthorization
+	return &copied, nil
+}
+
+func (s *memoryDeviceStore) DeviceCode(userCode string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	deviceCode, ok := s.userCodes[userCode]
+	if !ok || s.get(deviceCode) == nil {
+		return "", ErrDeviceCodeNotFound
+	}
+	return deviceCode, nil
+}
+
+func (s *memoryDeviceStore) Decide(deviceCode string, status DeviceStatus, userID int64) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d := s.get(deviceCode)
+	if d == nil {
+		return false, ErrDeviceCodeNotFound
+	}
+	if d.Status != DevicePending {
+		return false, nil
+	}
+	d.Status = status
+	d.UserID = userID
+	return true, nil
+}
+
+func (s *memoryDeviceStore) Poll(deviceCode string, polledAt int64) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d := s.get(deviceCode)
+	if d == nil {
+		return 0, ErrDeviceCodeNotFound
+	}
+	previous := d.polledAt
+	d.polledAt = polledAt
+	return previous, nil
+}
+
+func (s *memoryDeviceStore) SlowDown(deviceCode string, seconds int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d := s.get(deviceCode)
+	if d == nil {
+		return ErrDeviceCodeNotFound
+	}
+	d.Interval += seconds
+	return nil
+}
+
+func (s *memoryDeviceStore) Remove(deviceCode string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d := s.get(deviceCode)
+	if d == nil {
+		return false, nil
+	}
+	delete(s.devices, deviceCode)
+	delete(s.userCodes, d.UserCode)
+	return true, nil
+}
+
+func newMemoryDeviceStore() DeviceStore {
+	return &memoryDeviceStore{
+		devices:   map[string]*memoryDevice{},
+		userCodes: map[string]string{},
+	}
+}
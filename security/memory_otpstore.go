@@ -0,0 +1,52 @@
+package security
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryOTP struct {
+	codeHash  string
+	attempts  int64
+	expiresAt int64
+}
+
+// memoryOTPStore keeps one-time passwords on the process memory, it's meant
+// to be used on tests and development environments
+type memoryOTPStore struct {
+	mutex sync.Mutex
+	otps  map[string]*memoryOTP
+}
+
+func (s *memoryOTPStore) Add(key string, codeHash string, expiresAt int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.otps[key] = &memoryOTP{codeHash: codeHash, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *memoryOTPStore) Attempt(key string) (string, int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	otp, ok := s.otps[key]
+	if !ok {
+		return "", 0, ErrOTPNotFound
+	}
+	if otp.expiresAt <= time.Now().Unix() {
+		delete(s.otps, key)
+		return "", 0, ErrOTPNotFound
+	}
+	otp.attempts++
+	return otp.codeHash, otp.attempts, nil
+}
+
+func (s *memoryOTPStore) Remove(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.otps, key)
+	return nil
+}
+
+func newMemoryOTPStore() OTPStore {
+	return &memoryOTPStore{otps: map[string]*memoryOTP{}}
+}
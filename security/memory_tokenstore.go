@@ -0,0 +1,198 @@
+package security
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// memoryTokenStore keeps tokens on the process memory, it's meant
+// to be used on tests and development environments
+type memoryTokenStore struct {
+	mutex    sync.Mutex
+	tokens   map[string]*Token
+	families map[string][]string
+	grants   map[memoryGrant][]string
+	codes    map[string]memoryCode
+	sessions map[int64]memorySessions
+}
+
+type memorySessions struct {
+	revokedAt int64
+	expiresAt int64
+}
+
+type memoryGrant struct {
+	userID   int64
+	clientID string
+}
+
+type memoryCode struct {
+	family    string
+	binding   *CodeBinding
+	expiresAt int64
+}
+
+func (s *memoryTokenStore) get(token string) *Token {
+	t, ok := s.tokens[token]
+	if !ok {
+		return nil
+	}
+	if t.ExpiresAt <= time.Now().Unix() {
+		delete(s.tokens, token)
+		return nil
+	}
+	return t
+}
+
+func (s *memoryTokenStore) Contains(token string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.get(token) != nil, nil
+}
+
+func (s *memoryTokenStore) Get(token string) (*Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t := s.get(token)
+	if t == nil {
+		return nil, ErrTokenNotFound
+	}
+	copied := *t
+	return &copied, nil
+}
+
+func (s *memoryTokenStore) Add(token string, t *Token) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	copied := *t
+	s.tokens[token] = &copied
+	if t.Family != "" {
+		s.families[t.Family] = append(s.families[t.Family], token)
+	}
+	if t.UserID != 0 {
+		grant := memoryGrant{t.UserID, t.ClientID}
+		s.grants[grant] = append(s.grants[grant], token)
+	}
+	return nil
+}
+
+func (s *memoryTokenStore) Remove(token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.tokens, token)
+	return nil
+}
+
+func (s *memoryTokenStore) Rotate(token string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t := s.get(token)
+	if t == nil {
+		return false, ErrTokenNotFound
+	}
+	if t.Rotated {
+		return false, nil
+	}
+	t.Rotated = true
+	return true, nil
+}
+
+func (s *memoryTokenStore) RemoveFamily(family string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, token := range s.families[family] {
+		delete(s.tokens, token)
+	}
+	delete(s.families, family)
+	return nil
+}
+
+func (s *memoryTokenStore) RemoveByUserAndClient(userID int64, clientID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	grant := memoryGrant{userID, clientID}
+	for _, token := range s.grants[grant] {
+		delete(s.tokens, token)
+	}
+	delete(s.grants, grant)
+	return nil
+}
+
+func (s *memoryTokenStore) RedeemCode(codeHash string, family string, expiresAt int64) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	code, ok := s.codes[codeHash]
+	if ok && code.family != "" && code.expiresAt > time.Now().Unix() {
+		return code.family, nil
+	}
+	code.family = family
+	code.expiresAt = expiresAt
+	s.codes[codeHash] = code
+	return "", nil
+}
+
+func (s *memoryTokenStore) AddCodeBinding(codeHash string, binding *CodeBinding, expiresAt int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.codes[codeHash] = memoryCode{binding: binding, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *memoryTokenStore) GetCodeBinding(codeHash string) (*CodeBinding, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	code, ok := s.codes[codeHash]
+	if !ok || code.expiresAt <= time.Now().Unix() {
+		return nil, nil
+	}
+	return code.binding, nil
+}
+
+func (s *memoryTokenStore) Close() error {
+	return nil
+}
+
+func (s *memoryTokenStore) RemoveByUser(userID int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for token, t := range s.tokens {
+		if t.UserID == userID {
+			delete(s.tokens, token)
+		}
+	}
+	for grant := range s.grants {
+		if grant.userID == userID {
+			delete(s.grants, grant)
+		}
+	}
+	return nil
+}
+
+func (s *memoryTokenStore) RevokeSessions(userID int64, revokedAt int64, expiresAt int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sessions[userID] = memorySessions{revokedAt: revokedAt, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *memoryTokenStore) SessionsRevokedAt(userID int64) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sessions, ok := s.sessions[userID]
+	if !ok || sessions.expiresAt <= time.Now().Unix() {
+		return 0, nil
+	}
+	return sessions.revokedAt, nil
+}
+
+func newMemoryTokenStore() (TokenStore, io.Closer, error) {
+	s := &memoryTokenStore{
+		tokens:   map[string]*Token{},
+		families: map[string][]string{},
+		grants:   map[memoryGrant][]string{},
+		codes:    map[string]memoryCode{},
+		sessions: map[int64]memorySessions{},
+	}
+	return s, s, nil
+}
@@ -8,9 +8,11 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,69 +21,328 @@ import (
 	"github.com/gabriel-araujjo/condominio-auth/domain"
 )
 
-//TODO: Add refresh token support
+var (
+	// ErrTokenNotFound is returned when a token is unknown, expired or revoked
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrInvalidRefreshToken is returned when a refresh token can't be used by the client
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is
+	// used again. The whole token family is revoked when it happens.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrTokenOfOtherClient is returned when a client tries to revoke a token issued to another one
+	ErrTokenOfOtherClient = errors.New("token issued to another client")
+	// ErrInvalidCode is returned when an authorization code is malformed or expired
+	ErrInvalidCode = errors.New("invalid code")
+	// ErrCodeReused is returned when an authorization code is redeemed twice
+	ErrCodeReused = errors.New("code reused")
+)
+
+// TokenKind tells access tokens and refresh tokens apart
+type TokenKind string
+
+// Token kinds
+const (
+	AccessToken  TokenKind = "access"
+	RefreshToken TokenKind = "refresh"
+)
+
+// Token is the record kept by a TokenStore for each issued token
+type Token struct {
+	Kind TokenKind
+	// UserID is zero on tokens issued to the client itself,
+	// then the client is the subject
+	UserID   int64
+	ClientID string
+	Scope    domain.Scope
+	// Family groups every token descending from the same grant,
+	// refresh token rotations included
+	Family    string
+	IssuedAt  int64
+	ExpiresAt int64
+	// Rotated is set on refresh tokens already exchanged
+	Rotated bool
+}
+
+// Subject returns the user id, or the client id when the token
+// was issued through the client_credentials grant
+func (t *Token) Subject() string {
+	if t.UserID == 0 {
+		return t.ClientID
+	}
+	return strconv.FormatInt(t.UserID, 10)
+}
+
+// Tokens are the tokens issued to a client on a grant
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration
+	UserID       int64
+	Scope        domain.Scope
+}
 
-// TokenStore is a map with a token and its scopes
+// TokenStore is a map with a token and its record
 type TokenStore interface {
-	// tokenSignature param is the third part of the token
-	// the base64 encoded signature bytes
 	Contains(token string) (bool, error)
-	Get(token string) (userID int64, scope domain.Scope, err error)
-	Add(token string, expiresAt int64, userID int64, scope domain.Scope) error
+	// Get returns ErrTokenNotFound when the token doesn't exist
+	Get(token string) (*Token, error)
+	// Add stores the token until its expiration
+	Add(token string, t *Token) error
 	Remove(token string) error
+	// Rotate marks a refresh token as used, returning false if
+	// it was already marked
+	Rotate(token string) (bool, error)
+	// RemoveFamily removes every token of a family
+	RemoveFamily(family string) error
+	// RemoveByUserAndClient removes every token issued to a client on behalf of a user
+	RemoveByUserAndClient(userID int64, clientID string) error
+	// RemoveByUser removes every token issued on behalf of a user
+	RemoveByUser(userID int64) error
+	// RevokeSessions records that the login sessions of a user started until
+	// revokedAt, in nanoseconds, are revoked. The record lasts until expiresAt.
+	RevokeSessions(userID int64, revokedAt int64, expiresAt int64) error
+	// SessionsRevokedAt returns when the login sessions of a user were revoked or zero
+	SessionsRevokedAt(userID int64) (int64, error)
+	// RedeemCode records a code as redeemed until expiresAt, binding it to a token family.
+	// If the code was already redeemed the family bound on the first redemption is
+	// returned, otherwise an empty string is returned.
+	RedeemCode(codeHash string, family string, expiresAt int64) (string, error)
+	// AddCodeBinding binds the authorization request parameters to a code until expiresAt
+	AddCodeBinding(codeHash string, binding *CodeBinding, expiresAt int64) error
+	// GetCodeBinding returns nil when nothing is bound to the code
+	GetCodeBinding(codeHash string) (*CodeBinding, error)
 }
 
 // Notary controls the bureaucracy of access tokens
 type Notary struct {
-	method     jwt.SigningMethod
-	tokenStore TokenStore
-	privateKey interface{}
-	publicKey  interface{}
-	codeCipher cipher.Block
-	closer     io.Closer
+	keys        *keySet
+	tokenStore  TokenStore
+	deviceStore DeviceStore
+	otpStore    OTPStore
+	codeCipher  cipher.Block
+	closer      io.Closer
+	issuer      string
+
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	codeTTL         time.Duration
+
+	deviceCodeTTL         time.Duration
+	devicePollingInterval time.Duration
+
+	verificationSecret   []byte
+	emailVerificationTTL time.Duration
+	phoneOTPTTL          time.Duration
+	passwordResetTTL     time.Duration
+	otpMaxAttempts       int64
 }
 
 // NewIDTokenWithClaims creates a new access token with the especified claims
 func (a *Notary) NewIDTokenWithClaims(claims *domain.Claims) string {
-	claims.ExpiresAt = time.Now().Add(30 * 24 * time.Hour).Unix()
-	claims.NotBefore = time.Now().Unix()
-	res, _ := jwt.NewWithClaims(a.method, claims).SignedString(a.privateKey)
+	now := time.Now()
+	key := a.keys.signing(now)
+	if key == nil {
+		return ""
+	}
+	claims.Issuer = a.issuer
+	claims.ExpiresAt = now.Add(idTokenTTL).Unix()
+	claims.NotBefore = now.Unix()
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.jwk.Kid
+	res, _ := token.SignedString(key.privateKey)
 	return res
 }
 
-// VerifyIDToken checks the access token signature and whether the token is revoked
+// Issuer returns the identifier of this authorization server
+func (a *Notary) Issuer() string {
+	return a.issuer
+}
+
+// SigningAlgorithms returns the algorithms of the keys signing ID tokens
+func (a *Notary) SigningAlgorithms() []string {
+	return a.keys.algorithms(time.Now())
+}
+
+// JSONWebKeySet returns the keys that verify the ID tokens signatures,
+// keys about to be promoted are included
+func (a *Notary) JSONWebKeySet() *JSONWebKeySet {
+	return &JSONWebKeySet{Keys: a.keys.published(time.Now())}
+}
+
+// VerifyIDToken checks the access token signature with the key identified
+// by the kid header
 func (a *Notary) VerifyIDToken(tokenString string) (*domain.Claims, error) {
 
 	var claims domain.Claims
 	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
-		if token.Method.Alg() != a.method.Alg() {
+		kid, _ := token.Header["kid"].(string)
+		key := a.keys.verifying(kid, time.Now())
+		if key == nil {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Method.Alg())
 		}
-		return a.publicKey, nil
+		return key.publicKey, nil
 	})
 	return &claims, err
 }
 
+// AccessToken returns the record of an access token, ErrTokenNotFound is
+// returned when the token is unknown, expired, revoked or a refresh token
+func (a *Notary) AccessToken(accessToken string) (*Token, error) {
+	t, err := a.tokenStore.Get(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if t.Kind != AccessToken {
+		return nil, ErrTokenNotFound
+	}
+	return t, nil
+}
+
+// Introspect returns the record of an access token or a refresh token,
+// ErrTokenNotFound is returned when the token is unknown, expired, revoked
+// or already rotated
+func (a *Notary) Introspect(token string) (*Token, error) {
+	t, err := a.tokenStore.Get(token)
+	if err != nil {
+		return nil, err
+	}
+	if t.Rotated {
+		return nil, ErrTokenNotFound
+	}
+	return t, nil
+}
+
 // VerifyAccessToken verifies if the access token is for userID and whether the scope iscovered
 func (a *Notary) VerifyAccessToken(accessToken string, userID int64, scope ...string) error {
-	uID, allowedScope, err := a.tokenStore.Get(accessToken)
+	t, err := a.tokenStore.Get(accessToken)
 	if err != nil {
 		return err
 	}
 
-	if uID != userID {
-		return errors.New("invalid access_toke")
+	if t.Kind != AccessToken || t.UserID != userID {
+		return errors.New("invalid access_token")
 	}
 
-	if !allowedScope.HasSubscope(scope) {
+	if !t.Scope.HasSubscope(scope) {
 		return fmt.Errorf("invalid scope %q", strings.Join(scope, " "))
 	}
 
 	return nil
 }
 
-// NewAccessToken generate an access or a refresh token
-func (a *Notary) NewAccessToken(duration time.Duration, userID int64, scope ...string) (string, error) {
+// NewAccessToken generates an access token lasting the configured access token TTL
+func (a *Notary) NewAccessToken(clientID string, userID int64, scope ...string) (token string, expiresIn time.Duration, err error) {
+	return a.addToken(AccessToken, "", clientID, userID, scope)
+}
+
+// NewTokens generates an access token and a refresh token starting a new token family
+func (a *Notary) NewTokens(clientID string, userID int64, scope domain.Scope) (*Tokens, error) {
+	return a.newTokensOnFamily(newFamily(), clientID, userID, scope, scope)
+}
+
+// NewClientTokens generates an access token on behalf of the client itself, no refresh
+// token is issued since the client can always ask for a new access token
+// https://tools.ietf.org/html/rfc6749#section-4.4.3
+func (a *Notary) NewClientTokens(clientID string, scope domain.Scope) (*Tokens, error) {
+	accessToken, expiresIn, err := a.addToken(AccessToken, "", clientID, 0, scope)
+	if err != nil {
+		return nil, err
+	}
+	return &Tokens{
+		AccessToken: accessToken,
+		ExpiresIn:   expiresIn,
+		Scope:       scope,
+	}, nil
+}
+
+// RefreshTokens rotates a refresh token issued to clientID, returning a new access token
+// and a new refresh token of the same family. A narrower scope may be requested
+// for the access token, an empty scope keeps the original one.
+//
+// When a refresh token is used twice the whole family is revoked, since either the
+// client or an attacker is holding a stolen token.
+func (a *Notary) RefreshTokens(refreshToken string, clientID string, scope domain.Scope) (*Tokens, error) {
+	t, err := a.tokenStore.Get(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Kind != RefreshToken || t.ClientID != clientID || !t.Scope.HasSubscope(scope) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	fresh, err := a.tokenStore.Rotate(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		if err = a.tokenStore.RemoveFamily(t.Family); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if len(scope) == 0 {
+		scope = t.Scope
+	}
+	return a.newTokensOnFamily(t.Family, clientID, t.UserID, t.Scope, scope)
+}
+
+func (a *Notary) newTokensOnFamily(family, clientID string, userID int64, refreshScope, accessScope domain.Scope) (*Tokens, error) {
+	refreshToken, _, err := a.addToken(RefreshToken, family, clientID, userID, refreshScope)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, expiresIn, err := a.addToken(AccessToken, family, clientID, userID, accessScope)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    expiresIn,
+		UserID:       userID,
+		Scope:        accessScope,
+	}, nil
+}
+
+func (a *Notary) addToken(kind TokenKind, family, clientID string, userID int64, scope domain.Scope) (token string, expiresIn time.Duration, err error) {
+	token, err = a.newToken()
+	if err != nil {
+		return "", 0, err
+	}
+
+	expiresIn = a.accessTokenTTL
+	if kind == RefreshToken {
+		expiresIn = a.refreshTokenTTL
+	}
+
+	now := time.Now()
+	return token, expiresIn, a.tokenStore.Add(token, &Token{
+		Kind:      kind,
+		UserID:    userID,
+		ClientID:  clientID,
+		Scope:     scope,
+		Family:    family,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(expiresIn).Unix(),
+	})
+}
+
+// newFamily generates a random token family identifier
+func newFamily() string {
+	var family [16]byte
+	rand.Read(family[:])
+	return base64.RawURLEncoding.EncodeToString(family[:])
+}
+
+// newToken generates an opaque token not yet present on the token store
+func (a *Notary) newToken() (string, error) {
 	var (
 		tokenBytes [33]byte
 		token      string
@@ -97,32 +358,79 @@ func (a *Notary) NewAccessToken(duration time.Duration, userID int64, scope ...s
 			return "", err
 		}
 		if !contains {
-			break
+			return token, nil
 		}
 	}
-	return token, a.tokenStore.Add(token, time.Now().Add(duration).Unix(), userID, scope)
 }
 
-// RevokeAccessToken revokes an access token
-func (a *Notary) RevokeAccessToken(accessToken string) error {
-	return a.tokenStore.Remove(accessToken)
+// RevokeToken revokes a token issued to clientID. Revoking a refresh token
+// also revokes every token of its family. Unknown tokens are ignored.
+// https://tools.ietf.org/html/rfc7009#section-2.1
+func (a *Notary) RevokeToken(token string, clientID string) error {
+	t, err := a.tokenStore.Get(token)
+	if err == ErrTokenNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if t.ClientID != clientID {
+		return ErrTokenOfOtherClient
+	}
+
+	if t.Kind == RefreshToken && t.Family != "" {
+		return a.tokenStore.RemoveFamily(t.Family)
+	}
+	return a.tokenStore.Remove(token)
+}
+
+// RevokeGrant revokes every access token and refresh token issued to clientID on behalf of userID
+func (a *Notary) RevokeGrant(userID int64, clientID string) error {
+	return a.tokenStore.RemoveByUserAndClient(userID, clientID)
+}
+
+// sessionMaxAge is how long a login session lasts, the default max age of the session store
+const sessionMaxAge = 30 * 24 * time.Hour
+
+// RevokeUser revokes every token issued on behalf of a user and every
+// login session the user started until now
+func (a *Notary) RevokeUser(userID int64) error {
+	if err := a.tokenStore.RemoveByUser(userID); err != nil {
+		return err
+	}
+	now := time.Now()
+	return a.tokenStore.RevokeSessions(userID, now.UnixNano(), now.Add(sessionMaxAge).Unix())
+}
+
+// SessionRevoked tells whether a login session of a user started at loginAt,
+// in nanoseconds, was revoked by RevokeUser
+func (a *Notary) SessionRevoked(userID int64, loginAt int64) (bool, error) {
+	revokedAt, err := a.tokenStore.SessionsRevokedAt(userID)
+	if err != nil {
+		return false, err
+	}
+	return revokedAt != 0 && loginAt <= revokedAt, nil
 }
 
 // rawClientCode stores the client authorization code
 //
-// XX XX = client id            (4 bytes)
-// N     = permission count     (1 byte )
-// PP    = permission           (2 bytes)
-// HH HH = hash                 (4 bytes)
-// R*    = random section
+//	XX XX = client id            (4 bytes)
+//	N     = permission count     (1 byte )
+//	PP    = permission           (2 bytes)
+//	UU UU = user id              (8 bytes)
+//	A     = authentication methods (1 byte, see amrMethods)
+//	HH HH = hash                 (8 bytes)
+//	TT TT = issued at            (4 bytes)
+//	R*    = random section
 //
-//         01 23 4 5 67 89 AB CD EF
-// 0x00    XX XX|N|R|PP|PP|PP|RR RR
-// 0x10    PP|PP|P P|PP|PP|PP|RR RR
-// 0x20    PP|PP|P P|PP|PP|PP|RR RR
-// 0x30    PP|PP|P P|PP|PP|PP|RR RR
-// 0x40    PP|PP|P P|PP|UU|UU|RR RR
-// 0x50    UU|UU|H H HH HH HH|RR RR
+//	        01 23 4 5 67 89 AB CD EF
+//	0x00    XX XX|N|R|PP|PP|PP|RR RR
+//	0x10    PP|PP|P P|PP|PP|PP|RR RR
+//	0x20    PP|PP|P P|PP|PP|PP|RR RR
+//	0x30    PP|PP|P P|PP|PP|PP|RR RR
+//	0x40    PP|PP|P P|PP|UU|UU|A R RR
+//	0x50    UU|UU|H H HH HH HH|TT TT
 type rawClientCode [0x60]byte
 
 func (code rawClientCode) clientID() int64 {
@@ -156,9 +464,24 @@ func (code rawClientCode) userID() (uID int64) {
 	return
 }
 
+// amr returns the authentication methods flagged on the code
+func (code rawClientCode) amr() []string {
+	var amr []string
+	for i, method := range amrMethods {
+		if code[0x4C]&(1<<uint(i)) != 0 {
+			amr = append(amr, method)
+		}
+	}
+	return amr
+}
+
+func (code rawClientCode) issuedAt() int64 {
+	return int64(binary.BigEndian.Uint32(code[0x5C:0x60]))
+}
+
 func (code rawClientCode) strip() (stripped []byte) {
 	toRead := code[4]
-	stripped = make([]byte, 0, 4+toRead*2+8)
+	stripped = make([]byte, 0, 4+toRead*2+13)
 	stripped = append(stripped, code[0:4]...)
 	i := 6
 	for i < 0x60 && toRead > 0 {
@@ -169,13 +492,20 @@ func (code rawClientCode) strip() (stripped []byte) {
 		i += 2
 		toRead--
 	}
-	stripped = append(stripped, code[0x48:0x4C]...)
+	stripped = append(stripped, code[0x48:0x4D]...)
 	stripped = append(stripped, code[0x50:0x54]...)
+	stripped = append(stripped, code[0x5C:0x60]...)
 	return
 }
 
-// NewClientCode generate a new code to be used on authorization end point
-func (a *Notary) NewClientCode(clientID int64, scope []int64, userID int64) (string, error) {
+// amrMethods are the authentication methods a code can carry, each one is a bit
+// of the methods byte in the order listed
+var amrMethods = []string{domain.AMRPassword, domain.AMROTP, domain.AMRMultiFactor, domain.AMRFederated,
+	domain.AMRHardwareKey}
+
+// NewClientCode generate a new code to be used on authorization end point,
+// amr are the methods the user authenticated with
+func (a *Notary) NewClientCode(clientID int64, scope []int64, userID int64, amr ...string) (string, error) {
 	if len(scope) > 25 {
 		return "", errors.New("max of 25 scopes per code")
 	}
@@ -211,18 +541,25 @@ func (a *Notary) NewClientCode(clientID int64, scope []int64, userID int64) (str
 
 	// uid first 4 bytes
 	binary.BigEndian.PutUint32(message[0x48:0x4C], uint32(userID>>32))
-	// random bytes
-	rng.Read(message[0x4C:0x50])
+	// authentication methods followed by random bytes
+	for i, method := range amrMethods {
+		for _, used := range amr {
+			if used == method {
+				message[0x4C] |= 1 << uint(i)
+			}
+		}
+	}
+	rng.Read(message[0x4D:0x50])
 	// uid last 4 bytes
 	binary.BigEndian.PutUint32(message[0x50:0x54], uint32(userID))
 
+	// write issue time
+	binary.BigEndian.PutUint32(message[0x5C:0x60], uint32(time.Now().Unix()))
+
 	// write hash
 	hash := sha256.Sum256(message.strip())
 	copy(message[0x54:0x5C], hash[0:8])
 
-	// stuff final code random bytes
-	rng.Read(message[0x5C:0x60])
-
 	blockSize := a.codeCipher.BlockSize()
 	for i = 0; i < 0x60; i += blockSize {
 		a.codeCipher.Encrypt(message[i:i+blockSize], message[i:i+blockSize])
@@ -233,7 +570,22 @@ func (a *Notary) NewClientCode(clientID int64, scope []int64, userID int64) (str
 
 // DecipherCode get the client and the scope of a code
 func (a *Notary) DecipherCode(code string) (clientID int64, scope []int64, uID int64, err error) {
-	var message rawClientCode
+	message, err := a.decipherCode(code)
+	if err != nil {
+		return
+	}
+
+	clientID = message.clientID()
+	scope = message.scopeIDs()
+	uID = message.userID()
+	return
+}
+
+func (a *Notary) decipherCode(code string) (message rawClientCode, err error) {
+	if len(code) != base64.URLEncoding.EncodedLen(len(message)) {
+		err = errors.New("invalid code")
+		return
+	}
 	_, err = base64.URLEncoding.Decode(message[:], []byte(code))
 	if err != nil {
 		return
@@ -248,15 +600,114 @@ func (a *Notary) DecipherCode(code string) (clientID int64, scope []int64, uID i
 
 	if !bytes.Equal(hash[0:8], message.hash()) {
 		err = errors.New("invalid code")
-		return
 	}
-
-	clientID = message.clientID()
-	scope = message.scopeIDs()
-	uID = message.userID()
 	return
 }
 
+// Code is a redeemed authorization code
+type Code struct {
+	ClientID int64
+	ScopeIDs []int64
+	UserID   int64
+	IssuedAt int64
+	// AMR are the methods the user authenticated with
+	AMR []string
+	// Challenged tells whether the code was bound to a PKCE code challenge
+	Challenged bool
+	// Nonce is the nonce sent on the authorization request
+	Nonce string
+	// family holds the tokens issued from this code
+	family string
+}
+
+func hashCode(code string) string {
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
+
+// CodeBinding holds the authorization request parameters a code must be redeemed with
+type CodeBinding struct {
+	// Challenge is the PKCE code challenge, nil when the client sent none
+	Challenge *CodeChallenge
+	// RedirectURI is the redirect_uri sent on the authorization request, empty when omitted
+	RedirectURI string
+	// Nonce is the OpenID Connect nonce to be echoed on the ID token
+	Nonce string
+}
+
+// BindCode ties the authorization request parameters to a code issued on the authorization
+// end point, the code will only be redeemed with the matching code verifier and redirect URI
+// https://tools.ietf.org/html/rfc7636#section-4.4
+// https://tools.ietf.org/html/rfc6749#section-4.1.3
+func (a *Notary) BindCode(code string, binding *CodeBinding) error {
+	return a.tokenStore.AddCodeBinding(hashCode(code), binding, time.Now().Add(a.codeTTL).Unix())
+}
+
+// RedeemCode deciphers an authorization code issued to clientID making sure it is
+// redeemed only once and within the configured code TTL. The verifier is the PKCE
+// code verifier, it must be empty when the code has no challenge bound. The redirectURI
+// must match the one bound to the code, if any.
+//
+// Redeeming a code twice revokes the tokens issued from the first redemption,
+// as recommended on https://tools.ietf.org/html/rfc6749#section-4.1.2
+func (a *Notary) RedeemCode(code string, clientID int64, verifier string, redirectURI string) (*Code, error) {
+	message, err := a.decipherCode(code)
+	if err != nil || message.clientID() != clientID {
+		return nil, ErrInvalidCode
+	}
+
+	issuedAt := message.issuedAt()
+	expiresAt := time.Unix(issuedAt, 0).Add(a.codeTTL)
+	if time.Now().After(expiresAt) {
+		return nil, ErrInvalidCode
+	}
+
+	codeHash := hashCode(code)
+	binding, err := a.tokenStore.GetCodeBinding(codeHash)
+	if err != nil {
+		return nil, err
+	}
+	if binding == nil {
+		binding = &CodeBinding{}
+	}
+	challenge := binding.Challenge
+	if challenge == nil && verifier != "" || challenge != nil && !challenge.Verify(verifier) {
+		return nil, ErrInvalidCode
+	}
+	if binding.RedirectURI != "" && binding.RedirectURI != redirectURI {
+		return nil, ErrInvalidCode
+	}
+
+	family := newFamily()
+	previousFamily, err := a.tokenStore.RedeemCode(codeHash, family, expiresAt.Unix())
+	if err != nil {
+		return nil, err
+	}
+	if previousFamily != "" {
+		if err = a.tokenStore.RemoveFamily(previousFamily); err != nil {
+			return nil, err
+		}
+		return nil, ErrCodeReused
+	}
+
+	return &Code{
+		ClientID:   message.clientID(),
+		ScopeIDs:   message.scopeIDs(),
+		UserID:     message.userID(),
+		IssuedAt:   issuedAt,
+		AMR:        message.amr(),
+		Challenged: challenge != nil,
+		Nonce:      binding.Nonce,
+		family:     family,
+	}, nil
+}
+
+// NewTokensFromCode issues an access token and a refresh token for a redeemed code.
+// They get revoked if the code is redeemed again.
+func (a *Notary) NewTokensFromCode(code *Code, clientID string, scope domain.Scope) (*Tokens, error) {
+	return a.newTokensOnFamily(code.family, clientID, code.UserID, scope, scope)
+}
+
 // Close closes any remain connection
 func (a *Notary) Close() error {
 	return a.closer.Close()
@@ -265,13 +716,21 @@ func (a *Notary) Close() error {
 // NewNotary creates a notary following config specs
 func NewNotary(config *config.Config) (*Notary, error) {
 	var (
-		tokenStore TokenStore
-		closer     io.Closer
-		err        error
+		tokenStore  TokenStore
+		deviceStore DeviceStore
+		otpStore    OTPStore
+		closer      io.Closer
+		err         error
 	)
 	switch config.Notary.TokenStoreType {
 	case "redis":
-		tokenStore, closer, err = newRedisTokenStore(config)
+		pool := newRedisPool(config)
+		tokenStore, deviceStore, closer = &redisTokenStore{pool: pool}, &redisDeviceStore{pool: pool}, pool
+		otpStore = &redisOTPStore{pool: pool}
+	case "memory":
+		tokenStore, closer, err = newMemoryTokenStore()
+		deviceStore = newMemoryDeviceStore()
+		otpStore = newMemoryOTPStore()
 	default:
 		return nil, errors.New("invalid TokenStoreType")
 	}
@@ -279,14 +738,37 @@ func NewNotary(config *config.Config) (*Notary, error) {
 		return nil, err
 	}
 
-	privateKey, _ := aes.NewCipher(config.Notary.CodeCipherSecret)
+	privateKey, err := aes.NewCipher(config.Notary.CodeCipherSecret)
+	if err != nil {
+		closer.Close()
+		return nil, err
+	}
+
+	keys, err := newKeySet(config.Notary.SigningKeys)
+	if err != nil {
+		closer.Close()
+		return nil, err
+	}
 
 	return &Notary{
-		method:     jwt.GetSigningMethod(config.Notary.JWTAlgorithm),
-		tokenStore: tokenStore,
-		privateKey: config.Notary.JWTSigningKey,
-		publicKey:  config.Notary.JWTVerifyingKey,
-		codeCipher: privateKey,
-		closer:     closer,
+		keys:            keys,
+		tokenStore:      tokenStore,
+		deviceStore:     deviceStore,
+		otpStore:        otpStore,
+		codeCipher:      privateKey,
+		closer:          closer,
+		issuer:          config.Notary.Issuer,
+		accessTokenTTL:  config.Notary.AccessTokenTTL,
+		refreshTokenTTL: config.Notary.RefreshTokenTTL,
+		codeTTL:         config.Notary.CodeTTL,
+
+		deviceCodeTTL:         config.Notary.DeviceCodeTTL,
+		devicePollingInterval: config.Notary.DevicePollingInterval,
+
+		verificationSecret:   config.Notary.VerificationSecret,
+		emailVerificationTTL: config.Notary.EmailVerificationTTL,
+		phoneOTPTTL:          config.Notary.PhoneOTPTTL,
+		passwordResetTTL:     config.Notary.PasswordResetTTL,
+		otpMaxAttempts:       int64(config.Notary.OTPMaxAttempts),
 	}, nil
 }
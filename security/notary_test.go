@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/gabriel-araujjo/condominio-auth/domain"
 )
 
 func TestClientCode(t *testing.T) {
@@ -73,3 +76,451 @@ func TestCodeUniqueness(t *testing.T) {
 		fmt.Printf("%s\n", code)
 	}
 }
+
+func newTestNotary(t *testing.T) *Notary {
+	tokenStore, closer, err := newMemoryTokenStore()
+	if err != nil {
+		t.Fatalf("can't create token store due to %q", err.Error())
+	}
+	return &Notary{
+		tokenStore:      tokenStore,
+		deviceStore:     newMemoryDeviceStore(),
+		otpStore:        newMemoryOTPStore(),
+		closer:          closer,
+		accessTokenTTL:  time.Hour,
+		refreshTokenTTL: 24 * time.Hour,
+
+		deviceCodeTTL:         10 * time.Minute,
+		devicePollingInterval: 5 * time.Second,
+
+		verificationSecret:   []byte("verification secret"),
+		emailVerificationTTL: time.Hour,
+		phoneOTPTTL:          10 * time.Minute,
+		passwordResetTTL:     time.Hour,
+		otpMaxAttempts:       3,
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	var userID int64 = 233
+	scope := domain.Scope{"openid", "profile"}
+
+	t.Run("Rotation", func(t *testing.T) {
+		notary := newTestNotary(t)
+		tokens, err := notary.NewTokens("client", userID, scope)
+		if err != nil {
+			t.Fatalf("error while creating tokens %q", err.Error())
+		}
+
+		rotated, err := notary.RefreshTokens(tokens.RefreshToken, "client", nil)
+		if err != nil {
+			t.Fatalf("error while refreshing tokens %q", err.Error())
+		}
+
+		if rotated.RefreshToken == tokens.RefreshToken {
+			t.Error("refresh token was not rotated")
+		}
+
+		if rotated.UserID != userID || !reflect.DeepEqual(rotated.Scope, scope) {
+			t.Errorf("unmatch grant %d %q", rotated.UserID, rotated.Scope)
+		}
+
+		if err := notary.VerifyAccessToken(rotated.AccessToken, userID, "profile"); err != nil {
+			t.Errorf("rotated access token should be valid instead of %q", err.Error())
+		}
+	})
+
+	t.Run("NarrowScope", func(t *testing.T) {
+		notary := newTestNotary(t)
+		tokens, _ := notary.NewTokens("client", userID, scope)
+
+		rotated, err := notary.RefreshTokens(tokens.RefreshToken, "client", domain.Scope{"profile"})
+		if err != nil {
+			t.Fatalf("error while refreshing tokens %q", err.Error())
+		}
+		if err := notary.VerifyAccessToken(rotated.AccessToken, userID, "openid"); err == nil {
+			t.Error("access token should not cover the dropped scope")
+		}
+
+		if _, err := notary.RefreshTokens(rotated.RefreshToken, "client", domain.Scope{"email"}); err != ErrInvalidRefreshToken {
+			t.Errorf("widening scope should fail with %q instead of %v", ErrInvalidRefreshToken, err)
+		}
+	})
+
+	t.Run("OtherClient", func(t *testing.T) {
+		notary := newTestNotary(t)
+		tokens, _ := notary.NewTokens("client", userID, scope)
+
+		if _, err := notary.RefreshTokens(tokens.RefreshToken, "other", nil); err != ErrInvalidRefreshToken {
+			t.Errorf("expecting %q instead of %v", ErrInvalidRefreshToken, err)
+		}
+	})
+
+	t.Run("AccessTokenAsRefreshToken", func(t *testing.T) {
+		notary := newTestNotary(t)
+		tokens, _ := notary.NewTokens("client", userID, scope)
+
+		if _, err := notary.RefreshTokens(tokens.AccessToken, "client", nil); err != ErrInvalidRefreshToken {
+			t.Errorf("expecting %q instead of %v", ErrInvalidRefreshToken, err)
+		}
+	})
+
+	t.Run("ReuseRevokesFamily", func(t *testing.T) {
+		notary := newTestNotary(t)
+		tokens, _ := notary.NewTokens("client", userID, scope)
+		rotated, _ := notary.RefreshTokens(tokens.RefreshToken, "client", nil)
+		unrelated, _ := notary.NewTokens("client", userID, scope)
+
+		if _, err := notary.RefreshTokens(tokens.RefreshToken, "client", nil); err != ErrRefreshTokenReused {
+			t.Fatalf("expecting %q instead of %v", ErrRefreshTokenReused, err)
+		}
+
+		for _, token := range []string{tokens.AccessToken, rotated.AccessToken, rotated.RefreshToken} {
+			if contains, _ := notary.tokenStore.Contains(token); contains {
+				t.Errorf("token %q of the reused family should be revoked", token)
+			}
+		}
+
+		if err := notary.VerifyAccessToken(unrelated.AccessToken, userID, "openid"); err != nil {
+			t.Errorf("tokens of other families should be kept, got %q", err.Error())
+		}
+	})
+}
+
+func TestRedeemCode(t *testing.T) {
+	var cipherKey [32]byte
+	rand.Reader.Read(cipherKey[:])
+	cipher, err := aes.NewCipher(cipherKey[:])
+	if err != nil {
+		t.Fatalf("can't create cipher due to %q", err.Error())
+	}
+
+	var clientID int64 = 1
+	var userID int64 = 233
+	scope := domain.Scope{"openid"}
+
+	newNotary := func(codeTTL time.Duration) *Notary {
+		notary := newTestNotary(t)
+		notary.codeCipher = cipher
+		notary.codeTTL = codeTTL
+		return notary
+	}
+
+	t.Run("SingleUse", func(t *testing.T) {
+		notary := newNotary(time.Minute)
+		code, _ := notary.NewClientCode(clientID, []int64{1}, userID)
+
+		redeemed, err := notary.RedeemCode(code, clientID, "", "")
+		if err != nil {
+			t.Fatalf("error while redeeming code %q", err.Error())
+		}
+		if redeemed.UserID != userID || !reflect.DeepEqual(redeemed.ScopeIDs, []int64{1}) {
+			t.Errorf("unmatch code content %#v", redeemed)
+		}
+
+		tokens, err := notary.NewTokensFromCode(redeemed, "client", scope)
+		if err != nil {
+			t.Fatalf("error while creating tokens %q", err.Error())
+		}
+
+		if _, err := notary.RedeemCode(code, clientID, "", ""); err != ErrCodeReused {
+			t.Fatalf("expecting %q instead of %v", ErrCodeReused, err)
+		}
+
+		for _, token := range []string{tokens.AccessToken, tokens.RefreshToken} {
+			if contains, _ := notary.tokenStore.Contains(token); contains {
+				t.Errorf("token %q issued from the replayed code should be revoked", token)
+			}
+		}
+	})
+
+	t.Run("AMR", func(t *testing.T) {
+		notary := newNotary(time.Minute)
+		code, _ := notary.NewClientCode(clientID, []int64{1}, userID, domain.AMRPassword, domain.AMROTP)
+
+		redeemed, err := notary.RedeemCode(code, clientID, "", "")
+		if err != nil {
+			t.Fatalf("error while redeeming code %q", err.Error())
+		}
+		if amr := []string{domain.AMRPassword, domain.AMROTP}; !reflect.DeepEqual(redeemed.AMR, amr) {
+			t.Errorf("amr should be %v instead of %v", amr, redeemed.AMR)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		notary := newNotary(-time.Minute)
+		code, _ := notary.NewClientCode(clientID, []int64{1}, userID)
+
+		if _, err := notary.RedeemCode(code, clientID, "", ""); err != ErrInvalidCode {
+			t.Errorf("expecting %q instead of %v", ErrInvalidCode, err)
+		}
+	})
+
+	t.Run("OtherClient", func(t *testing.T) {
+		notary := newNotary(time.Minute)
+		code, _ := notary.NewClientCode(clientID, []int64{1}, userID)
+
+		if _, err := notary.RedeemCode(code, clientID+1, "", ""); err != ErrInvalidCode {
+			t.Errorf("expecting %q instead of %v", ErrInvalidCode, err)
+		}
+		if _, err := notary.RedeemCode(code, clientID, "", ""); err != nil {
+			t.Errorf("code should be kept for its client, got %q", err.Error())
+		}
+	})
+
+	t.Run("CodeChallenge", func(t *testing.T) {
+		notary := newNotary(time.Minute)
+		code, _ := notary.NewClientCode(clientID, []int64{1}, userID)
+		challenge, _ := NewCodeChallenge(testCodeChallenge, CodeChallengeS256)
+		if err := notary.BindCode(code, &CodeBinding{Challenge: challenge}); err != nil {
+			t.Fatalf("error while binding challenge %q", err.Error())
+		}
+
+		for _, verifier := range []string{"", testCodeChallenge} {
+			if _, err := notary.RedeemCode(code, clientID, verifier, ""); err != ErrInvalidCode {
+				t.Errorf("verifier %q: expecting %q instead of %v", verifier, ErrInvalidCode, err)
+			}
+		}
+
+		redeemed, err := notary.RedeemCode(code, clientID, testCodeVerifier, "")
+		if err != nil {
+			t.Fatalf("error while redeeming code %q", err.Error())
+		}
+		if !redeemed.Challenged {
+			t.Error("redeemed code should be challenged")
+		}
+	})
+
+	t.Run("RedirectURI", func(t *testing.T) {
+		notary := newNotary(time.Minute)
+		code, _ := notary.NewClientCode(clientID, []int64{1}, userID)
+		redirectURI := "https://client.example/callback"
+		if err := notary.BindCode(code, &CodeBinding{RedirectURI: redirectURI}); err != nil {
+			t.Fatalf("error while binding redirect uri %q", err.Error())
+		}
+
+		for _, other := range []string{"", "https://evil.example/callback"} {
+			if _, err := notary.RedeemCode(code, clientID, "", other); err != ErrInvalidCode {
+				t.Errorf("redirect uri %q: expecting %q instead of %v", other, ErrInvalidCode, err)
+			}
+		}
+
+		if _, err := notary.RedeemCode(code, clientID, "", redirectURI); err != nil {
+			t.Fatalf("error while redeeming code %q", err.Error())
+		}
+	})
+
+	t.Run("Nonce", func(t *testing.T) {
+		notary := newNotary(time.Minute)
+		code, _ := notary.NewClientCode(clientID, []int64{1}, userID)
+		if err := notary.BindCode(code, &CodeBinding{Nonce: "n-0S6_WzA2Mj"}); err != nil {
+			t.Fatalf("error while binding nonce %q", err.Error())
+		}
+
+		redeemed, err := notary.RedeemCode(code, clientID, "", "")
+		if err != nil {
+			t.Fatalf("error while redeeming code %q", err.Error())
+		}
+		if redeemed.Nonce != "n-0S6_WzA2Mj" {
+			t.Errorf("nonce should be %q instead of %q", "n-0S6_WzA2Mj", redeemed.Nonce)
+		}
+	})
+
+	t.Run("UnexpectedVerifier", func(t *testing.T) {
+		notary := newNotary(time.Minute)
+		code, _ := notary.NewClientCode(clientID, []int64{1}, userID)
+
+		if _, err := notary.RedeemCode(code, clientID, testCodeVerifier, ""); err != ErrInvalidCode {
+			t.Errorf("expecting %q instead of %v", ErrInvalidCode, err)
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		notary := newNotary(time.Minute)
+		code, _ := notary.NewClientCode(clientID, []int64{1}, userID)
+
+		if _, err := notary.RedeemCode(code+code, clientID, "", ""); err != ErrInvalidCode {
+			t.Errorf("expecting %q instead of %v", ErrInvalidCode, err)
+		}
+	})
+}
+
+func TestAccessToken(t *testing.T) {
+	notary := newTestNotary(t)
+	tokens, err := notary.NewTokens("client", 233, domain.Scope{"openid", "email"})
+	if err != nil {
+		t.Fatalf("error while creating tokens %q", err.Error())
+	}
+
+	t.Run("Record", func(t *testing.T) {
+		token, err := notary.AccessToken(tokens.AccessToken)
+		if err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+		if token.UserID != 233 || token.ClientID != "client" || !token.Scope.HasSubscope(domain.Scope{"email"}) {
+			t.Errorf("unexpected token record %v", token)
+		}
+	})
+
+	t.Run("RefreshToken", func(t *testing.T) {
+		if _, err := notary.AccessToken(tokens.RefreshToken); err != ErrTokenNotFound {
+			t.Errorf("err should be ErrTokenNotFound instead of %v", err)
+		}
+	})
+}
+
+func TestIntrospect(t *testing.T) {
+	notary := newTestNotary(t)
+	tokens, err := notary.NewTokens("client", 233, domain.Scope{"openid"})
+	if err != nil {
+		t.Fatalf("error while creating tokens %q", err.Error())
+	}
+
+	for _, token := range []string{tokens.AccessToken, tokens.RefreshToken} {
+		if _, err := notary.Introspect(token); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+	}
+
+	if _, err := notary.RefreshTokens(tokens.RefreshToken, "client", nil); err != nil {
+		t.Fatalf("error while refreshing tokens %q", err.Error())
+	}
+
+	if _, err := notary.Introspect(tokens.RefreshToken); err != ErrTokenNotFound {
+		t.Errorf("rotated refresh token should not be active, err = %v", err)
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	scope := domain.Scope{"openid"}
+
+	t.Run("AccessToken", func(t *testing.T) {
+		notary := newTestNotary(t)
+		tokens, _ := notary.NewTokens("client", 233, scope)
+		if err := notary.RevokeToken(tokens.AccessToken, "client"); err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+		if _, err := notary.Introspect(tokens.AccessToken); err != ErrTokenNotFound {
+			t.Error("access token should be revoked")
+		}
+		if _, err := notary.Introspect(tokens.RefreshToken); err != nil {
+			t.Error("refresh token should not be revoked with the access token")
+		}
+	})
+
+	t.Run("RefreshTokenRevokesFamily", func(t *testing.T) {
+		notary := newTestNotary(t)
+		tokens, _ := notary.NewTokens("client", 233, scope)
+		if err := notary.RevokeToken(tokens.RefreshToken, "client"); err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+		for _, token := range []string{tokens.AccessToken, tokens.RefreshToken} {
+			if _, err := notary.Introspect(token); err != ErrTokenNotFound {
+				t.Errorf("token %q should be revoked", token)
+			}
+		}
+	})
+
+	t.Run("OtherClient", func(t *testing.T) {
+		notary := newTestNotary(t)
+		tokens, _ := notary.NewTokens("client", 233, scope)
+		if err := notary.RevokeToken(tokens.AccessToken, "other"); err != ErrTokenOfOtherClient {
+			t.Errorf("err should be ErrTokenOfOtherClient instead of %v", err)
+		}
+		if _, err := notary.Introspect(tokens.AccessToken); err != nil {
+			t.Error("access token should not be revoked by other client")
+		}
+	})
+
+	t.Run("UnknownToken", func(t *testing.T) {
+		notary := newTestNotary(t)
+		if err := notary.RevokeToken("unknown", "client"); err != nil {
+			t.Errorf("unknown tokens should be ignored instead of %q", err)
+		}
+	})
+}
+
+func TestNewClientTokens(t *testing.T) {
+	notary := newTestNotary(t)
+	tokens, err := notary.NewClientTokens("client", domain.Scope{"users:create"})
+	if err != nil {
+		t.Fatalf("err should be nil instead of %q", err)
+	}
+
+	if tokens.RefreshToken != "" {
+		t.Error("client_credentials grant should not issue refresh tokens")
+	}
+
+	token, err := notary.AccessToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("err should be nil instead of %q", err)
+	}
+	if token.UserID != 0 || token.Subject() != "client" {
+		t.Errorf("client should be the token subject instead of %q", token.Subject())
+	}
+}
+
+func TestRevokeGrant(t *testing.T) {
+	notary := newTestNotary(t)
+	scope := domain.Scope{"openid"}
+	first, _ := notary.NewTokens("client", 233, scope)
+	second, _ := notary.NewTokens("client", 233, scope)
+	otherClient, _ := notary.NewTokens("other", 233, scope)
+	otherUser, _ := notary.NewTokens("client", 234, scope)
+
+	if err := notary.RevokeGrant(233, "client"); err != nil {
+		t.Fatalf("err should be nil instead of %q", err)
+	}
+
+	for _, tokens := range []*Tokens{first, second} {
+		for _, token := range []string{tokens.AccessToken, tokens.RefreshToken} {
+			if _, err := notary.Introspect(token); err != ErrTokenNotFound {
+				t.Errorf("token %q should be revoked", token)
+			}
+		}
+	}
+
+	for _, tokens := range []*Tokens{otherClient, otherUser} {
+		if _, err := notary.Introspect(tokens.AccessToken); err != nil {
+			t.Errorf("token %q should not be revoked", tokens.AccessToken)
+		}
+	}
+}
+
+func TestRevokeUser(t *testing.T) {
+	notary := newTestNotary(t)
+	scope := domain.Scope{"openid"}
+	first, _ := notary.NewTokens("client", 233, scope)
+	otherClient, _ := notary.NewTokens("other", 233, scope)
+	otherUser, _ := notary.NewTokens("client", 234, scope)
+	loginAt := time.Now().UnixNano()
+
+	if revoked, err := notary.SessionRevoked(233, loginAt); err != nil || revoked {
+		t.Errorf("session should not be revoked before RevokeUser, err %v", err)
+	}
+
+	if err := notary.RevokeUser(233); err != nil {
+		t.Fatalf("err should be nil instead of %q", err)
+	}
+
+	for _, tokens := range []*Tokens{first, otherClient} {
+		for _, token := range []string{tokens.AccessToken, tokens.RefreshToken} {
+			if _, err := notary.Introspect(token); err != ErrTokenNotFound {
+				t.Errorf("token %q should be revoked", token)
+			}
+		}
+	}
+	if _, err := notary.Introspect(otherUser.AccessToken); err != nil {
+		t.Errorf("token %q should not be revoked", otherUser.AccessToken)
+	}
+
+	if revoked, _ := notary.SessionRevoked(233, loginAt); !revoked {
+		t.Error("session started before RevokeUser should be revoked")
+	}
+	if revoked, _ := notary.SessionRevoked(233, time.Now().UnixNano()); revoked {
+		t.Error("session started after RevokeUser should not be revoked")
+	}
+	if revoked, _ := notary.SessionRevoked(234, loginAt); revoked {
+		t.Error("session of other user should not be revoked")
+	}
+}
@@ -0,0 +1,156 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// One-time password errors
+var (
+	// ErrOTPNotFound is returned when there's no pending code or it has expired
+	ErrOTPNotFound = errors.New("expired_code")
+	// ErrInvalidOTP is returned when the code doesn't match the one sent
+	ErrInvalidOTP = errors.New("invalid_code")
+	// ErrOTPAttemptsExceeded is returned when the code was tried too many times,
+	// the code is discarded and a new one must be sent
+	ErrOTPAttemptsExceeded = errors.New("too_many_attempts")
+	// ErrOTPResendTooSoon is returned when a new code is asked within otpResendCooldown
+	ErrOTPResendTooSoon = errors.New("slow_down")
+)
+
+// phoneVerification is the purpose of the codes proving the ownership of a phone
+const phoneVerification = "phone_verification"
+
+// otpDigits is the length of the codes sent by SMS
+const otpDigits = 6
+
+// otpAttempts and otpSent prefix the counters of the codes tried and sent for a key,
+// they outlive the codes so sending a new code doesn't give more attempts
+const (
+	otpAttempts = "otp_attempts:"
+	otpSent     = "otp_sent:"
+)
+
+const (
+	// otpAttemptsWindow is how long the attempts on every code of a key are counted
+	otpAttemptsWindow = time.Hour
+	// otpResendCooldown is the least time between two codes sent for a key
+	otpResendCooldown = time.Minute
+)
+
+// OTPStore keeps the hash of one-time passwords and how many times they were tried
+type OTPStore interface {
+	// Add stores a code hash until expiresAt, replacing the previous code of key
+	Add(key string, codeHash string, expiresAt int64) error
+	// Attempt counts an attempt to use the code of key, returning its hash
+	// and the attempts so far or ErrOTPNotFound
+	Attempt(key string) (codeHash string, attempts int64, err error)
+	// Remove discards the code of key
+	Remove(key string) error
+}
+
+// newOTPCode returns a random code with otpDigits decimal digits
+func newOTPCode() (string, error) {
+	var max uint32 = 1
+	for i := 0; i < otpDigits; i++ {
+		max *= 10
+	}
+	// rejecting the values above the last multiple of max avoids bias
+	limit := (1<<32 - 1) / max * max
+	var b [4]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return "", err
+		}
+		if n := binary.BigEndian.Uint32(b[:]); n < limit {
+			return fmt.Sprintf("%0*d", otpDigits, n%max), nil
+		}
+	}
+}
+
+func otpKey(purpose string, userID int64, subject string) string {
+	return purpose + ":" + strconv.FormatInt(userID, 10) + ":" + subject
+}
+
+// hashOTP ties the code to its key, so a stored hash is useless for other keys
+func (a *Notary) hashOTP(key string, code string) string {
+	mac := hmac.New(sha256.New, a.verificationSecret)
+	mac.Write([]byte(key))
+	mac.Write([]byte{0})
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// countAttempt counts an attempt on the counter of key, kept like a code without
+// hash for window since its first attempt, returning the attempts so far
+func (a *Notary) countAttempt(key string, window time.Duration) (int64, error) {
+	_, attempts, err := a.otpStore.Attempt(key)
+	if err == ErrOTPNotFound {
+		if err = a.otpStore.Add(key, "", time.Now().Add(window).Unix()); err == nil {
+			_, attempts, err = a.otpStore.Attempt(key)
+		}
+	}
+	return attempts, err
+}
+
+func (a *Notary) newOTP(key string, ttl time.Duration) (string, error) {
+	sent, err := a.countAttempt(otpSent+key, otpResendCooldown)
+	if err != nil {
+		return "", err
+	}
+	if sent > 1 {
+		return "", ErrOTPResendTooSoon
+	}
+
+	code, err := newOTPCode()
+	if err != nil {
+		return "", err
+	}
+	if err = a.otpStore.Add(key, a.hashOTP(key, code), time.Now().Add(ttl).Unix()); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+func (a *Notary) verifyOTP(key string, code string) error {
+	codeHash, attempts, err := a.otpStore.Attempt(key)
+	if err != nil {
+		return err
+	}
+	if attempts > a.otpMaxAttempts {
+		a.otpStore.Remove(key)
+		return ErrOTPAttemptsExceeded
+	}
+	// the attempts on the previous codes of key count as well
+	if attempts, err = a.countAttempt(otpAttempts+key, otpAttemptsWindow); err != nil {
+		return err
+	}
+	if attempts > a.otpMaxAttempts {
+		return ErrOTPAttemptsExceeded
+	}
+	if !hmac.Equal([]byte(codeHash), []byte(a.hashOTP(key, code))) {
+		return ErrInvalidOTP
+	}
+	a.otpStore.Remove(otpAttempts + key)
+	return a.otpStore.Remove(key)
+}
+
+// NewPhoneOTP issues the code sent by SMS proving that userID owns the phone,
+// it replaces any previous code of the phone. ErrOTPResendTooSoon is returned
+// within otpResendCooldown of the previous code.
+func (a *Notary) NewPhoneOTP(userID int64, phone string) (string, error) {
+	return a.newOTP(otpKey(phoneVerification, userID, phone), a.phoneOTPTTL)
+}
+
+// VerifyPhoneOTP checks the code typed by the user, a code is accepted only once
+// and it's discarded after the configured amount of attempts
+func (a *Notary) VerifyPhoneOTP(userID int64, phone string, code string) error {
+	return a.verifyOTP(otpKey(phoneVerification, userID, phone), code)
+}
@@ -0,0 +1,102 @@
+package security
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhoneOTP(t *testing.T) {
+	var userID int64 = 233
+	phone := "5584999990000"
+
+	t.Run("Code", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			code, err := newOTPCode()
+			if err != nil {
+				t.Fatalf("err should be nil instead of %q", err)
+			}
+			if len(code) != otpDigits {
+				t.Fatalf("code %q should have %d digits", code, otpDigits)
+			}
+		}
+	})
+
+	t.Run("SingleUse", func(t *testing.T) {
+		notary := newTestNotary(t)
+		code, err := notary.NewPhoneOTP(userID, phone)
+		if err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+
+		if err = notary.VerifyPhoneOTP(userID, "5584999991111", code); err != ErrOTPNotFound {
+			t.Errorf("err should be %q instead of %v", ErrOTPNotFound, err)
+		}
+		if err = notary.VerifyPhoneOTP(userID, phone, code); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if err = notary.VerifyPhoneOTP(userID, phone, code); err != ErrOTPNotFound {
+			t.Errorf("err should be %q instead of %v", ErrOTPNotFound, err)
+		}
+	})
+
+	t.Run("Attempts", func(t *testing.T) {
+		notary := newTestNotary(t)
+		code, _ := notary.NewPhoneOTP(userID, phone)
+		wrong := "000000"
+		if code == wrong {
+			wrong = "111111"
+		}
+
+		for i := int64(0); i < notary.otpMaxAttempts; i++ {
+			if err := notary.VerifyPhoneOTP(userID, phone, wrong); err != ErrInvalidOTP {
+				t.Fatalf("err should be %q instead of %v", ErrInvalidOTP, err)
+			}
+		}
+		if err := notary.VerifyPhoneOTP(userID, phone, code); err != ErrOTPAttemptsExceeded {
+			t.Errorf("err should be %q instead of %v", ErrOTPAttemptsExceeded, err)
+		}
+		if err := notary.VerifyPhoneOTP(userID, phone, code); err != ErrOTPNotFound {
+			t.Errorf("err should be %q instead of %v", ErrOTPNotFound, err)
+		}
+	})
+
+	t.Run("ResendTooSoon", func(t *testing.T) {
+		notary := newTestNotary(t)
+		notary.NewPhoneOTP(userID, phone)
+		if _, err := notary.NewPhoneOTP(userID, phone); err != ErrOTPResendTooSoon {
+			t.Errorf("err should be %q instead of %v", ErrOTPResendTooSoon, err)
+		}
+	})
+
+	t.Run("AttemptsSurviveNewCode", func(t *testing.T) {
+		notary := newTestNotary(t)
+		key := otpKey(phoneVerification, userID, phone)
+		code, _ := notary.NewPhoneOTP(userID, phone)
+		wrong := "000000"
+		if code == wrong {
+			wrong = "111111"
+		}
+		for i := int64(0); i < notary.otpMaxAttempts; i++ {
+			notary.VerifyPhoneOTP(userID, phone, wrong)
+		}
+
+		// as if the cooldown had passed
+		notary.otpStore.Remove(otpSent + key)
+		code, err := notary.NewPhoneOTP(userID, phone)
+		if err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+		if err = notary.VerifyPhoneOTP(userID, phone, code); err != ErrOTPAttemptsExceeded {
+			t.Errorf("err should be %q instead of %v", ErrOTPAttemptsExceeded, err)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		notary := newTestNotary(t)
+		notary.phoneOTPTTL = -time.Minute
+		code, _ := notary.NewPhoneOTP(userID, phone)
+		if err := notary.VerifyPhoneOTP(userID, phone, code); err != ErrOTPNotFound {
+			t.Errorf("err should be %q instead of %v", ErrOTPNotFound, err)
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package security
+
+// passwordReset is the purpose of the links and codes allowing a user to set a new password
+const passwordReset = "password_reset"
+
+// NewPasswordResetToken issues the token of the link sent by email allowing userID
+// to set a new password, it expires on the configured TTL
+func (a *Notary) NewPasswordResetToken(userID int64) (string, error) {
+	return a.newVerificationToken(passwordReset, userID, "", a.passwordResetTTL)
+}
+
+// RedeemPasswordResetToken returns the user allowed to set a new password by
+// the token, the token can't be redeemed again
+func (a *Notary) RedeemPasswordResetToken(token string) (*Verification, error) {
+	return a.redeemVerificationToken(passwordReset, token)
+}
+
+// NewPasswordResetOTP issues the code sent by SMS allowing userID to set a new password,
+// with the same resend cooldown of NewPhoneOTP
+func (a *Notary) NewPasswordResetOTP(userID int64, phone string) (string, error) {
+	return a.newOTP(otpKey(passwordReset, userID, phone), a.phoneOTPTTL)
+}
+
+// VerifyPasswordResetOTP checks the code sent by SMS to reset the password,
+// with the same limits of VerifyPhoneOTP
+func (a *Notary) VerifyPasswordResetOTP(userID int64, phone string, code string) error {
+	return a.verifyOTP(otpKey(passwordReset, userID, phone), code)
+}
@@ -0,0 +1,30 @@
+package security
+
+import "testing"
+
+func TestPasswordResetToken(t *testing.T) {
+	notary := newTestNotary(t)
+	token, err := notary.NewPasswordResetToken(233)
+	if err != nil {
+		t.Fatalf("err should be nil instead of %q", err)
+	}
+
+	if _, err = notary.RedeemEmailVerificationToken(token); err != ErrInvalidVerificationToken {
+		t.Errorf("a password reset token should not verify emails, err %v", err)
+	}
+
+	v, err := notary.RedeemPasswordResetToken(token)
+	if err != nil {
+		t.Fatalf("err should be nil instead of %q", err)
+	}
+	if v.UserID != 233 {
+		t.Errorf("user 233 should be returned instead of %d", v.UserID)
+	}
+
+	if _, err = notary.RedeemPasswordResetToken(token); err != ErrInvalidVerificationToken {
+		t.Errorf("err should be %q instead of %v", ErrInvalidVerificationToken, err)
+	}
+	if _, err = notary.RedeemPasswordResetToken(respell(token)); err != ErrInvalidVerificationToken {
+		t.Errorf("a respelled token should not be redeemed again, err %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package security
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"regexp"
+)
+
+// PKCE code challenge methods as defined in https://tools.ietf.org/html/rfc7636#section-4.2
+const (
+	CodeChallengePlain = "plain"
+	CodeChallengeS256  = "S256"
+)
+
+// ErrInvalidCodeChallenge is returned when a code challenge or a code verifier is malformed
+var ErrInvalidCodeChallenge = errors.New("invalid code challenge")
+
+// codeVerifierRegexp matches both code verifiers and code challenges,
+// S256 challenges are 43 characters of unpadded base64url
+var codeVerifierRegexp = regexp.MustCompile(`\A[A-Za-z0-9\-._~]{43,128}\z`)
+
+// CodeChallenge is a PKCE code challenge bound to an authorization code
+type CodeChallenge struct {
+	Method    string
+	Challenge string
+}
+
+// NewCodeChallenge validates the code_challenge and code_challenge_method authorization
+// parameters, the method defaults to plain when missing
+func NewCodeChallenge(challenge string, method string) (*CodeChallenge, error) {
+	if method == "" {
+		method = CodeChallengePlain
+	}
+	if method != CodeChallengePlain && method != CodeChallengeS256 {
+		return nil, ErrInvalidCodeChallenge
+	}
+	if !codeVerifierRegexp.MatchString(challenge) {
+		return nil, ErrInvalidCodeChallenge
+	}
+	return &CodeChallenge{Method: method, Challenge: challenge}, nil
+}
+
+// Verify checks whether the code verifier sent on the token end point matches the challenge
+func (c *CodeChallenge) Verify(verifier string) bool {
+	if !codeVerifierRegexp.MatchString(verifier) {
+		return false
+	}
+	expected := verifier
+	if c.Method == CodeChallengeS256 {
+		hash := sha256.Sum256([]byte(verifier))
+		expected = base64.RawURLEncoding.EncodeToString(hash[:])
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(c.Challenge)) == 1
+}
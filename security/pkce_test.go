@@ -0,0 +1,82 @@
+package security
+
+import (
+	"testing"
+)
+
+// verifier and challenge from https://tools.ietf.org/html/rfc7636#appendix-B
+const (
+	testCodeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testCodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestCodeChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		method    string
+		verifier  string
+		expectErr bool
+		verified  bool
+	}{{
+		name:      "S256",
+		challenge: testCodeChallenge,
+		method:    CodeChallengeS256,
+		verifier:  testCodeVerifier,
+		verified:  true,
+	}, {
+		name:      "S256WrongVerifier",
+		challenge: testCodeChallenge,
+		method:    CodeChallengeS256,
+		verifier:  testCodeChallenge,
+		verified:  false,
+	}, {
+		name:      "Plain",
+		challenge: testCodeVerifier,
+		method:    CodeChallengePlain,
+		verifier:  testCodeVerifier,
+		verified:  true,
+	}, {
+		name:      "DefaultsToPlain",
+		challenge: testCodeVerifier,
+		verifier:  testCodeVerifier,
+		verified:  true,
+	}, {
+		name:      "EmptyVerifier",
+		challenge: testCodeVerifier,
+		method:    CodeChallengePlain,
+		verified:  false,
+	}, {
+		name:      "UnknownMethod",
+		challenge: testCodeChallenge,
+		method:    "S512",
+		expectErr: true,
+	}, {
+		name:      "ShortChallenge",
+		challenge: "abc",
+		method:    CodeChallengePlain,
+		expectErr: true,
+	}, {
+		name:      "MissingChallenge",
+		method:    CodeChallengeS256,
+		expectErr: true,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge, err := NewCodeChallenge(tt.challenge, tt.method)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("test %q: should err be returned", tt.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("test %q: should err be nil instead of %q", tt.name, err)
+			}
+			if challenge.Verify(tt.verifier) != tt.verified {
+				t.Errorf("test %q: verification should be %v", tt.name, tt.verified)
+			}
+		})
+	}
+}
@@ -1,8 +1,9 @@
 package security
 
 import (
-	"errors"
-	"io"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gabriel-araujjo/condominio-auth/domain"
 
@@ -10,71 +11,243 @@ import (
 	"github.com/gomodule/redigo/redis"
 )
 
+const (
+	tokenKeyPrefix    = "token:"
+	familyKeyPrefix   = "family:"
+	grantKeyPrefix    = "grant:"
+	userKeyPrefix     = "user:"
+	sessionsKeyPrefix = "sessions:"
+	codeKeyPrefix     = "code:"
+	bindingKeyPrefix  = "binding:"
+)
+
+// addToSetScript adds a token to a family or a grant set, extending
+// the set expiration when the token outlives it
+var addToSetScript = redis.NewScript(1, `
+redis.call('SADD', KEYS[1], ARGV[1])
+if redis.call('TTL', KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call('EXPIRE', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// rotateScript marks an existing token as rotated, returning 1 only
+// on the first time it is called for a token
+var rotateScript = redis.NewScript(1, `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+return redis.call('HSETNX', KEYS[1], 'rotated', 1)
+`)
+
+// redeemCodeScript binds a code to a family only once, returning
+// the family bound before when there is one
+var redeemCodeScript = redis.NewScript(1, `
+local family = redis.call('GET', KEYS[1])
+if family then
+	return family
+end
+redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+return ''
+`)
+
+// exec runs the commands queued after MULTI, failing when any of them failed
+func exec(conn redis.Conn) error {
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(redis.Error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
 type redisTokenStore struct {
 	pool *redis.Pool
 }
 
-func (b *redisTokenStore) Add(token string, expiresAt int64, userID int64, scope domain.Scope) error {
+func (b *redisTokenStore) Add(token string, t *Token) error {
 	conn := b.pool.Get()
 	defer conn.Close()
-	args := make([]interface{}, len(scope)+2)
-	args[0] = token
-	args[1] = userID
-	for i := range scope {
-		args[i+1] = scope[i]
+	key := tokenKeyPrefix + token
+	conn.Send("MULTI")
+	conn.Send("HMSET", key,
+		"kind", string(t.Kind),
+		"user", t.UserID,
+		"client", t.ClientID,
+		"scope", strings.Join(t.Scope, " "),
+		"family", t.Family,
+		"iat", t.IssuedAt,
+		"exp", t.ExpiresAt)
+	conn.Send("EXPIREAT", key, t.ExpiresAt)
+	if t.Family != "" {
+		addToSetScript.Send(conn, familyKeyPrefix+t.Family, token, t.ExpiresAt-time.Now().Unix())
 	}
-	conn.Send("SET", args...)
-	conn.Send("EXPIREAT", token, expiresAt)
-	return conn.Flush()
+	if t.UserID != 0 {
+		addToSetScript.Send(conn, grantKey(t.UserID, t.ClientID), token, t.ExpiresAt-time.Now().Unix())
+		addToSetScript.Send(conn, userKeyPrefix+strconv.FormatInt(t.UserID, 10), token, t.ExpiresAt-time.Now().Unix())
+	}
+	// a token missing from its family or user sets couldn't be revoked, so it's all or nothing
+	return exec(conn)
 }
 
-func (b *redisTokenStore) Get(token string) (userID int64, scopes domain.Scope, err error) {
+func (b *redisTokenStore) Get(token string) (*Token, error) {
 	conn := b.pool.Get()
 	defer conn.Close()
-	result, err := conn.Do("SMEMBERS", token)
+	fields, err := redis.StringMap(conn.Do("HGETALL", tokenKeyPrefix+token))
 	if err != nil {
-		return
-	}
-	setMembers, isArray := result.([]interface{})
-	if !isArray {
-		err = errors.New("unexpected type")
-		return
-	}
-
-	scopes = make([]string, len(setMembers)-1)
-	var isString bool
-	userID = setMembers[0].(int64)
-	for i := range setMembers[1:] {
-		scopes[i], isString = setMembers[i].(string)
-		if !isString {
-			err = errors.New("unexpected type")
-			return
-		}
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrTokenNotFound
+	}
+
+	t := &Token{
+		Kind:     TokenKind(fields["kind"]),
+		ClientID: fields["client"],
+		Scope:    domain.Scope(strings.Fields(fields["scope"])),
+		Family:   fields["family"],
+		Rotated:  fields["rotated"] == "1",
+	}
+	if t.UserID, err = strconv.ParseInt(fields["user"], 10, 64); err != nil {
+		return nil, err
 	}
-	return
+	if t.IssuedAt, err = strconv.ParseInt(fields["iat"], 10, 64); err != nil {
+		return nil, err
+	}
+	if t.ExpiresAt, err = strconv.ParseInt(fields["exp"], 10, 64); err != nil {
+		return nil, err
+	}
+	return t, nil
 }
 
 func (b *redisTokenStore) Contains(token string) (bool, error) {
 	conn := b.pool.Get()
 	defer conn.Close()
-	reply, err := conn.Do("EXISTS", token)
-	exists, _ := reply.(string)
-	return exists == "1", err
+	return redis.Bool(conn.Do("EXISTS", tokenKeyPrefix+token))
 }
 
 func (b *redisTokenStore) Remove(token string) error {
 	conn := b.pool.Get()
 	defer conn.Close()
-	_, err := conn.Do("DEL", token)
+	_, err := conn.Do("DEL", tokenKeyPrefix+token)
+	return err
+}
+
+func (b *redisTokenStore) Rotate(token string) (bool, error) {
+	conn := b.pool.Get()
+	defer conn.Close()
+	reply, err := redis.Int(rotateScript.Do(conn, tokenKeyPrefix+token))
+	if err != nil {
+		return false, err
+	}
+	if reply < 0 {
+		return false, ErrTokenNotFound
+	}
+	return reply == 1, nil
+}
+
+// grantKey is the set of tokens issued to a client on behalf of a user
+func grantKey(userID int64, clientID string) string {
+	return grantKeyPrefix + strconv.FormatInt(userID, 10) + ":" + clientID
+}
+
+func (b *redisTokenStore) RemoveFamily(family string) error {
+	return b.removeSet(familyKeyPrefix + family)
+}
+
+func (b *redisTokenStore) RemoveByUserAndClient(userID int64, clientID string) error {
+	return b.removeSet(grantKey(userID, clientID))
+}
+
+func (b *redisTokenStore) RemoveByUser(userID int64) error {
+	return b.removeSet(userKeyPrefix + strconv.FormatInt(userID, 10))
+}
+
+func (b *redisTokenStore) RevokeSessions(userID int64, revokedAt int64, expiresAt int64) error {
+	conn := b.pool.Get()
+	defer conn.Close()
+	key := sessionsKeyPrefix + strconv.FormatInt(userID, 10)
+	conn.Send("MULTI")
+	conn.Send("SET", key, revokedAt)
+	conn.Send("EXPIREAT", key, expiresAt)
+	return exec(conn)
+}
+
+func (b *redisTokenStore) SessionsRevokedAt(userID int64) (int64, error) {
+	conn := b.pool.Get()
+	defer conn.Close()
+	revokedAt, err := redis.Int64(conn.Do("GET", sessionsKeyPrefix+strconv.FormatInt(userID, 10)))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return revokedAt, err
+}
+
+// removeSet removes a set of tokens and every token on it
+func (b *redisTokenStore) removeSet(key string) error {
+	conn := b.pool.Get()
+	defer conn.Close()
+	tokens, err := redis.Strings(conn.Do("SMEMBERS", key))
+	if err != nil {
+		return err
+	}
+	keys := make([]interface{}, 0, len(tokens)+1)
+	keys = append(keys, key)
+	for _, token := range tokens {
+		keys = append(keys, tokenKeyPrefix+token)
+	}
+	_, err = conn.Do("DEL", keys...)
+	return err
+}
+
+func (b *redisTokenStore) RedeemCode(codeHash string, family string, expiresAt int64) (string, error) {
+	conn := b.pool.Get()
+	defer conn.Close()
+	ttl := expiresAt - time.Now().Unix()
+	if ttl < 1 {
+		ttl = 1
+	}
+	return redis.String(redeemCodeScript.Do(conn, codeKeyPrefix+codeHash, family, ttl))
+}
+
+func (b *redisTokenStore) AddCodeBinding(codeHash string, binding *CodeBinding, expiresAt int64) error {
+	conn := b.pool.Get()
+	defer conn.Close()
+	key := bindingKeyPrefix + codeHash
+	args := redis.Args{key, "redirect_uri", binding.RedirectURI, "nonce", binding.Nonce}
+	if binding.Challenge != nil {
+		args = args.Add("method", binding.Challenge.Method, "challenge", binding.Challenge.Challenge)
+	}
+	conn.Send("MULTI")
+	conn.Send("HMSET", args...)
+	conn.Send("EXPIREAT", key, expiresAt)
+	_, err := conn.Do("EXEC")
 	return err
 }
 
-func newRedisTokenStore(config *config.Config) (TokenStore, io.Closer, error) {
-	pool := &redis.Pool{
+func (b *redisTokenStore) GetCodeBinding(codeHash string) (*CodeBinding, error) {
+	conn := b.pool.Get()
+	defer conn.Close()
+	fields, err := redis.StringMap(conn.Do("HGETALL", bindingKeyPrefix+codeHash))
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+	binding := &CodeBinding{RedirectURI: fields["redirect_uri"], Nonce: fields["nonce"]}
+	if fields["challenge"] != "" {
+		binding.Challenge = &CodeChallenge{Method: fields["method"], Challenge: fields["challenge"]}
+	}
+	return binding, nil
+}
+
+func newRedisPool(config *config.Config) *redis.Pool {
+	return &redis.Pool{
 		Dial: func() (redis.Conn, error) {
 			return redis.DialURL(config.Notary.TokenStoreURI)
 		},
 		MaxIdle: 10,
 	}
-	return &redisTokenStore{pool: pool}, pool, nil
 }
@@ -0,0 +1,182 @@
+package security
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabriel-araujjo/condominio-auth/domain"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	deviceKeyPrefix   = "device:"
+	userCodeKeyPrefix = "usercode:"
+)
+
+// decideScript sets the status of a device authorization only when it's
+// pending, returning -1 when the authorization doesn't exist
+var decideScript = redis.NewScript(1, `
+local status = redis.call('HGET', KEYS[1], 'status')
+if not status then
+	return -1
+end
+if status ~= 'pending' then
+	return 0
+end
+redis.call('HMSET', KEYS[1], 'status', ARGV[1], 'user', ARGV[2])
+return 1
+`)
+
+// pollScript records the time of a poll, returning the previous one
+var pollScript = redis.NewScript(1, `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+local previous = redis.call('HGET', KEYS[1], 'polled')
+redis.call('HSET', KEYS[1], 'polled', ARGV[1])
+return tonumber(previous) or 0
+`)
+
+// slowDownScript increases the polling interval of an existing device authorization
+var slowDownScript = redis.NewScript(1, `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+return redis.call('HINCRBY', KEYS[1], 'interval', ARGV[1])
+`)
+
+type redisDeviceStore struct {
+	pool *redis.Pool
+}
+
+func (s *redisDeviceStore) Add(deviceCode string, d *DeviceAuthorization) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	ttl := d.ExpiresAt - time.Now().Unix()
+	if ttl < 1 {
+		ttl = 1
+	}
+	// claiming the user code first keeps two authorizations from sharing it
+	reply, err := conn.Do("SET", userCodeKeyPrefix+d.UserCode, deviceCode, "EX", ttl, "NX")
+	if err != nil {
+		return err
+	}
+	if reply == nil {
+		return errUserCodeTaken
+	}
+
+	key := deviceKeyPrefix + deviceCode
+	conn.Send("MULTI")
+	conn.Send("HMSET", key,
+		"user_code", d.UserCode,
+		"client", d.ClientID,
+		"scope", strings.Join(d.Scope, " "),
+		"status", string(d.Status),
+		"user", d.UserID,
+		"interval", d.Interval,
+		"exp", d.ExpiresAt)
+	conn.Send("EXPIREAT", key, d.ExpiresAt)
+	if _, err = conn.Do("EXEC"); err != nil {
+		conn.Do("DEL", userCodeKeyPrefix+d.UserCode)
+		return err
+	}
+	return nil
+}
+
+func (s *redisDeviceStore) Get(deviceCode string) (*DeviceAuthorization, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	fields, err := redis.StringMap(conn.Do("HGETALL", deviceKeyPrefix+deviceCode))
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrDeviceCodeNotFound
+	}
+
+	d := &DeviceAuthorization{
+		UserCode: fields["user_code"],
+		ClientID: fields["client"],
+		Scope:    domain.Scope(strings.Fields(fields["scope"])),
+		Status:   DeviceStatus(fields["status"]),
+	}
+	if d.UserID, err = strconv.ParseInt(fields["user"], 10, 64); err != nil {
+		return nil, err
+	}
+	if d.Interval, err = strconv.ParseInt(fields["interval"], 10, 64); err != nil {
+		return nil, err
+	}
+	if d.ExpiresAt, err = strconv.ParseInt(fields["exp"], 10, 64); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (s *redisDeviceStore) DeviceCode(userCode string) (string, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	deviceCode, err := redis.String(conn.Do("GET", userCodeKeyPrefix+userCode))
+	if err == redis.ErrNil {
+		return "", ErrDeviceCodeNotFound
+	}
+	return deviceCode, err
+}
+
+func (s *redisDeviceStore) Decide(deviceCode string, status DeviceStatus, userID int64) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	reply, err := redis.Int(decideScript.Do(conn, deviceKeyPrefix+deviceCode, string(status), userID))
+	if err != nil {
+		return false, err
+	}
+	if reply < 0 {
+		return false, ErrDeviceCodeNotFound
+	}
+	return reply == 1, nil
+}
+
+func (s *redisDeviceStore) Poll(deviceCode string, polledAt int64) (int64, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	previous, err := redis.Int64(pollScript.Do(conn, deviceKeyPrefix+deviceCode, polledAt))
+	if err != nil {
+		return 0, err
+	}
+	if previous < 0 {
+		return 0, ErrDeviceCodeNotFound
+	}
+	return previous, nil
+}
+
+func (s *redisDeviceStore) SlowDown(deviceCode string, seconds int64) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	reply, err := redis.Int64(slowDownScript.Do(conn, deviceKeyPrefix+deviceCode, seconds))
+	if err != nil {
+		return err
+	}
+	if reply < 0 {
+		return ErrDeviceCodeNotFound
+	}
+	return nil
+}
+
+func (s *redisDeviceStore) Remove(deviceCode string) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	userCode, err := redis.String(conn.Do("HGET", deviceKeyPrefix+deviceCode, "user_code"))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// only the caller deleting the device key may use the authorization
+	removed, err := redis.Int(conn.Do("DEL", deviceKeyPrefix+deviceCode))
+	if err != nil {
+		return false, err
+	}
+	conn.Do("DEL", userCodeKeyPrefix+userCode)
+	return removed == 1, nil
+}
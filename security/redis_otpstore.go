@@ -0,0 +1,59 @@
+package security
+
+import (
+	"github.com/gomodule/redigo/redis"
+)
+
+const otpKeyPrefix = "otp:"
+
+// attemptScript counts an attempt returning the code hash and the attempts so far,
+// or nil when there's no code
+var attemptScript = redis.NewScript(1, `
+local code = redis.call('HGET', KEYS[1], 'code')
+if not code then
+	return nil
+end
+local attempts = redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+return {code, attempts}
+`)
+
+type redisOTPStore struct {
+	pool *redis.Pool
+}
+
+func (s *redisOTPStore) Add(key string, codeHash string, expiresAt int64) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	conn.Send("MULTI")
+	conn.Send("DEL", otpKeyPrefix+key)
+	conn.Send("HMSET", otpKeyPrefix+key, "code", codeHash, "attempts", 0)
+	conn.Send("EXPIREAT", otpKeyPrefix+key, expiresAt)
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+func (s *redisOTPStore) Attempt(key string) (string, int64, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	reply, err := redis.Values(attemptScript.Do(conn, otpKeyPrefix+key))
+	if err == redis.ErrNil {
+		return "", 0, ErrOTPNotFound
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	var codeHash string
+	var attempts int64
+	if _, err = redis.Scan(reply, &codeHash, &attempts); err != nil {
+		return "", 0, err
+	}
+	return codeHash, attempts, nil
+}
+
+func (s *redisOTPStore) Remove(key string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", otpKeyPrefix+key)
+	return err
+}
@@ -0,0 +1,174 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters, the defaults of authenticator apps
+// https://tools.ietf.org/html/rfc6238
+const (
+	totpSecretSize = 20
+	totpPeriod     = 30
+	// totpSkew is how many periods a code is accepted before or after
+	// the current one, making up for clock drifts
+	totpSkew = 1
+)
+
+// totpLogin is the purpose of the TOTP codes already used to log in
+const totpLogin = "totp_login"
+
+// secondFactorAttempts is the purpose of the counters of the TOTP and recovery
+// codes tried by a user, they're counted on secondFactorWindow
+const secondFactorAttempts = "second_factor_attempts"
+
+const secondFactorWindow = 15 * time.Minute
+
+// recoveryCodeSize is how many random bytes a recovery code has
+const recoveryCodeSize = 5
+
+// base32Encoding encodes the TOTP secrets and the recovery codes
+var base32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random secret to be shared with an authenticator app
+func NewTOTPSecret() ([]byte, error) {
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// EncodeTOTPSecret encodes a secret as typed on authenticator apps
+func EncodeTOTPSecret(secret []byte) string {
+	return base32Encoding.EncodeToString(secret)
+}
+
+// TOTPURI returns the otpauth URI of a secret, usually shown as a QR code
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func TOTPURI(issuer string, account string, secret []byte) string {
+	query := url.Values{}
+	query.Set("secret", EncodeTOTPSecret(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(otpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// hotp computes the code of counter as defined on https://tools.ietf.org/html/rfc4226#section-5.3
+func hotp(secret []byte, counter uint64) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], counter)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0F
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7FFFFFFF
+
+	var max uint32 = 1
+	for i := 0; i < otpDigits; i++ {
+		max *= 10
+	}
+	return fmt.Sprintf("%0*d", otpDigits, value%max)
+}
+
+// totpCounter is the period of t
+func totpCounter(t time.Time) uint64 {
+	return uint64(t.Unix() / totpPeriod)
+}
+
+// VerifyTOTP checks a code generated from the TOTP secret of the user, each
+// code is accepted only once
+func (a *Notary) VerifyTOTP(userID int64, secret []byte, code string) error {
+	return a.verifyTOTP(userID, secret, code, time.Now())
+}
+
+func (a *Notary) verifyTOTP(userID int64, secret []byte, code string, now time.Time) error {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != otpDigits {
+		return ErrInvalidOTP
+	}
+
+	current := totpCounter(now)
+	for skew := -totpSkew; skew <= totpSkew; skew++ {
+		counter := current + uint64(skew)
+		if subtle.ConstantTimeCompare([]byte(hotp(secret, counter)), []byte(code)) != 1 {
+			continue
+		}
+
+		// used codes are redeemed like authorization codes, a code is accepted
+		// on 2*totpSkew+1 periods at most
+		used := fmt.Sprintf("%s:%d:%d", totpLogin, userID, counter)
+		expiresAt := time.Now().Add((2*totpSkew + 1) * totpPeriod * time.Second).Unix()
+		previous, err := a.tokenStore.RedeemCode(hashCode(used), totpLogin, expiresAt)
+		if err != nil {
+			return err
+		}
+		if previous != "" {
+			return ErrInvalidOTP
+		}
+		return nil
+	}
+	return ErrInvalidOTP
+}
+
+// AttemptSecondFactor counts an attempt of userID to send a TOTP or a recovery
+// code. ErrOTPAttemptsExceeded is returned once the configured amount of attempts
+// is exceeded, until secondFactorWindow passes since the first one.
+func (a *Notary) AttemptSecondFactor(userID int64) error {
+	attempts, err := a.countAttempt(otpKey(secondFactorAttempts, userID, ""), secondFactorWindow)
+	if err != nil {
+		return err
+	}
+	if attempts > a.otpMaxAttempts {
+		return ErrOTPAttemptsExceeded
+	}
+	return nil
+}
+
+// ResetSecondFactorAttempts discards the attempts of userID after a valid code
+func (a *Notary) ResetSecondFactorAttempts(userID int64) error {
+	return a.otpStore.Remove(otpKey(secondFactorAttempts, userID, ""))
+}
+
+// NewRecoveryCodes returns n codes logging in without the TOTP code, they
+// should be shown once to the user and only their hashes kept
+func NewRecoveryCodes(n int) (codes []string, hashes [][]byte, err error) {
+	for i := 0; i < n; i++ {
+		raw := make([]byte, recoveryCodeSize)
+		if _, err = rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		encoded := strings.ToLower(base32Encoding.EncodeToString(raw))
+		code := encoded[:len(encoded)/2] + "-" + encoded[len(encoded)/2:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code as typed by the user, ignoring
+// case, spaces and dashes. The codes are random enough to not need a salt.
+func HashRecoveryCode(code string) []byte {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+	hash := sha256.Sum256([]byte(normalized))
+	return hash[:]
+}
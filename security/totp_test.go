@@ -0,0 +1,112 @@
+package security
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHOTP(t *testing.T) {
+	// test vectors of https://tools.ietf.org/html/rfc6238#appendix-B truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, code := range cases {
+		if got := hotp(secret, totpCounter(time.Unix(unix, 0))); got != code {
+			t.Errorf("code at %d should be %q instead of %q", unix, code, got)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111109, 0)
+
+	t.Run("SingleUse", func(t *testing.T) {
+		notary := newTestNotary(t)
+		if err := notary.verifyTOTP(42, secret, "081 804", now); err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+		if err := notary.verifyTOTP(42, secret, "081804", now); err != ErrInvalidOTP {
+			t.Errorf("err should be %q instead of %v", ErrInvalidOTP, err)
+		}
+		// other users may get the same code
+		if err := notary.verifyTOTP(43, secret, "081804", now); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+	})
+
+	t.Run("Skew", func(t *testing.T) {
+		notary := newTestNotary(t)
+		if err := notary.verifyTOTP(42, secret, "081804", now.Add(totpPeriod*time.Second)); err != nil {
+			t.Errorf("err should be nil instead of %q", err)
+		}
+		if err := notary.verifyTOTP(43, secret, "081804", now.Add(2*totpPeriod*time.Second)); err != ErrInvalidOTP {
+			t.Errorf("err should be %q instead of %v", ErrInvalidOTP, err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		notary := newTestNotary(t)
+		for _, code := range []string{"", "08180", "081805", "0818044"} {
+			if err := notary.verifyTOTP(42, secret, code, now); err != ErrInvalidOTP {
+				t.Errorf("code %q: err should be %q instead of %v", code, ErrInvalidOTP, err)
+			}
+		}
+	})
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Condominio", "fulano@email.com", []byte("12345678901234567890"))
+	expected := "otpauth://totp/Condominio:fulano@email.com?algorithm=SHA1&digits=6&issuer=Condominio&period=30&" +
+		"secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	if uri != expected {
+		t.Errorf("uri should be %q instead of %q", expected, uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("err should be nil instead of %q", err)
+	}
+	if len(codes) != 10 || len(hashes) != 10 {
+		t.Fatalf("10 codes should be returned instead of %d", len(codes))
+	}
+	for i, code := range codes {
+		typed := strings.ToUpper(strings.Replace(code, "-", " ", -1))
+		if !bytes.Equal(HashRecoveryCode(typed), hashes[i]) {
+			t.Errorf("%q should match the hash of %q", typed, code)
+		}
+		for _, other := range codes[i+1:] {
+			if other == code {
+				t.Errorf("code %q is duplicated", code)
+			}
+		}
+	}
+}
+
+func TestSecondFactorAttempts(t *testing.T) {
+	notary := newTestNotary(t)
+	for i := int64(0); i < notary.otpMaxAttempts; i++ {
+		if err := notary.AttemptSecondFactor(42); err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+	}
+	if err := notary.AttemptSecondFactor(42); err != ErrOTPAttemptsExceeded {
+		t.Errorf("err should be %q instead of %v", ErrOTPAttemptsExceeded, err)
+	}
+	if err := notary.AttemptSecondFactor(43); err != nil {
+		t.Errorf("the attempts of other users should not count, err %v", err)
+	}
+
+	notary.ResetSecondFactorAttempts(42)
+	if err := notary.AttemptSecondFactor(42); err != nil {
+		t.Errorf("err should be nil after a reset instead of %q", err)
+	}
+}
@@ -0,0 +1,108 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInvalidVerificationToken is returned when a verification token is malformed,
+// expired, already used or issued for another purpose
+var ErrInvalidVerificationToken = errors.New("invalid verification token")
+
+// emailVerification is the purpose of the tokens proving the ownership of an email
+const emailVerification = "email_verification"
+
+// verificationHeaderSize is the size of the user id, the expiration
+// and the nonce preceding the subject on a verification token
+const verificationHeaderSize = 32
+
+// verificationEncoding encodes the parts of the verification tokens, rejecting
+// the non canonical encodings of the same bytes
+var verificationEncoding = base64.RawURLEncoding.Strict()
+
+// Verification is what a verification token proves about a user
+type Verification struct {
+	UserID int64
+	// Subject is the verified email, phone or identifier
+	Subject   string
+	ExpiresAt int64
+}
+
+func (a *Notary) signVerification(purpose string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, a.verificationSecret)
+	mac.Write([]byte(purpose))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// newVerificationToken signs the user id, the subject and the expiration of a token,
+// there's no need to store the tokens until they are redeemed
+func (a *Notary) newVerificationToken(purpose string, userID int64, subject string, ttl time.Duration) (string, error) {
+	payload := make([]byte, verificationHeaderSize, verificationHeaderSize+len(subject))
+	binary.BigEndian.PutUint64(payload[0:8], uint64(userID))
+	binary.BigEndian.PutUint64(payload[8:16], uint64(time.Now().Add(ttl).Unix()))
+	if _, err := rand.Read(payload[16:verificationHeaderSize]); err != nil {
+		return "", err
+	}
+	payload = append(payload, subject...)
+
+	return verificationEncoding.EncodeToString(payload) + "." +
+		verificationEncoding.EncodeToString(a.signVerification(purpose, payload)), nil
+}
+
+// redeemVerificationToken checks the signature and the expiration of a token
+// making sure it's redeemed only once
+func (a *Notary) redeemVerificationToken(purpose string, token string) (*Verification, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidVerificationToken
+	}
+	// the lenient decoding accepts several spellings of the last character,
+	// which would redeem the same token more than once
+	payload, err := verificationEncoding.DecodeString(parts[0])
+	if err != nil || len(payload) < verificationHeaderSize {
+		return nil, ErrInvalidVerificationToken
+	}
+	signature, err := verificationEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, a.signVerification(purpose, payload)) {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	v := &Verification{
+		UserID:    int64(binary.BigEndian.Uint64(payload[0:8])),
+		ExpiresAt: int64(binary.BigEndian.Uint64(payload[8:16])),
+		Subject:   string(payload[verificationHeaderSize:]),
+	}
+	if time.Now().Unix() >= v.ExpiresAt {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	// verification tokens are redeemed like authorization codes
+	previous, err := a.tokenStore.RedeemCode(hashCode(string(payload)+string(signature)), purpose, v.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if previous != "" {
+		return nil, ErrInvalidVerificationToken
+	}
+	return v, nil
+}
+
+// NewEmailVerificationToken issues a token proving that userID owns the email,
+// it's sent to the email address and expires on the configured TTL
+func (a *Notary) NewEmailVerificationToken(userID int64, email string) (string, error) {
+	return a.newVerificationToken(emailVerification, userID, email, a.emailVerificationTTL)
+}
+
+// RedeemEmailVerificationToken returns the user and the email proven by a token,
+// the token can't be redeemed again
+func (a *Notary) RedeemEmailVerificationToken(token string) (*Verification, error) {
+	return a.redeemVerificationToken(emailVerification, token)
+}
@@ -0,0 +1,61 @@
+package security
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailVerificationToken(t *testing.T) {
+	t.Run("SingleUse", func(t *testing.T) {
+		notary := newTestNotary(t)
+		token, err := notary.NewEmailVerificationToken(42, "fulano@email.com")
+		if err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+
+		v, err := notary.RedeemEmailVerificationToken(token)
+		if err != nil {
+			t.Fatalf("err should be nil instead of %q", err)
+		}
+		if v.UserID != 42 || v.Subject != "fulano@email.com" {
+			t.Errorf("unexpected verification %+v", v)
+		}
+
+		if _, err = notary.RedeemEmailVerificationToken(token); err != ErrInvalidVerificationToken {
+			t.Errorf("err should be %q instead of %v", ErrInvalidVerificationToken, err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		notary := newTestNotary(t)
+		token, _ := notary.NewEmailVerificationToken(42, "fulano@email.com")
+		other, _ := notary.NewEmailVerificationToken(43, "fulano@email.com")
+		expired, _ := notary.newVerificationToken(emailVerification, 42, "fulano@email.com", -time.Minute)
+		otherPurpose, _ := notary.newVerificationToken("other", 42, "fulano@email.com", time.Hour)
+
+		cases := map[string]string{
+			"Empty":        "",
+			"Malformed":    "abc",
+			"Tampered":     strings.Split(other, ".")[0] + "." + strings.Split(token, ".")[1],
+			"Expired":      expired,
+			"OtherPurpose": otherPurpose,
+			"NonCanonical": respell(token),
+		}
+		for name, token := range cases {
+			t.Run(name, func(t *testing.T) {
+				if _, err := notary.RedeemEmailVerificationToken(token); err != ErrInvalidVerificationToken {
+					t.Errorf("err should be %q instead of %v", ErrInvalidVerificationToken, err)
+				}
+			})
+		}
+	})
+}
+
+// respell changes the unused bits of the last character of a token, the
+// lenient base64 decoding still reads the same bytes
+func respell(token string) string {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	last := strings.IndexByte(alphabet, token[len(token)-1])
+	return token[:len(token)-1] + string(alphabet[last^1])
+}
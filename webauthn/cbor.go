@@ -0,0 +1,162 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// errInvalidCBOR is returned for data that isn't a well formed CBOR item
+var errInvalidCBOR = errors.New("webauthn: invalid cbor")
+
+// cborMaxDepth limits how deep arrays and maps nest, the structures of
+// WebAuthn never go beyond a few levels
+const cborMaxDepth = 8
+
+// CBOR major types https://tools.ietf.org/html/rfc7049#section-2.1
+const (
+	cborUnsigned = iota
+	cborNegative
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+// decodeCBOR decodes the first item of data and returns the bytes after it.
+// It covers the subset of CBOR the authenticators send: integers become
+// int64, byte strings []byte, text strings string, arrays []interface{} and
+// maps map[interface{}]interface{}, keyed by int64 or string. Tags are
+// skipped and indefinite lengths and floats are rejected.
+func decodeCBOR(data []byte) (value interface{}, rest []byte, err error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, errInvalidCBOR
+	}
+	major, arg, data, err := decodeCBORHead(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case cborUnsigned:
+		if arg > math.MaxInt64 {
+			return nil, nil, errInvalidCBOR
+		}
+		return int64(arg), data, nil
+	case cborNegative:
+		if arg > math.MaxInt64 {
+			return nil, nil, errInvalidCBOR
+		}
+		return -1 - int64(arg), data, nil
+	case cborBytes, cborText:
+		if arg > uint64(len(data)) {
+			return nil, nil, errInvalidCBOR
+		}
+		if major == cborText {
+			return string(data[:arg]), data[arg:], nil
+		}
+		return append([]byte(nil), data[:arg]...), data[arg:], nil
+	case cborArray:
+		// each item takes a byte at least
+		if arg > uint64(len(data)) {
+			return nil, nil, errInvalidCBOR
+		}
+		array := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			if item, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			array = append(array, item)
+		}
+		return array, data, nil
+	case cborMap:
+		if arg > uint64(len(data))/2 {
+			return nil, nil, errInvalidCBOR
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, item interface{}
+			if key, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errInvalidCBOR
+			}
+			if _, duplicated := m[key]; duplicated {
+				return nil, nil, errInvalidCBOR
+			}
+			if item, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[key] = item
+		}
+		return m, data, nil
+	case cborTag:
+		return decodeCBORItem(data, depth+1)
+	default:
+		switch arg {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		}
+		return nil, nil, errInvalidCBOR
+	}
+}
+
+// decodeCBORHead reads the major type and the argument of an item,
+// the argument is either its value, its length or its count of items
+func decodeCBORHead(data []byte) (major byte, arg uint64, rest []byte, err error) {
+	if len(data) == 0 {
+		return 0, 0, nil, errInvalidCBOR
+	}
+	major, info := data[0]>>5, data[0]&0x1F
+	data = data[1:]
+
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), data, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		// indefinite lengths and reserved values
+		return 0, 0, nil, errInvalidCBOR
+	}
+	if major == cborSimple && size > 1 {
+		// floats
+		return 0, 0, nil, errInvalidCBOR
+	}
+	if len(data) < size {
+		return 0, 0, nil, errInvalidCBOR
+	}
+
+	switch size {
+	case 1:
+		arg = uint64(data[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(data))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(data))
+	default:
+		arg = binary.BigEndian.Uint64(data)
+	}
+	return major, arg, data[size:], nil
+}
@@ -0,0 +1,89 @@
+package webauthn
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// examples from https://tools.ietf.org/html/rfc7049#appendix-A
+	cases := []struct {
+		name     string
+		hex      string
+		expected interface{}
+	}{
+		{"Zero", "00", int64(0)},
+		{"SmallInt", "17", int64(23)},
+		{"OneByteInt", "1818", int64(24)},
+		{"TwoBytesInt", "190100", int64(256)},
+		{"FourBytesInt", "1a000f4240", int64(1000000)},
+		{"EightBytesInt", "1b000000e8d4a51000", int64(1000000000000)},
+		{"Negative", "20", int64(-1)},
+		{"NegativeAlg", "390100", int64(-257)},
+		{"False", "f4", false},
+		{"True", "f5", true},
+		{"Null", "f6", nil},
+		{"Bytes", "4401020304", []byte{1, 2, 3, 4}},
+		{"Text", "6449455446", "IETF"},
+		{"Array", "83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"NestedArray", "8301820203820405", []interface{}{int64(1),
+			[]interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+		{"Map", "a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{"TextKeys", "a26161016162820203", map[interface{}]interface{}{"a": int64(1),
+			"b": []interface{}{int64(2), int64(3)}}},
+		{"Tag", "c11a514b67b0", int64(1363896240)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(c.hex)
+			value, rest, err := decodeCBOR(data)
+			if err != nil {
+				t.Fatalf("err should be nil instead of %q", err)
+			}
+			if len(rest) != 0 {
+				t.Errorf("no bytes should be left instead of %x", rest)
+			}
+			if !reflect.DeepEqual(value, c.expected) {
+				t.Errorf("value should be %#v instead of %#v", c.expected, value)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORRest(t *testing.T) {
+	data, _ := hex.DecodeString("0102")
+	value, rest, err := decodeCBOR(data)
+	if err != nil || value != int64(1) || len(rest) != 1 || rest[0] != 2 {
+		t.Errorf("the first item should be decoded instead of %v, %x (err %v)", value, rest, err)
+	}
+}
+
+func TestDecodeInvalidCBOR(t *testing.T) {
+	cases := []struct {
+		name string
+		hex  string
+	}{
+		{"Empty", ""},
+		{"TruncatedInt", "19"},
+		{"TruncatedBytes", "4401"},
+		{"TruncatedArray", "8301"},
+		{"IndefiniteArray", "9f01ff"},
+		{"Float", "f93c00"},
+		{"BytesKey", "a1410101"},
+		{"DuplicatedKey", "a201020103"},
+		{"HugeArray", "9b7fffffffffffffff"},
+		{"HugeInt", "1bffffffffffffffff"},
+		{"TooDeep", "8181818181818181818100"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(c.hex)
+			if _, _, err := decodeCBOR(data); err != errInvalidCBOR {
+				t.Errorf("err should be %q instead of %v", errInvalidCBOR, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+)
+
+// ErrUnsupportedKey is returned for credential keys of algorithms not supported
+var ErrUnsupportedKey = errors.New("webauthn: unsupported key")
+
+// COSE algorithms https://www.iana.org/assignments/cose/cose.xhtml#algorithms
+const (
+	// AlgES256 is ECDSA on P-256 with SHA-256
+	AlgES256 = -7
+	// AlgRS256 is RSASSA-PKCS1-v1_5 with SHA-256
+	AlgRS256 = -257
+)
+
+// SupportedAlgorithms are the algorithms of the credentials accepted on
+// registration, in order of preference
+var SupportedAlgorithms = []int64{AlgES256, AlgRS256}
+
+// COSE key parameters https://tools.ietf.org/html/rfc8152#section-7
+const (
+	coseKeyType   = 1
+	coseAlgorithm = 3
+
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseEC2Curve  = -1
+	coseEC2X      = -2
+	coseEC2Y      = -3
+	coseCurveP256 = 1
+
+	coseRSAModulus  = -1
+	coseRSAExponent = -2
+)
+
+// publicKey is a credential public key decoded from its COSE encoding
+type publicKey struct {
+	algorithm int64
+	key       crypto.PublicKey
+}
+
+// parseCOSEKey decodes the COSE key in the start of data, returning the
+// bytes after it
+func parseCOSEKey(data []byte) (*publicKey, []byte, error) {
+	value, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	params, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, nil, ErrUnsupportedKey
+	}
+
+	keyType, _ := params[int64(coseKeyType)].(int64)
+	algorithm, _ := params[int64(coseAlgorithm)].(int64)
+	switch {
+	case keyType == coseKeyTypeEC2 && algorithm == AlgES256:
+		curve, _ := params[int64(coseEC2Curve)].(int64)
+		x, _ := params[int64(coseEC2X)].([]byte)
+		y, _ := params[int64(coseEC2Y)].([]byte)
+		if curve != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, nil, ErrUnsupportedKey
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, nil, ErrUnsupportedKey
+		}
+		return &publicKey{algorithm, key}, rest, nil
+	case keyType == coseKeyTypeRSA && algorithm == AlgRS256:
+		n, _ := params[int64(coseRSAModulus)].([]byte)
+		e, _ := params[int64(coseRSAExponent)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, nil, ErrUnsupportedKey
+		}
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		if exponent < 3 {
+			return nil, nil, ErrUnsupportedKey
+		}
+		return &publicKey{algorithm, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, rest, nil
+	}
+	return nil, nil, ErrUnsupportedKey
+}
+
+// verify checks the signature of message
+func (k *publicKey) verify(message []byte, signature []byte) error {
+	digest := sha256.Sum256(message)
+
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		var sig struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(signature, &sig); err != nil || len(rest) > 0 {
+			return ErrInvalidSignature
+		}
+		if sig.R == nil || sig.S == nil || !ecdsa.Verify(key, digest[:], sig.R, sig.S) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+	return ErrUnsupportedKey
+}
@@ -0,0 +1,239 @@
+// Package webauthn verifies the ceremonies registering passkeys and logging
+// users in with them, as defined on https://www.w3.org/TR/webauthn/
+package webauthn
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrInvalidCredential is returned when the authenticator answer doesn't
+	// match the ceremony it should complete
+	ErrInvalidCredential = errors.New("webauthn: invalid credential")
+	// ErrInvalidSignature is returned when the assertion isn't signed by the credential
+	ErrInvalidSignature = errors.New("webauthn: invalid signature")
+)
+
+// Client data types of the ceremonies
+const (
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+)
+
+// Authenticator data flags https://www.w3.org/TR/webauthn/#flags
+const (
+	flagUserPresent       = 0x01
+	flagUserVerified      = 0x04
+	flagAttestedData      = 0x40
+	flagExtensionIncluded = 0x80
+)
+
+const (
+	// challengeSize is how many random bytes a challenge has
+	challengeSize = 32
+	// authDataMinSize is the size of the RP id hash, the flags and the sign count
+	authDataMinSize = 37
+	// maxCredentialIDSize is the limit set by the spec on credential ids
+	maxCredentialIDSize = 1023
+)
+
+// RelyingParty is this server as seen by the authenticators. The credentials
+// are scoped to ID, a domain, and ceremonies only run on the listed origins.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// NewRelyingParty creates a relying party identified by id, a domain
+func NewRelyingParty(id string, name string, origins []string) *RelyingParty {
+	return &RelyingParty{id, name, origins}
+}
+
+// Registration is a credential created by an authenticator
+type Registration struct {
+	CredentialID []byte
+	// PublicKey is the COSE encoded public key of the credential
+	PublicKey    []byte
+	SignCount    uint32
+	UserVerified bool
+}
+
+// Assertion is a successful login with a credential
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+// clientData is the JSON the browser collects and the authenticator signs
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// authenticatorData is the authenticator part of the signed data
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	// credentialID and publicKey are only set on registration
+	credentialID []byte
+	publicKey    []byte
+}
+
+// NewChallenge returns a random challenge of a ceremony, it must be kept on
+// the session and used once
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// VerifyRegistration checks the answer of navigator.credentials.create for
+// challenge. Attestation isn't requested, so any attestation statement is
+// taken as "none" and left unverified.
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, clientDataJSON []byte, attestationObject []byte) (*Registration, error) {
+	if err := rp.verifyClientData(clientDataJSON, ceremonyCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	value, rest, err := decodeCBOR(attestationObject)
+	if err != nil || len(rest) > 0 {
+		return nil, ErrInvalidCredential
+	}
+	attestation, _ := value.(map[interface{}]interface{})
+	rawAuthData, _ := attestation["authData"].([]byte)
+
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.credentialID == nil {
+		return nil, ErrInvalidCredential
+	}
+
+	return &Registration{
+		CredentialID: authData.credentialID,
+		PublicKey:    authData.publicKey,
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&flagUserVerified != 0,
+	}, nil
+}
+
+// VerifyAssertion checks the answer of navigator.credentials.get for challenge
+// was signed by the credential with publicKey. The sign count returned must
+// be checked against the one kept for the credential.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, publicKey []byte, clientDataJSON []byte,
+	rawAuthData []byte, signature []byte) (*Assertion, error) {
+	if err := rp.verifyClientData(clientDataJSON, ceremonyGet, challenge); err != nil {
+		return nil, err
+	}
+
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	key, _, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	if err = key.verify(signed, signature); err != nil {
+		return nil, err
+	}
+
+	return &Assertion{
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&flagUserVerified != 0,
+	}, nil
+}
+
+// verifyClientData checks the ceremony, the challenge and the origin the browser saw
+func (rp *RelyingParty) verifyClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return ErrInvalidCredential
+	}
+	if data.Type != ceremony || data.CrossOrigin || len(challenge) == 0 {
+		return ErrInvalidCredential
+	}
+
+	received, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(data.Challenge, "="))
+	if err != nil || subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return ErrInvalidCredential
+	}
+
+	for _, origin := range rp.Origins {
+		if data.Origin == origin {
+			return nil
+		}
+	}
+	return ErrInvalidCredential
+}
+
+// parseAuthenticatorData checks the authenticator data was made for this
+// relying party with the user present
+// https://www.w3.org/TR/webauthn/#sctn-authenticator-data
+func (rp *RelyingParty) parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < authDataMinSize {
+		return nil, ErrInvalidCredential
+	}
+	authData := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(authData.rpIDHash, rpIDHash[:]) != 1 {
+		return nil, ErrInvalidCredential
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return nil, ErrInvalidCredential
+	}
+
+	rest := data[authDataMinSize:]
+	if authData.flags&flagAttestedData != 0 {
+		// aaguid, credential id length, credential id and public key
+		if len(rest) < 18 {
+			return nil, ErrInvalidCredential
+		}
+		size := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if size == 0 || size > maxCredentialIDSize || len(rest) < size {
+			return nil, ErrInvalidCredential
+		}
+		authData.credentialID = append([]byte(nil), rest[:size]...)
+		rest = rest[size:]
+
+		_, afterKey, err := parseCOSEKey(rest)
+		if err != nil {
+			return nil, err
+		}
+		authData.publicKey = append([]byte(nil), rest[:len(rest)-len(afterKey)]...)
+		rest = afterKey
+	}
+	if authData.flags&flagExtensionIncluded != 0 {
+		var err error
+		if _, rest, err = decodeCBOR(rest); err != nil {
+			return nil, ErrInvalidCredential
+		}
+	}
+	if len(rest) > 0 {
+		return nil, ErrInvalidCredential
+	}
+	return authData, nil
+}
@@ -0,0 +1,339 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"sort"
+	"testing"
+)
+
+const (
+	testRPID   = "condominio.example"
+	testOrigin = "https://condominio.example"
+)
+
+// softAuthenticator is an authenticator kept on memory, so the ceremonies
+// can be tested without special hardware
+type softAuthenticator struct {
+	rpID         string
+	origin       string
+	credentialID []byte
+	key          crypto.Signer
+	signCount    uint32
+	// flags are set on every authenticator data
+	flags byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("can't generate key: %v", err)
+	}
+	credentialID := make([]byte, 16)
+	rand.Read(credentialID)
+	return &softAuthenticator{testRPID, testOrigin, credentialID, key, 0, flagUserPresent | flagUserVerified}
+}
+
+// create answers navigator.credentials.create
+func (a *softAuthenticator) create(challenge []byte) (clientDataJSON []byte, attestationObject []byte) {
+	clientDataJSON = a.clientData(ceremonyCreate, challenge)
+
+	attested := make([]byte, 18)
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, a.coseKey()...)
+
+	authData := a.authData(flagAttestedData, attested)
+	attestationObject = encodeCBOR(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": authData,
+	})
+	return clientDataJSON, attestationObject
+}
+
+// get answers navigator.credentials.get
+func (a *softAuthenticator) get(challenge []byte) (clientDataJSON []byte, authData []byte, signature []byte) {
+	a.signCount++
+	clientDataJSON = a.clientData(ceremonyGet, challenge)
+	authData = a.authData(0, nil)
+	return clientDataJSON, authData, a.sign(authData, clientDataJSON)
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, _ := json.Marshal(clientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    a.origin,
+	})
+	return data
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], a.flags|flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) sign(authData []byte, clientDataJSON []byte) []byte {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := a.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		panic(err)
+	}
+	return signature
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	switch key := a.key.Public().(type) {
+	case *ecdsa.PublicKey:
+		return encodeCBOR(map[interface{}]interface{}{
+			int64(coseKeyType):   int64(coseKeyTypeEC2),
+			int64(coseAlgorithm): int64(AlgES256),
+			int64(coseEC2Curve):  int64(coseCurveP256),
+			int64(coseEC2X):      padded(key.X, 32),
+			int64(coseEC2Y):      padded(key.Y, 32),
+		})
+	case *rsa.PublicKey:
+		return encodeCBOR(map[interface{}]interface{}{
+			int64(coseKeyType):     int64(coseKeyTypeRSA),
+			int64(coseAlgorithm):   int64(AlgRS256),
+			int64(coseRSAModulus):  key.N.Bytes(),
+			int64(coseRSAExponent): big.NewInt(int64(key.E)).Bytes(),
+		})
+	}
+	return nil
+}
+
+func padded(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// encodeCBOR encodes the subset of CBOR decoded by decodeCBOR
+func encodeCBOR(value interface{}) []byte {
+	head := func(major byte, arg uint64) []byte {
+		switch {
+		case arg < 24:
+			return []byte{major<<5 | byte(arg)}
+		case arg <= 0xFF:
+			return []byte{major<<5 | 24, byte(arg)}
+		case arg <= 0xFFFF:
+			return []byte{major<<5 | 25, byte(arg >> 8), byte(arg)}
+		}
+		b := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(arg))
+		return b
+	}
+
+	switch v := value.(type) {
+	case int64:
+		if v < 0 {
+			return head(cborNegative, uint64(-1-v))
+		}
+		return head(cborUnsigned, uint64(v))
+	case []byte:
+		return append(head(cborBytes, uint64(len(v))), v...)
+	case string:
+		return append(head(cborText, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		// sorted for a deterministic encoding
+		encodedKeys := []string{}
+		items := map[string][]byte{}
+		for key, item := range v {
+			encoded := string(encodeCBOR(key))
+			encodedKeys = append(encodedKeys, encoded)
+			items[encoded] = encodeCBOR(item)
+		}
+		sort.Strings(encodedKeys)
+		b := head(cborMap, uint64(len(v)))
+		for _, key := range encodedKeys {
+			b = append(append(b, key...), items[key]...)
+		}
+		return b
+	}
+	panic("can't encode value")
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("can't generate key: %v", err)
+	}
+	rsaAuthenticator := newSoftAuthenticator(t)
+	rsaAuthenticator.key = rsaKey
+
+	cases := []struct {
+		name          string
+		authenticator *softAuthenticator
+	}{
+		{"ES256", newSoftAuthenticator(t)},
+		{"RS256", rsaAuthenticator},
+	}
+
+	rp := NewRelyingParty(testRPID, "Condomínio", []string{testOrigin})
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			challenge, _ := NewChallenge()
+			clientDataJSON, attestationObject := c.authenticator.create(challenge)
+			registration, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+			if err != nil {
+				t.Fatalf("err should be nil instead of %q", err)
+			}
+			if !bytes.Equal(registration.CredentialID, c.authenticator.credentialID) {
+				t.Errorf("credential id should be %x instead of %x", c.authenticator.credentialID, registration.CredentialID)
+			}
+			if !bytes.Equal(registration.PublicKey, c.authenticator.coseKey()) {
+				t.Errorf("public key should be %x instead of %x", c.authenticator.coseKey(), registration.PublicKey)
+			}
+			if !registration.UserVerified {
+				t.Error("the user should be verified")
+			}
+
+			challenge, _ = NewChallenge()
+			clientDataJSON, authData, signature := c.authenticator.get(challenge)
+			assertion, err := rp.VerifyAssertion(challenge, registration.PublicKey, clientDataJSON, authData, signature)
+			if err != nil {
+				t.Fatalf("err should be nil instead of %q", err)
+			}
+			if assertion.SignCount != 1 || !assertion.UserVerified {
+				t.Errorf("sign count should be 1 and the user verified instead of %d, %v",
+					assertion.SignCount, assertion.UserVerified)
+			}
+		})
+	}
+}
+
+func TestInvalidRegistration(t *testing.T) {
+	rp := NewRelyingParty(testRPID, "Condomínio", []string{testOrigin})
+	challenge, _ := NewChallenge()
+
+	cases := []struct {
+		name   string
+		answer func(a *softAuthenticator) ([]byte, []byte)
+	}{{
+		name: "OtherChallenge",
+		answer: func(a *softAuthenticator) ([]byte, []byte) {
+			other, _ := NewChallenge()
+			return a.create(other)
+		},
+	}, {
+		name: "OtherOrigin",
+		answer: func(a *softAuthenticator) ([]byte, []byte) {
+			a.origin = "https://evil.example"
+			return a.create(challenge)
+		},
+	}, {
+		name: "OtherRelyingParty",
+		answer: func(a *softAuthenticator) ([]byte, []byte) {
+			a.rpID = "evil.example"
+			return a.create(challenge)
+		},
+	}, {
+		name: "UserNotPresent",
+		answer: func(a *softAuthenticator) ([]byte, []byte) {
+			a.flags = 0
+			return a.create(challenge)
+		},
+	}, {
+		name: "LoginClientData",
+		answer: func(a *softAuthenticator) ([]byte, []byte) {
+			_, attestationObject := a.create(challenge)
+			return a.clientData(ceremonyGet, challenge), attestationObject
+		},
+	}, {
+		name: "NoAttestedCredential",
+		answer: func(a *softAuthenticator) ([]byte, []byte) {
+			clientDataJSON, _ := a.create(challenge)
+			return clientDataJSON, encodeCBOR(map[interface{}]interface{}{
+				"fmt":      "none",
+				"attStmt":  map[interface{}]interface{}{},
+				"authData": a.authData(0, nil),
+			})
+		},
+	}, {
+		name: "TrailingBytes",
+		answer: func(a *softAuthenticator) ([]byte, []byte) {
+			clientDataJSON, attestationObject := a.create(challenge)
+			return clientDataJSON, append(attestationObject, 0)
+		},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clientDataJSON, attestationObject := c.answer(newSoftAuthenticator(t))
+			if _, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject); err != ErrInvalidCredential {
+				t.Errorf("err should be %q instead of %v", ErrInvalidCredential, err)
+			}
+		})
+	}
+}
+
+func TestInvalidAssertion(t *testing.T) {
+	rp := NewRelyingParty(testRPID, "Condomínio", []string{testOrigin})
+	authenticator := newSoftAuthenticator(t)
+	publicKey := authenticator.coseKey()
+	challenge, _ := NewChallenge()
+
+	cases := []struct {
+		name     string
+		expected error
+		answer   func() ([]byte, []byte, []byte)
+	}{{
+		name:     "OtherChallenge",
+		expected: ErrInvalidCredential,
+		answer: func() ([]byte, []byte, []byte) {
+			other, _ := NewChallenge()
+			return authenticator.get(other)
+		},
+	}, {
+		name:     "RegistrationClientData",
+		expected: ErrInvalidCredential,
+		answer: func() ([]byte, []byte, []byte) {
+			_, authData, signature := authenticator.get(challenge)
+			return authenticator.clientData(ceremonyCreate, challenge), authData, signature
+		},
+	}, {
+		name:     "TamperedAuthData",
+		expected: ErrInvalidSignature,
+		answer: func() ([]byte, []byte, []byte) {
+			clientDataJSON, authData, signature := authenticator.get(challenge)
+			authData[36]++
+			return clientDataJSON, authData, signature
+		},
+	}, {
+		name:     "OtherKey",
+		expected: ErrInvalidSignature,
+		answer: func() ([]byte, []byte, []byte) {
+			return newSoftAuthenticator(t).get(challenge)
+		},
+	}, {
+		name:     "MalformedSignature",
+		expected: ErrInvalidSignature,
+		answer: func() ([]byte, []byte, []byte) {
+			clientDataJSON, authData, _ := authenticator.get(challenge)
+			return clientDataJSON, authData, []byte("signature")
+		},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clientDataJSON, authData, signature := c.answer()
+			_, err := rp.VerifyAssertion(challenge, publicKey, clientDataJSON, authData, signature)
+			if err != c.expected {
+				t.Errorf("err should be %q instead of %v", c.expected, err)
+			}
+		})
+	}
+}